@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
@@ -12,11 +13,15 @@ import (
 	"github.com/rs/zerolog"
 
 	"nodeimage/api/internal/cache"
+	"nodeimage/api/internal/captcha"
 	"nodeimage/api/internal/config"
 	"nodeimage/api/internal/database"
 	"nodeimage/api/internal/handlers"
 	"nodeimage/api/internal/jobs"
 	"nodeimage/api/internal/log"
+	"nodeimage/api/internal/mailer"
+	"nodeimage/api/internal/repository"
+	"nodeimage/api/internal/security"
 	"nodeimage/api/internal/server"
 	"nodeimage/api/internal/storage"
 )
@@ -49,7 +54,22 @@ func main() {
 		logger.Warn().Err(err).Msg("ensure buckets failed")
 	}
 
-	handlerSet := handlers.NewHandlerSet(logger, dbPool, redisClient, objectStore, cfg)
+	mail, err := mailer.New(cfg.Mail, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to init mailer")
+	}
+
+	captchaVerifier, err := captcha.New(cfg.Captcha, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to init captcha verifier")
+	}
+
+	keys, err := loadKeySet(cfg.Security)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to load jwt keys")
+	}
+
+	handlerSet := handlers.NewHandlerSet(logger, dbPool, redisClient, objectStore, mail, captchaVerifier, keys, cfg)
 	httpServer := server.NewHTTPServer(cfg, logger, handlerSet)
 
 	scheduler := jobs.NewScheduler(redisClient, logger)
@@ -57,6 +77,11 @@ func main() {
 		logger.Error().Err(err).Msg("scheduler start failed")
 	}
 
+	hostname, _ := os.Hostname()
+	statusCtx, stopStatus := context.WithCancel(ctx)
+	defer stopStatus()
+	go jobs.NewStatusConsumer(redisClient, repository.NewImageRepository(dbPool), "api-"+hostname, logger).Run(statusCtx)
+
 	go func() {
 		if err := httpServer.Start(); err != nil {
 			logger.Fatal().Err(err).Msg("http server failed")
@@ -66,6 +91,28 @@ func main() {
 	waitForShutdown(logger, httpServer, scheduler, dbPool, redisClient)
 }
 
+func loadKeySet(cfg config.SecurityConfig) (*security.KeySet, error) {
+	specs := make([]security.KeySpec, 0, len(cfg.JWTKeys))
+	for _, key := range cfg.JWTKeys {
+		spec := security.KeySpec{
+			ID:         key.ID,
+			Algorithm:  key.Algorithm,
+			Secret:     key.Secret,
+			ActiveFrom: key.ActiveFrom,
+			RetireAt:   key.RetireAt,
+		}
+		if key.PrivateKeyFile != "" {
+			data, err := os.ReadFile(key.PrivateKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %q: %w", key.ID, err)
+			}
+			spec.PrivateKeyPEM = data
+		}
+		specs = append(specs, spec)
+	}
+	return security.NewKeySet(specs, cfg.JWTAccessSecret)
+}
+
 func waitForShutdown(logger zerolog.Logger, srv *server.HTTPServer, scheduler *jobs.Scheduler, db *pgxpool.Pool, redisClient *redis.Client) {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -0,0 +1,33 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"nodeimage/api/internal/config"
+)
+
+var ErrInvalidToken = errors.New("invalid captcha token")
+
+// Verifier checks a CAPTCHA response token produced by the frontend widget.
+// Implementations must be safe for concurrent use.
+type Verifier interface {
+	Verify(ctx context.Context, token string, remoteIP string) error
+}
+
+// New builds the verifier selected by cfg.Driver: "none" or "siteverify".
+// A nil Verifier means CAPTCHA is disabled.
+func New(cfg config.CaptchaConfig, log zerolog.Logger) (Verifier, error) {
+	switch strings.ToLower(cfg.Driver) {
+	case "", "none":
+		return nil, nil
+	case "siteverify":
+		return NewSiteVerify(cfg, log)
+	default:
+		return nil, fmt.Errorf("unknown captcha driver %q", cfg.Driver)
+	}
+}
@@ -0,0 +1,77 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"nodeimage/api/internal/config"
+)
+
+// SiteVerify speaks the siteverify protocol shared by Cloudflare Turnstile,
+// hCaptcha and reCAPTCHA: POST secret, response and remoteip as a form and
+// read {"success": bool}.
+type SiteVerify struct {
+	url    string
+	secret string
+	client *http.Client
+	log    zerolog.Logger
+}
+
+func NewSiteVerify(cfg config.CaptchaConfig, log zerolog.Logger) (*SiteVerify, error) {
+	if cfg.VerifyURL == "" || cfg.Secret == "" {
+		return nil, fmt.Errorf("captcha verifyURL and secret required")
+	}
+	return &SiteVerify{
+		url:    cfg.VerifyURL,
+		secret: cfg.Secret,
+		client: &http.Client{Timeout: cfg.Timeout},
+		log:    log,
+	}, nil
+}
+
+func (v *SiteVerify) Verify(ctx context.Context, token string, remoteIP string) error {
+	if token == "" {
+		return ErrInvalidToken
+	}
+
+	form := url.Values{
+		"secret":   {v.secret},
+		"response": {token},
+	}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("captcha verify: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("captcha verify: status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("captcha verify: %w", err)
+	}
+	if !result.Success {
+		v.log.Debug().Strs("error_codes", result.ErrorCodes).Msg("captcha rejected")
+		return ErrInvalidToken
+	}
+	return nil
+}
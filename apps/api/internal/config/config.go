@@ -15,11 +15,13 @@ type TLSConfig struct {
 }
 
 type HTTPConfig struct {
-	Host         string
-	Port         int
-	ReadTimeout  time.Duration
-	WriteTimeout time.Duration
-	IdleTimeout  time.Duration
+	Host           string
+	Port           int
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	IdleTimeout    time.Duration
+	IdempotencyTTL time.Duration
+	MaxBodyBytes   int64
 }
 
 type PostgresConfig struct {
@@ -46,12 +48,159 @@ type StorageConfig struct {
 }
 
 type SecurityConfig struct {
-	JWTAccessSecret  string
-	JWTRefreshSecret string
-	JWTAccessTTL     time.Duration
-	JWTRefreshTTL    time.Duration
-	SignatureSecret  string
-	MaxSessions      int
+	JWTAccessSecret   string
+	JWTKeys           []JWTKeyConfig
+	JWTRefreshSecret  string
+	JWTAccessTTL      time.Duration
+	JWTRefreshTTL     time.Duration
+	SignatureSecret   string
+	ActionTokenSecret string
+	MaxSessions       int
+	MaxAPIKeys        int
+	RefreshCookie     RefreshCookieConfig
+	EmailVerification EmailVerificationConfig
+	PasswordReset     PasswordResetConfig
+	MFA               MFAConfig
+	WebAuthn          WebAuthnConfig
+	OIDC              OIDCConfig
+	LoginThrottle     LoginThrottleConfig
+}
+
+// JWTKeyConfig is one access-token signing key. Algorithm is HS512 (Secret),
+// EdDSA or ES256 (PrivateKeyFile, PEM). The key with the latest ActiveFrom
+// in the past signs; every key verifies until RetireAt.
+type JWTKeyConfig struct {
+	ID             string
+	Algorithm      string
+	Secret         string
+	PrivateKeyFile string
+	ActiveFrom     time.Time
+	RetireAt       time.Time
+}
+
+// RefreshCookieConfig controls delivering refresh tokens as an HttpOnly
+// cookie to clients that ask for it, so browser code never sees them.
+type RefreshCookieConfig struct {
+	Enabled  bool
+	Name     string
+	Domain   string
+	Path     string
+	Secure   bool
+	SameSite string
+}
+
+// EmailVerificationConfig makes registration create pending users that
+// must follow an emailed link before they can log in.
+type EmailVerificationConfig struct {
+	Required         bool
+	TokenTTL         time.Duration
+	LinkURL          string
+	ResendInterval   time.Duration
+	MaxResendsPerDay int
+}
+
+type PasswordResetConfig struct {
+	TokenTTL        time.Duration
+	LinkURL         string
+	RequestInterval time.Duration
+}
+
+// MFAConfig covers TOTP enrollment and the login challenge. Users whose role
+// is in RequiredRoles cannot reach admin routes without a second factor.
+// MaxAttempts caps guesses per challenge; MaxUserFailures caps them per user
+// across challenges within LockoutDuration.
+type MFAConfig struct {
+	Issuer          string
+	SecretKey       string
+	RequiredRoles   []string
+	ChallengeTTL    time.Duration
+	MaxAttempts     int
+	MaxUserFailures int
+	LockoutDuration time.Duration
+	RecoveryCodes   int
+}
+
+// WebAuthnConfig enables passkeys when RPID is set. RPOrigins must list
+// every origin the frontend is served from.
+type WebAuthnConfig struct {
+	RPID           string
+	RPDisplayName  string
+	RPOrigins      []string
+	ChallengeTTL   time.Duration
+	MaxCredentials int
+}
+
+// OIDCConfig is a single generic OpenID Connect provider, found through
+// IssuerURL discovery. RedirectURL is the frontend page that receives the
+// authorization code and posts it to /v1/auth/oidc/callback.
+type OIDCConfig struct {
+	Enabled      bool
+	Provider     string
+	DisplayName  string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	StateTTL     time.Duration
+	// AutoRegister creates accounts for verified emails that match no user.
+	AutoRegister bool
+}
+
+// LoginThrottleConfig limits password guessing. Failures are counted per
+// email and per client IP within Window; past the free attempts each failure
+// doubles the wait from BaseDelay up to MaxDelay. LockoutThreshold failures
+// on one email lock it for LockoutDuration and notify the owner.
+type LoginThrottleConfig struct {
+	Window           time.Duration
+	FreeAttempts     int
+	IPFreeAttempts   int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	CaptchaAfter     int
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+type CaptchaConfig struct {
+	// Driver is "none" or "siteverify".
+	Driver    string
+	VerifyURL string
+	Secret    string
+	Timeout   time.Duration
+}
+
+type MailConfig struct {
+	// Driver is "smtp", "log" or "memory".
+	Driver string
+	From   string
+	SMTP   SMTPConfig
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+type UploadConfig struct {
+	MaxBatchFiles     int
+	BatchConcurrency  int
+	MaxFileBytes      int64
+	MaxInlineBytes    int
+	MaxWidth          int
+	MaxHeight         int
+	MaxPixels         int64
+	MaxFrames         int
+	ArchiveMaxEntries int
+	ArchiveMaxBytes   int64
+	ArchiveMaxDepth   int
+	MaxSVGBytes       int64
+	MaxSVGDepth       int
+	MaxSVGElements    int
+	AllowedFormats    []string
+	WorkerFormats     []string
 }
 
 type NSFWConfig struct {
@@ -69,6 +218,9 @@ type AppConfig struct {
 	Redis         RedisConfig
 	Storage       StorageConfig
 	Security      SecurityConfig
+	Mail          MailConfig
+	Captcha       CaptchaConfig
+	Upload        UploadConfig
 	NSFW          NSFWConfig
 	AllowCORSOrigins []string
 }
@@ -97,6 +249,7 @@ func Load() (*AppConfig, error) {
 		dc.TagName = "mapstructure"
 		dc.DecodeHook = mapstructure.ComposeDecodeHookFunc(
 			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToTimeHookFunc(time.RFC3339),
 			mapstructure.StringToSliceHookFunc(","),
 		)
 	}); err != nil {
@@ -114,6 +267,8 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("http.readtimeout", "10s")
 	v.SetDefault("http.writetimeout", "15s")
 	v.SetDefault("http.idletimeout", "60s")
+	v.SetDefault("http.idempotencyttl", "24h")
+	v.SetDefault("http.maxbodybytes", 1<<20)
 
 	v.SetDefault("postgres.maxopen", 30)
 	v.SetDefault("postgres.maxidle", 10)
@@ -130,6 +285,73 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("security.jwtaccessttl", "15m")
 	v.SetDefault("security.jwtrefreshttl", "720h") // 30 days
 	v.SetDefault("security.maxsessions", 10)
+	v.SetDefault("security.maxapikeys", 20)
+	v.SetDefault("security.refreshcookie.enabled", false)
+	v.SetDefault("security.refreshcookie.name", "ni_refresh")
+	v.SetDefault("security.refreshcookie.path", "/v1/auth")
+	v.SetDefault("security.refreshcookie.secure", true)
+	v.SetDefault("security.refreshcookie.samesite", "strict")
+	v.SetDefault("security.emailverification.required", false)
+	v.SetDefault("security.emailverification.tokenttl", "24h")
+	v.SetDefault("security.emailverification.linkurl", "https://www.nodeimage.com/verify-email")
+	v.SetDefault("security.emailverification.resendinterval", "1m")
+	v.SetDefault("security.emailverification.maxresendsperday", 5)
+
+	v.SetDefault("security.passwordreset.tokenttl", "30m")
+	v.SetDefault("security.passwordreset.linkurl", "https://www.nodeimage.com/reset-password")
+	v.SetDefault("security.passwordreset.requestinterval", "1m")
+
+	v.SetDefault("security.mfa.issuer", "NodeImage")
+	v.SetDefault("security.mfa.requiredroles", []string{"admin", "superadmin"})
+	v.SetDefault("security.mfa.challengettl", "5m")
+	v.SetDefault("security.mfa.maxattempts", 5)
+	v.SetDefault("security.mfa.maxuserfailures", 10)
+	v.SetDefault("security.mfa.lockoutduration", "15m")
+	v.SetDefault("security.mfa.recoverycodes", 10)
+
+	v.SetDefault("security.webauthn.rpdisplayname", "NodeImage")
+	v.SetDefault("security.webauthn.challengettl", "5m")
+	v.SetDefault("security.webauthn.maxcredentials", 10)
+
+	v.SetDefault("security.oidc.enabled", false)
+	v.SetDefault("security.oidc.provider", "oidc")
+	v.SetDefault("security.oidc.displayname", "SSO")
+	v.SetDefault("security.oidc.scopes", []string{"openid", "email", "profile"})
+	v.SetDefault("security.oidc.statettl", "10m")
+	v.SetDefault("security.oidc.autoregister", false)
+
+	v.SetDefault("security.loginthrottle.window", "15m")
+	v.SetDefault("security.loginthrottle.freeattempts", 3)
+	v.SetDefault("security.loginthrottle.ipfreeattempts", 20)
+	v.SetDefault("security.loginthrottle.basedelay", "1s")
+	v.SetDefault("security.loginthrottle.maxdelay", "5m")
+	v.SetDefault("security.loginthrottle.captchaafter", 3)
+	v.SetDefault("security.loginthrottle.lockoutthreshold", 10)
+	v.SetDefault("security.loginthrottle.lockoutduration", "15m")
+
+	v.SetDefault("captcha.driver", "none")
+	v.SetDefault("captcha.timeout", "5s")
+
+	v.SetDefault("mail.driver", "log")
+	v.SetDefault("mail.from", "NodeImage <no-reply@nodeimage.com>")
+	v.SetDefault("mail.smtp.port", 587)
+
+	v.SetDefault("upload.maxbatchfiles", 20)
+	v.SetDefault("upload.batchconcurrency", 4)
+	v.SetDefault("upload.maxfilebytes", 50<<20)
+	v.SetDefault("upload.maxinlinebytes", 10<<20)
+	v.SetDefault("upload.maxwidth", 16384)
+	v.SetDefault("upload.maxheight", 16384)
+	v.SetDefault("upload.maxpixels", 100_000_000)
+	v.SetDefault("upload.maxframes", 1000)
+	v.SetDefault("upload.archivemaxentries", 500)
+	v.SetDefault("upload.archivemaxbytes", 256<<20)
+	v.SetDefault("upload.archivemaxdepth", 2)
+	v.SetDefault("upload.maxsvgbytes", 5<<20)
+	v.SetDefault("upload.maxsvgdepth", 64)
+	v.SetDefault("upload.maxsvgelements", 50_000)
+	v.SetDefault("upload.allowedformats", []string{"jpeg", "png", "apng", "gif", "webp", "avif", "svg", "bmp", "tiff", "ico", "heic", "heif", "jxl"})
+	v.SetDefault("upload.workerformats", []string{"jpeg", "png", "apng", "gif", "webp", "avif", "svg", "bmp", "tiff", "ico"})
 
 	v.SetDefault("nsfw.thresholdblock", 0.92)
 	v.SetDefault("nsfw.thresholdreview", 0.75)
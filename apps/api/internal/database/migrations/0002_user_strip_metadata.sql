@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN strip_metadata BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN IF EXISTS strip_metadata;
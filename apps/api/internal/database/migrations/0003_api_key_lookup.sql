@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE api_keys ADD COLUMN key_prefix TEXT NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX idx_api_keys_hash ON api_keys (key_hash);
+CREATE INDEX idx_api_keys_user ON api_keys (user_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_api_keys_user;
+DROP INDEX IF EXISTS idx_api_keys_hash;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS key_prefix;
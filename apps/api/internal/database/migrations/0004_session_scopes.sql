@@ -0,0 +1,6 @@
+-- +goose Up
+-- NULL keeps the session unrestricted: it gets every scope its user's role grants.
+ALTER TABLE user_sessions ADD COLUMN scopes TEXT[];
+
+-- +goose Down
+ALTER TABLE user_sessions DROP COLUMN IF EXISTS scopes;
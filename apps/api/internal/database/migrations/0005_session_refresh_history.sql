@@ -0,0 +1,17 @@
+-- +goose Up
+-- Refresh token hashes a session has rotated away from. Presenting one again
+-- means the token was copied, so the session it belonged to is revoked.
+CREATE TABLE session_refresh_history (
+    refresh_token_hash  BYTEA PRIMARY KEY,
+    session_id          CHAR(27) NOT NULL,
+    user_id             CHAR(27) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id           VARCHAR(64) NOT NULL,
+    rotated_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at          TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_session_refresh_history_session ON session_refresh_history (session_id);
+CREATE INDEX idx_session_refresh_history_user ON session_refresh_history (user_id, expires_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS session_refresh_history;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE password_resets (
+    id          CHAR(27) PRIMARY KEY,
+    user_id     CHAR(27) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash  BYTEA NOT NULL UNIQUE,
+    expires_at  TIMESTAMPTZ NOT NULL,
+    used_at     TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_password_resets_user ON password_resets (user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS password_resets;
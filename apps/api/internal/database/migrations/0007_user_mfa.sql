@@ -0,0 +1,28 @@
+-- +goose Up
+CREATE TABLE user_mfa (
+    user_id         CHAR(27) PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    -- AES-GCM sealed with security.mfa.secretKey
+    totp_secret     BYTEA NOT NULL,
+    enabled_at      TIMESTAMPTZ,
+    last_used_step  BIGINT NOT NULL DEFAULT 0,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE mfa_recovery_codes (
+    id          CHAR(27) PRIMARY KEY,
+    user_id     CHAR(27) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash   BYTEA NOT NULL,
+    used_at     TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, code_hash)
+);
+
+-- Sessions remember whether login passed a second factor, so refreshed
+-- access tokens keep the claim.
+ALTER TABLE user_sessions ADD COLUMN mfa BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE user_sessions DROP COLUMN IF EXISTS mfa;
+DROP TABLE IF EXISTS mfa_recovery_codes;
+DROP TABLE IF EXISTS user_mfa;
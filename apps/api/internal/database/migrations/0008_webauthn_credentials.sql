@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE webauthn_credentials (
+    id               CHAR(27) PRIMARY KEY,
+    user_id          CHAR(27) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    credential_id    BYTEA NOT NULL UNIQUE,
+    public_key       BYTEA NOT NULL,
+    name             TEXT NOT NULL,
+    attestation_type TEXT NOT NULL DEFAULT '',
+    transports       TEXT[] NOT NULL DEFAULT '{}',
+    aaguid           BYTEA,
+    sign_count       BIGINT NOT NULL DEFAULT 0,
+    backup_eligible  BOOLEAN NOT NULL DEFAULT FALSE,
+    backup_state     BOOLEAN NOT NULL DEFAULT FALSE,
+    -- set when an assertion arrives with a counter that did not advance
+    clone_warning    BOOLEAN NOT NULL DEFAULT FALSE,
+    last_used_at     TIMESTAMPTZ,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_webauthn_credentials_user ON webauthn_credentials(user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS webauthn_credentials;
@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE external_identities (
+    id             CHAR(27) PRIMARY KEY,
+    user_id        CHAR(27) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider       TEXT NOT NULL,
+    subject        TEXT NOT NULL,
+    email          TEXT NOT NULL DEFAULT '',
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_login_at  TIMESTAMPTZ,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX idx_external_identities_user ON external_identities(user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS external_identities;
@@ -0,0 +1,8 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+ALTER TYPE image_status ADD VALUE IF NOT EXISTS 'failed';
+
+-- +goose Down
+-- PostgreSQL cannot drop an enum value; put failed images back so older
+-- code never reads a status it does not know.
+UPDATE images SET status = 'processing' WHERE status = 'failed';
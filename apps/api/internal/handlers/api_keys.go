@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nodeimage/api/internal/models"
+	"nodeimage/api/internal/repository"
+	"nodeimage/api/internal/security"
+	"nodeimage/api/internal/service"
+)
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name" binding:"required,max=100"`
+	Scopes []string `json:"scopes"`
+}
+
+type apiKeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type createAPIKeyResponse struct {
+	apiKeyResponse
+	Key string `json:"key"`
+}
+
+func (h HandlerSet) CreateAPIKey(c *gin.Context) {
+	userVal, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	user, ok := userVal.(models.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_user"})
+		return
+	}
+
+	claimsVal, _ := c.Get("access_claims")
+	claims, ok := claimsVal.(security.AccessClaims)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_claims"})
+		return
+	}
+
+	var req createAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := h.apiKeyService.Create(c.Request.Context(), service.CreateAPIKeyInput{
+		UserID:  user.ID,
+		Name:    req.Name,
+		Scopes:  req.Scopes,
+		Allowed: claims.Scopes,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrAPIKeyLimit) {
+			c.JSON(http.StatusConflict, gin.H{"error": "api_key_limit"})
+			return
+		}
+		if errors.Is(err, service.ErrInvalidScope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, createAPIKeyResponse{
+		apiKeyResponse: newAPIKeyResponse(created.APIKey),
+		Key:            created.Key,
+	})
+}
+
+func (h HandlerSet) ListAPIKeys(c *gin.Context) {
+	userVal, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	user, ok := userVal.(models.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_user"})
+		return
+	}
+
+	keys, err := h.apiKeyService.List(c.Request.Context(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := make([]apiKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		resp = append(resp, newAPIKeyResponse(key))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"apiKeys": resp})
+}
+
+func (h HandlerSet) RevokeAPIKey(c *gin.Context) {
+	userVal, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	user, ok := userVal.(models.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_user"})
+		return
+	}
+
+	if err := h.apiKeyService.Revoke(c.Request.Context(), user.ID, c.Param("id")); err != nil {
+		if errors.Is(err, repository.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "api_key_not_found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func newAPIKeyResponse(key models.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.KeyPrefix,
+		Scopes:     key.Scopes,
+		LastUsedAt: key.LastUsedAt,
+		CreatedAt:  key.CreatedAt,
+	}
+}
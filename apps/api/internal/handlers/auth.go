@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"errors"
+	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,20 +25,21 @@ type registerRequest struct {
 
 type authResponse struct {
 	AccessToken  string        `json:"accessToken"`
-	RefreshToken string        `json:"refreshToken"`
+	RefreshToken string        `json:"refreshToken,omitempty"`
 	DeviceID     string        `json:"deviceId"`
 	User         userResponse  `json:"user"`
 }
 
 type userResponse struct {
-	ID          string `json:"id"`
-	Email       string `json:"email"`
-	DisplayName string `json:"displayName"`
-	Role        string `json:"role"`
-	Status      string `json:"status"`
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	DisplayName   string `json:"displayName"`
+	Role          string `json:"role"`
+	Status        string `json:"status"`
+	StripMetadata bool   `json:"stripMetadata"`
 }
 
-func (h HandlerSet) Register(c *gin.Context) {
+func (h HandlerSet) RegisterUser(c *gin.Context) {
 	var req registerRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -51,14 +56,24 @@ func (h HandlerSet) Register(c *gin.Context) {
 		return
 	}
 
-	sendAuthResponse(c, result)
+	if result.PendingVerification {
+		c.JSON(http.StatusAccepted, gin.H{
+			"status": "pending_verification",
+			"user":   newUserResponse(result.User),
+		})
+		return
+	}
+
+	h.sendAuthResponse(c, result, h.wantsRefreshCookie(c))
 }
 
 type loginRequest struct {
 	Email      string `json:"email" binding:"required,email"`
 	Password   string `json:"password" binding:"required"`
-	DeviceID   string `json:"deviceId"`
-	DeviceName string `json:"deviceName"`
+	DeviceID   string   `json:"deviceId"`
+	DeviceName string   `json:"deviceName"`
+	Scopes     []string `json:"scopes"`
+	CaptchaToken string `json:"captchaToken"`
 }
 
 func (h HandlerSet) Login(c *gin.Context) {
@@ -69,83 +84,136 @@ func (h HandlerSet) Login(c *gin.Context) {
 	}
 
 	result, err := h.authService.Login(c.Request.Context(), service.LoginInput{
-		Email:      req.Email,
-		Password:   req.Password,
-		DeviceID:   req.DeviceID,
-		DeviceName: req.DeviceName,
-		IPAddress:  c.ClientIP(),
-		UserAgent:  c.GetHeader("User-Agent"),
+		Email:        req.Email,
+		Password:     req.Password,
+		DeviceID:     req.DeviceID,
+		DeviceName:   req.DeviceName,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.GetHeader("User-Agent"),
+		Scopes:       req.Scopes,
+		CaptchaToken: req.CaptchaToken,
 	})
 	if err != nil {
+		if errors.Is(err, service.ErrInvalidScope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope"})
+			return
+		}
+		var throttled *service.ThrottledError
+		if errors.As(err, &throttled) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too_many_attempts"})
+			return
+		}
+		if errors.Is(err, service.ErrCaptchaRequired) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "captcha_required"})
+			return
+		}
+		if errors.Is(err, service.ErrInvalidCaptcha) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid_captcha"})
+			return
+		}
 		status := http.StatusUnauthorized
-		if strings.Contains(err.Error(), "suspended") {
+		if strings.Contains(err.Error(), "suspended") || errors.Is(err, service.ErrEmailNotVerified) {
 			status = http.StatusForbidden
 		}
 		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
-	sendAuthResponse(c, result)
+	if result.MFARequired {
+		c.JSON(http.StatusAccepted, gin.H{
+			"mfaRequired": true,
+			"mfaToken":    result.MFAToken,
+		})
+		return
+	}
+
+	h.sendAuthResponse(c, result, h.wantsRefreshCookie(c))
 }
 
 type refreshRequest struct {
-	UserID       string `json:"userId" binding:"required"`
-	DeviceID     string `json:"deviceId" binding:"required"`
-	RefreshToken string `json:"refreshToken" binding:"required"`
+	RefreshToken string `json:"refreshToken"`
 }
 
+// Refresh takes the token from the body, falling back to the refresh cookie.
+// A token that arrived as a cookie is renewed as a cookie.
 func (h HandlerSet) Refresh(c *gin.Context) {
 	var req refreshRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	token, fromCookie := h.refreshTokenFromRequest(c, req.RefreshToken)
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing_refresh_token"})
+		return
+	}
+
 	result, err := h.authService.Refresh(c.Request.Context(), service.RefreshInput{
-		UserID:       req.UserID,
-		DeviceID:     req.DeviceID,
-		RefreshToken: req.RefreshToken,
+		RefreshToken: token,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.GetHeader("User-Agent"),
 	})
 	if err != nil {
+		if fromCookie {
+			h.clearRefreshCookie(c)
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
 
-	sendAuthResponse(c, result)
+	h.sendAuthResponse(c, result, fromCookie || h.wantsRefreshCookie(c))
 }
 
+// logoutRequest identifies the session by refresh token (body or cookie).
+// The route is unauthenticated, so nothing weaker than the token is accepted.
 type logoutRequest struct {
-	UserID   string `json:"userId" binding:"required"`
-	DeviceID string `json:"deviceId" binding:"required"`
+	RefreshToken string `json:"refreshToken"`
 }
 
 func (h HandlerSet) Logout(c *gin.Context) {
 	var req logoutRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := h.authService.Logout(c.Request.Context(), req.UserID, req.DeviceID); err != nil {
+	token, fromCookie := h.refreshTokenFromRequest(c, req.RefreshToken)
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing_refresh_token"})
+		return
+	}
+	if err := h.authService.LogoutToken(c.Request.Context(), token); err != nil {
+		if errors.Is(err, security.ErrInvalidRefreshToken) {
+			if fromCookie {
+				h.clearRefreshCookie(c)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_refresh_token"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	if h.cfg.Security.RefreshCookie.Enabled {
+		h.clearRefreshCookie(c)
+	}
 	c.Status(http.StatusNoContent)
 }
 
-func sendAuthResponse(c *gin.Context, result service.AuthResult) {
+// sendAuthResponse moves the refresh token out of the body and into the
+// HttpOnly cookie when asCookie is set.
+func (h HandlerSet) sendAuthResponse(c *gin.Context, result service.AuthResult, asCookie bool) {
 	resp := authResponse{
 		AccessToken:  result.AccessToken,
 		RefreshToken: result.RefreshToken,
 		DeviceID:     result.DeviceID,
-		User: userResponse{
-			ID:          result.User.ID,
-			Email:       result.User.Email,
-			DisplayName: result.User.DisplayName,
-			Role:        string(result.User.Role),
-			Status:      string(result.User.Status),
-		},
+		User:         newUserResponse(result.User),
+	}
+	if asCookie {
+		h.setRefreshCookie(c, result.RefreshToken, h.cfg.Security.JWTRefreshTTL)
+		resp.RefreshToken = ""
 	}
 
 	c.JSON(http.StatusOK, resp)
@@ -164,19 +232,57 @@ func (h HandlerSet) Me(c *gin.Context) {
 		return
 	}
 
-	resp := userResponse{
-		ID:          user.ID,
-		Email:       user.Email,
-		DisplayName: user.DisplayName,
-		Role:        string(user.Role),
-		Status:      string(user.Status),
+	c.JSON(http.StatusOK, gin.H{
+		"user": newUserResponse(user),
+	})
+}
+
+type updateMeRequest struct {
+	StripMetadata *bool `json:"stripMetadata"`
+}
+
+func (h HandlerSet) UpdateMe(c *gin.Context) {
+	userVal, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	user, ok := userVal.(models.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_user"})
+		return
+	}
+
+	var req updateMeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.StripMetadata != nil {
+		if err := h.users.UpdateStripMetadata(c.Request.Context(), user.ID, *req.StripMetadata); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		user.StripMetadata = *req.StripMetadata
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"user": resp,
+		"user": newUserResponse(user),
 	})
 }
 
+func newUserResponse(user models.User) userResponse {
+	return userResponse{
+		ID:            user.ID,
+		Email:         user.Email,
+		DisplayName:   user.DisplayName,
+		Role:          string(user.Role),
+		Status:        string(user.Status),
+		StripMetadata: user.StripMetadata,
+	}
+}
+
 type sessionResponse struct {
 	ID         string    `json:"id"`
 	DeviceID   string    `json:"deviceId"`
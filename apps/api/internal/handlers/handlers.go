@@ -6,10 +6,13 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
 
+	"nodeimage/api/internal/captcha"
 	"nodeimage/api/internal/config"
+	"nodeimage/api/internal/mailer"
 	"nodeimage/api/internal/middleware"
 	"nodeimage/api/internal/models"
 	"nodeimage/api/internal/repository"
+	"nodeimage/api/internal/security"
 	"nodeimage/api/internal/service"
 	"nodeimage/api/internal/storage"
 )
@@ -19,68 +22,143 @@ type HandlerSet struct {
 	cfg         *config.AppConfig
 	authService *service.AuthService
 	uploadService *service.UploadService
+	apiKeyService *service.APIKeyService
+	verificationService *service.VerificationService
+	passwordResetService *service.PasswordResetService
+	mfaService          *service.MFAService
+	passkeyService      *service.WebAuthnService
+	oidcService         *service.OIDCService
 	db          *pgxpool.Pool
 	cache       *redis.Client
 	store       *storage.ObjectStore
 	users       *repository.UserRepository
 	sessions    *repository.SessionRepository
 	images      *repository.ImageRepository
+	apiKeys     *repository.APIKeyRepository
+	keys        *security.KeySet
 }
 
-func NewHandlerSet(log zerolog.Logger, db *pgxpool.Pool, cache *redis.Client, store *storage.ObjectStore, cfg *config.AppConfig) HandlerSet {
+func NewHandlerSet(log zerolog.Logger, db *pgxpool.Pool, cache *redis.Client, store *storage.ObjectStore, mail mailer.Mailer, verifier captcha.Verifier, keys *security.KeySet, cfg *config.AppConfig) HandlerSet {
 	userRepo := repository.NewUserRepository(db)
 	sessionRepo := repository.NewSessionRepository(db)
 	imageRepo := repository.NewImageRepository(db)
-	auth := service.NewAuthService(userRepo, sessionRepo, cache, cfg, log)
+	apiKeyRepo := repository.NewAPIKeyRepository(db)
+	verification := service.NewVerificationService(userRepo, cache, mail, cfg, log)
+	passwordReset := service.NewPasswordResetService(userRepo, sessionRepo, repository.NewPasswordResetRepository(db), cache, mail, cfg, log)
+	mfa := service.NewMFAService(repository.NewMFARepository(db), sessionRepo, cache, cfg, log)
+	passkeys := service.NewWebAuthnService(repository.NewWebAuthnRepository(db), userRepo, mfa, cache, cfg, log)
+	oidc := service.NewOIDCService(userRepo, repository.NewExternalIdentityRepository(db), cache, cfg, log)
+	throttle := service.NewLoginThrottle(cache, verifier, mail, cfg, log)
+	auth := service.NewAuthService(userRepo, sessionRepo, verification, mfa, passkeys, oidc, throttle, keys, cache, cfg, log)
 	upload := service.NewUploadService(imageRepo, store, cache, cfg, log)
+	apiKeys := service.NewAPIKeyService(apiKeyRepo, userRepo, cfg, log)
 
 	return HandlerSet{
 		log:         log,
 		cfg:         cfg,
 		authService: auth,
 		uploadService: upload,
+		apiKeyService: apiKeys,
+		verificationService: verification,
+		passwordResetService: passwordReset,
+		mfaService:          mfa,
+		passkeyService:      passkeys,
+		oidcService:         oidc,
 		db:          db,
 		cache:       cache,
 		store:       store,
 		users:       userRepo,
 		sessions:    sessionRepo,
 		images:      imageRepo,
+		apiKeys:     apiKeyRepo,
+		keys:        keys,
 	}
 }
 
 func (h HandlerSet) Register(router *gin.RouterGroup) {
 	router.GET("/healthz", h.Health)
+	router.GET("/.well-known/jwks.json", h.JWKS)
 
 	v1 := router.Group("/v1")
 	{
-		auth := v1.Group("/auth")
-		auth.POST("/register", h.Register)
+		auth := v1.Group("/auth", middleware.BodyLimit(h.cfg.HTTP.MaxBodyBytes))
+		auth.POST("/register", h.RegisterUser)
 		auth.POST("/login", h.Login)
+		auth.POST("/login/mfa", h.LoginMFA)
+		auth.POST("/login/mfa/passkey/options", h.BeginMFAPasskey)
+		auth.POST("/login/mfa/passkey", h.LoginMFAPasskey)
+		auth.POST("/passkeys/login/options", h.BeginPasskeyLogin)
+		auth.POST("/passkeys/login", h.PasskeyLogin)
+		auth.GET("/oidc/authorize", h.OIDCAuthorize)
+		auth.POST("/oidc/callback", h.OIDCCallback)
 		auth.POST("/refresh", h.Refresh)
 		auth.POST("/logout", h.Logout)
+		auth.POST("/verify-email", h.VerifyEmail)
+		auth.POST("/verify-email/resend", h.ResendVerification)
+		auth.POST("/password/forgot", h.ForgotPassword)
+		auth.POST("/password/reset", h.ResetPassword)
 
 		protected := v1.Group("/auth")
 		protected.Use(
-			middleware.Auth(h.cfg, h.users, h.sessions),
+			middleware.BodyLimit(h.cfg.HTTP.MaxBodyBytes),
+			middleware.Auth(h.keys, h.users, h.sessions),
 			middleware.Signature(h.cfg, h.cache),
+			middleware.Idempotency(h.cache, h.cfg.HTTP.IdempotencyTTL),
 		)
 		protected.GET("/me", h.Me)
-		protected.GET("/sessions", h.ListSessions)
-		protected.DELETE("/sessions/:deviceId", h.RevokeSession)
+		protected.PATCH("/me", h.UpdateMe)
+
+		// Second factors and linked identities decide who can sign in, so
+		// they are guarded by the same scope as the sessions they create.
+		sessionScope := middleware.RequireScopes(security.ScopeSessionsManage)
+		protected.GET("/mfa", h.MFAStatus)
+		protected.POST("/mfa/totp/setup", sessionScope, h.SetupTOTP)
+		protected.POST("/mfa/totp/enable", sessionScope, h.EnableTOTP)
+		protected.POST("/mfa/totp/disable", sessionScope, h.DisableTOTP)
+		protected.POST("/mfa/recovery-codes", sessionScope, h.RegenerateRecoveryCodes)
+		protected.GET("/passkeys", sessionScope, h.ListPasskeys)
+		protected.POST("/passkeys/register/options", sessionScope, h.BeginPasskeyRegistration)
+		protected.POST("/passkeys/register", sessionScope, h.FinishPasskeyRegistration)
+		protected.DELETE("/passkeys/:id", sessionScope, h.DeletePasskey)
+		protected.GET("/identities", sessionScope, h.ListIdentities)
+		protected.DELETE("/identities/:id", sessionScope, h.UnlinkIdentity)
+		protected.GET("/sessions", sessionScope, h.ListSessions)
+		protected.DELETE("/sessions/:deviceId", sessionScope, h.RevokeSession)
+
+		keyScope := middleware.RequireScopes(security.ScopeAPIKeysManage)
+		protected.GET("/api-keys", keyScope, h.ListAPIKeys)
+		protected.POST("/api-keys", keyScope, h.CreateAPIKey)
+		protected.DELETE("/api-keys/:id", keyScope, h.RevokeAPIKey)
 	}
 
-	media := v1.Group("/media")
-	media.Use(
-		middleware.Auth(h.cfg, h.users, h.sessions),
-		middleware.Signature(h.cfg, h.cache),
-	)
-	media.POST("/upload", h.UploadMedia)
+	// Each upload route gets its own group so its body limit is in place
+	// before Signature and Idempotency buffer the body.
+	media := func(bodyLimit int64) *gin.RouterGroup {
+		group := v1.Group("/media")
+		group.Use(
+			middleware.BodyLimit(bodyLimit),
+			middleware.APIKey(h.apiKeyService),
+			middleware.Auth(h.keys, h.users, h.sessions),
+			middleware.Signature(h.cfg, h.cache),
+			middleware.Idempotency(h.cache, h.cfg.HTTP.IdempotencyTTL),
+		)
+		return group
+	}
+	mediaWrite := middleware.RequireScopes(security.ScopeMediaWrite)
+	media(h.multipartBodyLimit(1)).POST("/upload", mediaWrite, h.UploadMedia)
+	media(h.multipartBodyLimit(h.cfg.Upload.MaxBatchFiles)).POST("/upload/batch", mediaWrite, h.UploadMediaBatch)
+	media(h.inlineBodyLimit()).POST("/upload/inline", mediaWrite, h.UploadMediaInline)
+	media(h.archiveBodyLimit()).POST("/upload/archive", mediaWrite, h.UploadMediaArchive)
 
 	admin := v1.Group("/admin")
 	admin.Use(
-		middleware.Auth(h.cfg, h.users, h.sessions),
+		middleware.BodyLimit(h.cfg.HTTP.MaxBodyBytes),
+		middleware.APIKey(h.apiKeyService),
+		middleware.Auth(h.keys, h.users, h.sessions),
 		middleware.Signature(h.cfg, h.cache),
 		middleware.RequireRoles(models.UserRoleAdmin, models.UserRoleSuperAdmin),
+		middleware.RequireMFA(h.cfg.Security.MFA.RequiredRoles),
+		middleware.Idempotency(h.cache, h.cfg.HTTP.IdempotencyTTL),
 	)
-	admin.GET("/images", h.AdminListImages)
+	admin.GET("/images", middleware.RequireScopes(security.ScopeAdminImages), h.AdminListImages)
 }
@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"nodeimage/api/internal/media/archive"
+	"nodeimage/api/internal/media/datauri"
 	"nodeimage/api/internal/models"
 	"nodeimage/api/internal/security"
 	"nodeimage/api/internal/service"
@@ -16,71 +19,316 @@ type uploadResponse struct {
 	URL       string    `json:"url"`
 	Status    string    `json:"status"`
 	Format    string    `json:"format"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	Frames    int       `json:"frames"`
 	SizeBytes int64     `json:"sizeBytes"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
+type uploadErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type batchItemResponse struct {
+	Index    int                  `json:"index"`
+	Filename string               `json:"filename"`
+	Image    *uploadResponse      `json:"image,omitempty"`
+	Error    *uploadErrorResponse `json:"error,omitempty"`
+	Skipped  bool                 `json:"skipped,omitempty"`
+}
+
 func (h HandlerSet) UploadMedia(c *gin.Context) {
-	userVal, exists := c.Get("current_user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	user, claims, ok := uploaderFromContext(c)
+	if !ok {
 		return
 	}
-	user, ok := userVal.(models.User)
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		if isBodyTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request_too_large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file_required"})
+		return
+	}
+	defer file.Close()
+
+	result, err := h.uploadService.Upload(c.Request.Context(), service.UploadInput{
+		User:       user,
+		DeviceID:   claims.DeviceID,
+		File:       file,
+		Header:     header,
+		Visibility: c.PostForm("visibility"),
+		ExpireAt:   parseExpireAt(c),
+	})
+	if err != nil {
+		h.log.Error().Err(err).Str("user_id", user.ID).Msg("upload failed")
+		writeUploadError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"image": newUploadResponse(result),
+	})
+}
+
+type inlineUploadRequest struct {
+	Data       string     `json:"data" binding:"required"`
+	Visibility string     `json:"visibility"`
+	ExpireAt   *time.Time `json:"expireAt"`
+}
+
+func (h HandlerSet) UploadMediaInline(c *gin.Context) {
+	user, claims, ok := uploaderFromContext(c)
 	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_user"})
 		return
 	}
 
-	claimsVal, exists := c.Get("access_claims")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing_claims"})
+	var req inlineUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		if isBodyTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request_too_large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	claims, ok := claimsVal.(security.AccessClaims)
+
+	result, err := h.uploadService.UploadEncoded(c.Request.Context(), service.UploadEncodedInput{
+		User:       user,
+		DeviceID:   claims.DeviceID,
+		Payload:    req.Data,
+		Visibility: req.Visibility,
+		ExpireAt:   req.ExpireAt,
+	})
+	if err != nil {
+		h.log.Error().Err(err).Str("user_id", user.ID).Msg("inline upload failed")
+		writeUploadError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"image": newUploadResponse(result),
+	})
+}
+
+func (h HandlerSet) UploadMediaBatch(c *gin.Context) {
+	user, claims, ok := uploaderFromContext(c)
 	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_claims"})
 		return
 	}
 
-	file, header, err := c.Request.FormFile("file")
+	form, err := c.MultipartForm()
 	if err != nil {
+		if isBodyTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request_too_large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_multipart"})
+		return
+	}
+	files := form.File["file"]
+	if len(files) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "file_required"})
 		return
 	}
-	defer file.Close()
 
-	var expireAt *time.Time
-	if expires := c.PostForm("expireAt"); expires != "" {
-		if parsed, err := time.Parse(time.RFC3339, expires); err == nil {
-			expireAt = &parsed
+	results, err := h.uploadService.UploadBatch(c.Request.Context(), service.BatchUploadInput{
+		User:       user,
+		DeviceID:   claims.DeviceID,
+		Files:      files,
+		Visibility: c.PostForm("visibility"),
+		ExpireAt:   parseExpireAt(c),
+	})
+	if err != nil {
+		h.log.Warn().Err(err).Str("user_id", user.ID).Msg("batch upload rejected")
+		writeUploadError(c, err)
+		return
+	}
+
+	h.sendBatchReport(c, user, results)
+}
+
+func (h HandlerSet) UploadMediaArchive(c *gin.Context) {
+	user, claims, ok := uploaderFromContext(c)
+	if !ok {
+		return
+	}
+
+	file, _, err := c.Request.FormFile("file")
+	if err != nil {
+		if isBodyTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request_too_large"})
+			return
 		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file_required"})
+		return
 	}
+	defer file.Close()
 
-	result, err := h.uploadService.Upload(c.Request.Context(), service.UploadInput{
+	results, err := h.uploadService.UploadArchive(c.Request.Context(), service.ArchiveUploadInput{
 		User:       user,
 		DeviceID:   claims.DeviceID,
 		File:       file,
-		Header:     header,
 		Visibility: c.PostForm("visibility"),
-		ExpireAt:   expireAt,
+		ExpireAt:   parseExpireAt(c),
 	})
 	if err != nil {
-		h.log.Error().Err(err).Str("user_id", user.ID).Msg("upload failed")
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		h.log.Warn().Err(err).Str("user_id", user.ID).Msg("archive upload rejected")
+		writeUploadError(c, err)
 		return
 	}
 
-	resp := uploadResponse{
+	h.sendBatchReport(c, user, results)
+}
+
+func (h HandlerSet) sendBatchReport(c *gin.Context, user models.User, results []service.BatchItemResult) {
+	items := make([]batchItemResponse, 0, len(results))
+	succeeded, skipped := 0, 0
+	for _, item := range results {
+		resp := batchItemResponse{
+			Index:    item.Index,
+			Filename: item.Filename,
+			Skipped:  item.Skipped,
+		}
+		switch {
+		case item.Skipped:
+			skipped++
+			resp.Error = newUploadErrorResponse(item.Err)
+		case item.Err != nil:
+			h.log.Warn().Err(item.Err).Str("user_id", user.ID).Str("filename", item.Filename).Msg("batch item upload failed")
+			resp.Error = newUploadErrorResponse(item.Err)
+		default:
+			image := newUploadResponse(item.Result)
+			resp.Image = &image
+			succeeded++
+		}
+		items = append(items, resp)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":     items,
+		"succeeded": succeeded,
+		"skipped":   skipped,
+		"failed":    len(items) - succeeded - skipped,
+	})
+}
+
+func uploaderFromContext(c *gin.Context) (models.User, security.AccessClaims, bool) {
+	userVal, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return models.User{}, security.AccessClaims{}, false
+	}
+	user, ok := userVal.(models.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_user"})
+		return models.User{}, security.AccessClaims{}, false
+	}
+
+	claimsVal, exists := c.Get("access_claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing_claims"})
+		return models.User{}, security.AccessClaims{}, false
+	}
+	claims, ok := claimsVal.(security.AccessClaims)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_claims"})
+		return models.User{}, security.AccessClaims{}, false
+	}
+
+	return user, claims, true
+}
+
+func parseExpireAt(c *gin.Context) *time.Time {
+	expires := c.PostForm("expireAt")
+	if expires == "" {
+		return nil
+	}
+	parsed, err := time.Parse(time.RFC3339, expires)
+	if err != nil {
+		return nil
+	}
+	return &parsed
+}
+
+func newUploadResponse(result service.UploadResult) uploadResponse {
+	return uploadResponse{
 		ID:        result.Image.ID,
 		URL:       result.URL,
 		Status:    string(result.Image.Status),
 		Format:    result.Image.Format,
+		Width:     result.Image.Width,
+		Height:    result.Image.Height,
+		Frames:    result.Image.Frames,
 		SizeBytes: result.Image.SizeBytes,
 		CreatedAt: result.Image.CreatedAt,
 	}
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"image": resp,
-	})
+// newUploadErrorResponse reports err by code. Internal failures keep their
+// details in the log and only say internal_error to the client.
+func newUploadErrorResponse(err error) *uploadErrorResponse {
+	code := service.UploadErrorCode(err)
+	message := err.Error()
+	if code == service.UploadErrorInternal {
+		message = "internal error"
+	}
+	return &uploadErrorResponse{
+		Code:    code,
+		Message: message,
+	}
+}
+
+func writeUploadError(c *gin.Context, err error) {
+	resp := newUploadErrorResponse(err)
+	status := http.StatusBadRequest
+	switch {
+	case resp.Code == service.UploadErrorInternal:
+		status = http.StatusInternalServerError
+	case isBodyTooLarge(err), errors.Is(err, service.ErrFileTooLarge), errors.Is(err, datauri.ErrTooLarge),
+		errors.Is(err, archive.ErrTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	}
+	c.JSON(status, gin.H{"error": resp.Code, "message": resp.Message})
+}
+
+func isBodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+// inlineOverhead covers the JSON envelope and data URI header around the
+// encoded payload of an inline upload.
+const inlineOverhead = 64 << 10
+
+func (h HandlerSet) inlineBodyLimit() int64 {
+	if h.cfg.Upload.MaxInlineBytes <= 0 {
+		return 0
+	}
+	return datauri.MaxEncodedLen(h.cfg.Upload.MaxInlineBytes) + inlineOverhead
+}
+
+// multipartOverhead covers form fields and part headers on top of the file
+// bytes a multipart upload route accepts.
+const multipartOverhead = 1 << 20
+
+// multipartBodyLimit is the request body cap for a multipart upload of up to
+// files files. Zero means unlimited.
+func (h HandlerSet) multipartBodyLimit(files int) int64 {
+	if files <= 0 || h.cfg.Upload.MaxFileBytes <= 0 {
+		return 0
+	}
+	return int64(files)*h.cfg.Upload.MaxFileBytes + multipartOverhead
+}
+
+// archiveBodyLimit caps an archive upload at the size it may expand to.
+func (h HandlerSet) archiveBodyLimit() int64 {
+	if h.cfg.Upload.ArchiveMaxBytes <= 0 {
+		return 0
+	}
+	return h.cfg.Upload.ArchiveMaxBytes + multipartOverhead
 }
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"nodeimage/api/internal/models"
+	"nodeimage/api/internal/security"
+	"nodeimage/api/internal/service"
+)
+
+type loginMFARequest struct {
+	MFAToken string `json:"mfaToken" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+func (h HandlerSet) LoginMFA(c *gin.Context) {
+	var req loginMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.authService.LoginMFA(c.Request.Context(), service.LoginMFAInput{
+		MFAToken: req.MFAToken,
+		Code:     req.Code,
+	})
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	h.sendAuthResponse(c, result, h.wantsRefreshCookie(c))
+}
+
+type mfaStatusResponse struct {
+	Enabled                bool `json:"enabled"`
+	Required               bool `json:"required"`
+	RecoveryCodesRemaining int  `json:"recoveryCodesRemaining"`
+}
+
+func (h HandlerSet) MFAStatus(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	status, err := h.mfaService.Status(c.Request.Context(), user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, mfaStatusResponse{
+		Enabled:                status.Enabled,
+		Required:               status.Required,
+		RecoveryCodesRemaining: status.RecoveryCodesRemaining,
+	})
+}
+
+// SetupTOTP returns the secret and its otpauth:// URI for the client to show
+// as a QR code. Enrollment is not active until EnableTOTP.
+func (h HandlerSet) SetupTOTP(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	setup, err := h.mfaService.Setup(c.Request.Context(), user)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":          setup.Secret,
+		"provisioningUri": setup.URI,
+	})
+}
+
+type mfaCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+func (h HandlerSet) EnableTOTP(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claimsVal, _ := c.Get("access_claims")
+	claims, _ := claimsVal.(security.AccessClaims)
+
+	codes, err := h.mfaService.Enable(c.Request.Context(), user, claims.SessionID, req.Code)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+func (h HandlerSet) DisableTOTP(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.mfaService.Disable(c.Request.Context(), user.ID, req.Code); err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h HandlerSet) RegenerateRecoveryCodes(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.mfaService.RegenerateRecoveryCodes(c.Request.Context(), user.ID, req.Code)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+func currentUser(c *gin.Context) (models.User, bool) {
+	userVal, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return models.User{}, false
+	}
+	user, ok := userVal.(models.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_user"})
+		return models.User{}, false
+	}
+	return user, true
+}
+
+func writeMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidMFACode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_mfa_code"})
+	case errors.Is(err, service.ErrInvalidMFAChallenge):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_mfa_challenge"})
+	case errors.Is(err, service.ErrMFALocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "mfa_locked"})
+	case errors.Is(err, service.ErrMFAAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": "mfa_already_enabled"})
+	case errors.Is(err, service.ErrMFANotEnabled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mfa_not_enabled"})
+	case errors.Is(err, service.ErrUserSuspended):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
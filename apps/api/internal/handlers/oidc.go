@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nodeimage/api/internal/service"
+)
+
+// OIDCAuthorize returns the provider authorization URL. The frontend keeps
+// state and compares it with the one on the callback before posting the code.
+func (h HandlerSet) OIDCAuthorize(c *gin.Context) {
+	auth, err := h.oidcService.Authorize(c.Request.Context())
+	if err != nil {
+		h.writeOIDCError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"provider":         h.cfg.Security.OIDC.Provider,
+		"displayName":      h.cfg.Security.OIDC.DisplayName,
+		"authorizationUrl": auth.URL,
+		"state":            auth.State,
+	})
+}
+
+type oidcCallbackRequest struct {
+	Code       string   `json:"code" binding:"required"`
+	State      string   `json:"state" binding:"required"`
+	DeviceID   string   `json:"deviceId"`
+	DeviceName string   `json:"deviceName"`
+	Scopes     []string `json:"scopes"`
+}
+
+func (h HandlerSet) OIDCCallback(c *gin.Context) {
+	var req oidcCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.authService.LoginOIDC(c.Request.Context(), service.OIDCLoginInput{
+		Code:       req.Code,
+		State:      req.State,
+		DeviceID:   req.DeviceID,
+		DeviceName: req.DeviceName,
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.GetHeader("User-Agent"),
+		Scopes:     req.Scopes,
+	})
+	if err != nil {
+		h.writeOIDCError(c, err)
+		return
+	}
+
+	if result.MFARequired {
+		c.JSON(http.StatusAccepted, gin.H{
+			"mfaRequired": true,
+			"mfaToken":    result.MFAToken,
+		})
+		return
+	}
+
+	h.sendAuthResponse(c, result, h.wantsRefreshCookie(c))
+}
+
+type externalIdentityResponse struct {
+	ID          string     `json:"id"`
+	Provider    string     `json:"provider"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastLoginAt *time.Time `json:"lastLoginAt"`
+}
+
+func (h HandlerSet) ListIdentities(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	identities, err := h.oidcService.ListIdentities(c.Request.Context(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	items := make([]externalIdentityResponse, 0, len(identities))
+	for _, identity := range identities {
+		items = append(items, externalIdentityResponse{
+			ID:          identity.ID,
+			Provider:    identity.Provider,
+			Email:       identity.Email,
+			CreatedAt:   identity.CreatedAt,
+			LastLoginAt: identity.LastLoginAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h HandlerSet) UnlinkIdentity(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.oidcService.Unlink(c.Request.Context(), user.ID, c.Param("id")); err != nil {
+		h.writeOIDCError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// writeOIDCError maps OIDC failures to codes. Unexpected errors, such as
+// database or Redis failures while linking, are logged and reported as
+// internal_error so their details stay server-side.
+func (h HandlerSet) writeOIDCError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrOIDCDisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": "oidc_disabled"})
+	case errors.Is(err, service.ErrInvalidOIDCState):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_oidc_state"})
+	case errors.Is(err, service.ErrOIDCLoginFailed):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "oidc_login_failed"})
+	case errors.Is(err, service.ErrOIDCEmailNotVerified):
+		c.JSON(http.StatusForbidden, gin.H{"error": "oidc_email_not_verified"})
+	case errors.Is(err, service.ErrOIDCAccountNotLinked):
+		c.JSON(http.StatusForbidden, gin.H{"error": "oidc_account_not_linked"})
+	case errors.Is(err, service.ErrIdentityNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "identity_not_found"})
+	case errors.Is(err, service.ErrUserSuspended):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidScope):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope"})
+	case errors.Is(err, service.ErrOIDCUnavailable):
+		h.log.Warn().Err(err).Msg("oidc provider unavailable")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "oidc_provider_unavailable"})
+	default:
+		h.log.Error().Err(err).Msg("oidc request failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal_error"})
+	}
+}
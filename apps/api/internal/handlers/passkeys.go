@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nodeimage/api/internal/models"
+	"nodeimage/api/internal/security"
+	"nodeimage/api/internal/service"
+)
+
+type passkeyResponse struct {
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	BackedUp     bool       `json:"backedUp"`
+	CloneWarning bool       `json:"cloneWarning"`
+	LastUsedAt   *time.Time `json:"lastUsedAt"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+func newPasskeyResponse(cred models.WebAuthnCredential) passkeyResponse {
+	return passkeyResponse{
+		ID:           cred.ID,
+		Name:         cred.Name,
+		BackedUp:     cred.BackupState,
+		CloneWarning: cred.CloneWarning,
+		LastUsedAt:   cred.LastUsedAt,
+		CreatedAt:    cred.CreatedAt,
+	}
+}
+
+func (h HandlerSet) ListPasskeys(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	creds, err := h.passkeyService.List(c.Request.Context(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	items := make([]passkeyResponse, 0, len(creds))
+	for _, cred := range creds {
+		items = append(items, newPasskeyResponse(cred))
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// BeginPasskeyRegistration returns options for navigator.credentials.create.
+func (h HandlerSet) BeginPasskeyRegistration(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	claimsVal, _ := c.Get("access_claims")
+	claims, _ := claimsVal.(security.AccessClaims)
+
+	options, err := h.passkeyService.BeginRegistration(c.Request.Context(), user, claims.MFA)
+	if err != nil {
+		writePasskeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, options)
+}
+
+type finishPasskeyRegistrationRequest struct {
+	Name       string          `json:"name" binding:"max=100"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+}
+
+func (h HandlerSet) FinishPasskeyRegistration(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	var req finishPasskeyRegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cred, err := h.passkeyService.FinishRegistration(c.Request.Context(), user, req.Name, req.Credential)
+	if err != nil {
+		writePasskeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, newPasskeyResponse(cred))
+}
+
+func (h HandlerSet) DeletePasskey(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.passkeyService.Delete(c.Request.Context(), user.ID, c.Param("id")); err != nil {
+		writePasskeyError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// BeginPasskeyLogin returns options for a discoverable passkey login.
+func (h HandlerSet) BeginPasskeyLogin(c *gin.Context) {
+	options, err := h.passkeyService.BeginLogin(c.Request.Context(), "")
+	if err != nil {
+		writePasskeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, options)
+}
+
+type passkeyLoginRequest struct {
+	Credential json.RawMessage `json:"credential" binding:"required"`
+	DeviceID   string          `json:"deviceId"`
+	DeviceName string          `json:"deviceName"`
+	Scopes     []string        `json:"scopes"`
+}
+
+func (h HandlerSet) PasskeyLogin(c *gin.Context) {
+	var req passkeyLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.authService.LoginPasskey(c.Request.Context(), service.PasskeyLoginInput{
+		Credential: req.Credential,
+		DeviceID:   req.DeviceID,
+		DeviceName: req.DeviceName,
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.GetHeader("User-Agent"),
+		Scopes:     req.Scopes,
+	})
+	if err != nil {
+		writePasskeyError(c, err)
+		return
+	}
+
+	h.sendAuthResponse(c, result, h.wantsRefreshCookie(c))
+}
+
+type mfaPasskeyOptionsRequest struct {
+	MFAToken string `json:"mfaToken" binding:"required"`
+}
+
+// BeginMFAPasskey returns assertion options for answering a login MFA
+// challenge with a passkey.
+func (h HandlerSet) BeginMFAPasskey(c *gin.Context) {
+	var req mfaPasskeyOptionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	options, err := h.authService.BeginMFAPasskey(c.Request.Context(), req.MFAToken)
+	if err != nil {
+		writePasskeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, options)
+}
+
+type loginMFAPasskeyRequest struct {
+	MFAToken   string          `json:"mfaToken" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+}
+
+func (h HandlerSet) LoginMFAPasskey(c *gin.Context) {
+	var req loginMFAPasskeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.authService.LoginMFAPasskey(c.Request.Context(), service.LoginMFAPasskeyInput{
+		MFAToken:   req.MFAToken,
+		Credential: req.Credential,
+	})
+	if err != nil {
+		writePasskeyError(c, err)
+		return
+	}
+
+	h.sendAuthResponse(c, result, h.wantsRefreshCookie(c))
+}
+
+func writePasskeyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrPasskeysDisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": "passkeys_disabled"})
+	case errors.Is(err, service.ErrInvalidPasskey):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_passkey"})
+	case errors.Is(err, service.ErrPasskeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "passkey_not_found"})
+	case errors.Is(err, service.ErrPasskeyLimit):
+		c.JSON(http.StatusConflict, gin.H{"error": "passkey_limit"})
+	case errors.Is(err, service.ErrMFARequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": "mfa_required"})
+	case errors.Is(err, service.ErrInvalidScope):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope"})
+	case errors.Is(err, service.ErrEmailNotVerified):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		writeMFAError(c, err)
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HeaderTokenDelivery lets a browser client ask for the refresh token as a
+// cookie instead of in the response body.
+const HeaderTokenDelivery = "X-Codex-Token-Delivery"
+
+func (h HandlerSet) wantsRefreshCookie(c *gin.Context) bool {
+	return h.cfg.Security.RefreshCookie.Enabled && strings.EqualFold(c.GetHeader(HeaderTokenDelivery), "cookie")
+}
+
+// refreshTokenFromRequest prefers an explicit token over the cookie and
+// reports whether the cookie was used.
+func (h HandlerSet) refreshTokenFromRequest(c *gin.Context, bodyToken string) (string, bool) {
+	if bodyToken != "" {
+		return bodyToken, false
+	}
+	if !h.cfg.Security.RefreshCookie.Enabled {
+		return "", false
+	}
+	token, err := c.Cookie(h.cfg.Security.RefreshCookie.Name)
+	if err != nil || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+func (h HandlerSet) setRefreshCookie(c *gin.Context, token string, ttl time.Duration) {
+	http.SetCookie(c.Writer, h.refreshCookie(token, int(ttl.Seconds())))
+}
+
+func (h HandlerSet) clearRefreshCookie(c *gin.Context) {
+	http.SetCookie(c.Writer, h.refreshCookie("", -1))
+}
+
+func (h HandlerSet) refreshCookie(value string, maxAge int) *http.Cookie {
+	cfg := h.cfg.Security.RefreshCookie
+	return &http.Cookie{
+		Name:     cfg.Name,
+		Value:    value,
+		Path:     cfg.Path,
+		Domain:   cfg.Domain,
+		MaxAge:   maxAge,
+		Secure:   cfg.Secure,
+		HttpOnly: true,
+		SameSite: parseSameSite(cfg.SameSite),
+	}
+}
+
+func parseSameSite(value string) http.SameSite {
+	switch strings.ToLower(value) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteStrictMode
+	}
+}
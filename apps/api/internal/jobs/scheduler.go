@@ -41,9 +41,9 @@ func (s *Scheduler) Start() error {
 }
 
 func (s *Scheduler) Stop() context.CancelFunc {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	_, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	go func() {
-		s.cron.Stop()
+		<-s.cron.Stop().Done()
 		cancel()
 	}()
 	return cancel
@@ -0,0 +1,113 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+
+	"nodeimage/api/internal/models"
+	"nodeimage/api/internal/repository"
+)
+
+const (
+	statusStream    = "media:status"
+	statusGroup     = "api"
+	statusBatchSize = 50
+)
+
+// StatusConsumer applies the image status updates the worker publishes for
+// tasks it gives up on, so rejected uploads do not stay "processing".
+type StatusConsumer struct {
+	queue    *redis.Client
+	images   *repository.ImageRepository
+	consumer string
+	log      zerolog.Logger
+}
+
+func NewStatusConsumer(queue *redis.Client, images *repository.ImageRepository, consumer string, log zerolog.Logger) *StatusConsumer {
+	return &StatusConsumer{
+		queue:    queue,
+		images:   images,
+		consumer: consumer,
+		log:      log,
+	}
+}
+
+// Run reads the status stream until ctx is cancelled. Updates that fail to
+// apply stay pending and are read again on the next start.
+func (s *StatusConsumer) Run(ctx context.Context) {
+	err := s.queue.XGroupCreateMkStream(ctx, statusStream, statusGroup, "0").Err()
+	if err != nil && !isBusyGroup(err) {
+		s.log.Error().Err(err).Msg("create status consumer group failed")
+		return
+	}
+
+	// Drain our own pending entries first, then new ones.
+	start := "0"
+	for ctx.Err() == nil {
+		result, err := s.queue.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    statusGroup,
+			Consumer: s.consumer,
+			Streams:  []string{statusStream, start},
+			Count:    statusBatchSize,
+			Block:    5 * time.Second,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+				continue
+			}
+			s.log.Error().Err(err).Msg("status stream read error")
+			time.Sleep(2 * time.Second)
+			continue
+		}
+
+		handled, failed := 0, 0
+		for _, stream := range result {
+			for _, msg := range stream.Messages {
+				handled++
+				if err := s.apply(ctx, msg); err != nil {
+					failed++
+					s.log.Error().Err(err).Str("message_id", msg.ID).Msg("apply status update failed")
+					continue
+				}
+				if err := s.queue.XAck(ctx, statusStream, statusGroup, msg.ID).Err(); err != nil {
+					s.log.Error().Err(err).Str("message_id", msg.ID).Msg("status ack failed")
+				}
+			}
+		}
+		// Entries that failed stay pending; they are retried after a
+		// restart instead of spinning on them here.
+		if start == "0" && (handled < statusBatchSize || failed > 0) {
+			start = ">"
+		}
+	}
+}
+
+func (s *StatusConsumer) apply(ctx context.Context, msg redis.XMessage) error {
+	imageID, _ := msg.Values["imageId"].(string)
+	status, _ := msg.Values["status"].(string)
+	if imageID == "" {
+		return nil
+	}
+	// The worker only reports terminal failures; anything else is ignored
+	// rather than trusted to overwrite moderation results.
+	if models.ImageStatus(status) != models.ImageStatusFailed {
+		s.log.Warn().Str("image_id", imageID).Str("status", status).Msg("ignoring status update")
+		return nil
+	}
+	if err := s.images.UpdateStatus(ctx, imageID, models.ImageStatusFailed, nil); err != nil {
+		return fmt.Errorf("update image %s: %w", imageID, err)
+	}
+	reason, _ := msg.Values["reason"].(string)
+	s.log.Info().Str("image_id", imageID).Str("reason", reason).Msg("image marked failed")
+	return nil
+}
+
+func isBusyGroup(err error) bool {
+	return strings.HasPrefix(err.Error(), "BUSYGROUP")
+}
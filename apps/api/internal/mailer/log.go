@@ -0,0 +1,29 @@
+package mailer
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+)
+
+// LogMailer writes messages to the log instead of sending them. It is the
+// default so development setups can follow links without a mail server.
+type LogMailer struct {
+	log zerolog.Logger
+}
+
+func NewLog(log zerolog.Logger) *LogMailer {
+	return &LogMailer{log: log}
+}
+
+func (m *LogMailer) Send(_ context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	m.log.Info().
+		Str("to", msg.To).
+		Str("subject", msg.Subject).
+		Str("text", msg.Text).
+		Msg("mail not sent (log driver)")
+	return nil
+}
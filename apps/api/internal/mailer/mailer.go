@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"nodeimage/api/internal/config"
+)
+
+var ErrInvalidMessage = errors.New("invalid mail message")
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+// Mailer delivers transactional mail. Implementations must be safe for
+// concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New builds the mailer selected by cfg.Driver: "smtp", "log" or "memory".
+func New(cfg config.MailConfig, log zerolog.Logger) (Mailer, error) {
+	switch strings.ToLower(cfg.Driver) {
+	case "smtp":
+		return NewSMTP(cfg)
+	case "", "log":
+		return NewLog(log), nil
+	case "memory":
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// validate rejects line breaks in header values, which would let a caller
+// inject extra headers or recipients.
+func (m Message) validate() error {
+	if m.To == "" {
+		return fmt.Errorf("%w: missing recipient", ErrInvalidMessage)
+	}
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return fmt.Errorf("%w: line break in header", ErrInvalidMessage)
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+var (
+	ErrUnsupportedArchive = errors.New("unsupported archive format")
+	ErrTooManyEntries     = errors.New("archive has too many entries")
+	ErrTooLarge           = errors.New("archive expands beyond size limit")
+	ErrUnsafePath         = errors.New("unsafe archive entry path")
+	ErrNestingTooDeep     = errors.New("archive nesting too deep")
+)
+
+type Kind string
+
+const (
+	KindZip   Kind = "zip"
+	KindTarGz Kind = "tar.gz"
+	KindTar   Kind = "tar"
+)
+
+type Limits struct {
+	MaxEntries int
+	MaxBytes   int64
+	MaxDepth   int
+}
+
+// Entry is a regular file found in the archive. Entries rejected by path
+// checks or nesting depth carry Err instead of Data so callers can report
+// them alongside processed files.
+type Entry struct {
+	Path string
+	Data []byte
+	Err  error
+}
+
+// Detect identifies an archive by its magic bytes. Gzip data only counts as
+// a tar.gz when the decompressed stream starts with a tar header, so a bare
+// gzipped file such as svgz is not mistaken for an archive.
+func Detect(head []byte) (Kind, bool) {
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return KindZip, true
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return KindTarGz, isTarGz(head)
+	case isTar(head):
+		return KindTar, true
+	}
+	return "", false
+}
+
+func isTar(head []byte) bool {
+	return len(head) >= 262 && bytes.Equal(head[257:262], []byte("ustar"))
+}
+
+func isTarGz(data []byte) bool {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return false
+	}
+	defer gz.Close()
+	head := make([]byte, 262)
+	if _, err := io.ReadFull(gz, head); err != nil {
+		return false
+	}
+	return isTar(head)
+}
+
+type expander struct {
+	limits  Limits
+	entries []Entry
+	count   int
+	total   int64
+}
+
+// Expand reads every regular file out of data. Limits are enforced on the
+// bytes actually decompressed, not on sizes declared in headers, and apply
+// across nested archives. Nested archives are expanded while depth allows;
+// past that they are reported with ErrNestingTooDeep.
+func Expand(data []byte, limits Limits) ([]Entry, error) {
+	kind, ok := Detect(data)
+	if !ok {
+		return nil, ErrUnsupportedArchive
+	}
+	e := &expander{limits: limits}
+	if err := e.expand(kind, data, "", 1); err != nil {
+		return nil, err
+	}
+	return e.entries, nil
+}
+
+func (e *expander) expand(kind Kind, data []byte, prefix string, depth int) error {
+	switch kind {
+	case KindZip:
+		return e.expandZip(data, prefix, depth)
+	case KindTarGz:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUnsupportedArchive, err)
+		}
+		defer gz.Close()
+		return e.expandTar(gz, prefix, depth)
+	case KindTar:
+		return e.expandTar(bytes.NewReader(data), prefix, depth)
+	default:
+		return ErrUnsupportedArchive
+	}
+}
+
+func (e *expander) expandZip(data []byte, prefix string, depth int) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsupportedArchive, err)
+	}
+	for _, f := range zr.File {
+		if err := e.countEntry(); err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name, err := safePath(f.Name)
+		if err != nil {
+			e.entries = append(e.entries, Entry{Path: prefix + f.Name, Err: err})
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			e.entries = append(e.entries, Entry{Path: prefix + name, Err: fmt.Errorf("%w: %v", ErrUnsupportedArchive, err)})
+			continue
+		}
+		content, err := e.read(rc)
+		rc.Close()
+		if errors.Is(err, ErrTooLarge) {
+			return err
+		}
+		if err != nil {
+			e.entries = append(e.entries, Entry{Path: prefix + name, Err: err})
+			continue
+		}
+		if err := e.add(prefix+name, content, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandTar charges every byte of the tar stream against MaxBytes, so
+// headers, padding and skipped entries count as well as file contents.
+func (e *expander) expandTar(r io.Reader, prefix string, depth int) error {
+	tr := tar.NewReader(&countingReader{e: e, r: r})
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if errors.Is(err, ErrTooLarge) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUnsupportedArchive, err)
+		}
+		if err := e.countEntry(); err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name, err := safePath(header.Name)
+		if err != nil {
+			e.entries = append(e.entries, Entry{Path: prefix + header.Name, Err: err})
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if errors.Is(err, ErrTooLarge) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUnsupportedArchive, err)
+		}
+		if err := e.add(prefix+name, content, depth); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *expander) add(name string, content []byte, depth int) error {
+	kind, nested := Detect(content)
+	if !nested {
+		e.entries = append(e.entries, Entry{Path: name, Data: content})
+		return nil
+	}
+	if e.limits.MaxDepth > 0 && depth >= e.limits.MaxDepth {
+		e.entries = append(e.entries, Entry{Path: name, Err: ErrNestingTooDeep})
+		return nil
+	}
+	mark := len(e.entries)
+	err := e.expand(kind, content, name+"/", depth+1)
+	if err == nil || errors.Is(err, ErrTooLarge) || errors.Is(err, ErrTooManyEntries) {
+		return err
+	}
+	// A nested archive that cannot be read is not fatal to the outer one:
+	// drop whatever it yielded and report the entry on its own.
+	e.entries = append(e.entries[:mark], Entry{Path: name, Err: err})
+	return nil
+}
+
+func (e *expander) countEntry() error {
+	e.count++
+	if e.limits.MaxEntries > 0 && e.count > e.limits.MaxEntries {
+		return fmt.Errorf("%w: limit %d", ErrTooManyEntries, e.limits.MaxEntries)
+	}
+	return nil
+}
+
+func (e *expander) read(r io.Reader) ([]byte, error) {
+	content, err := io.ReadAll(&countingReader{e: e, r: r})
+	if errors.Is(err, ErrTooLarge) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedArchive, err)
+	}
+	return content, nil
+}
+
+// charge adds n decompressed bytes to the running total.
+func (e *expander) charge(n int64) error {
+	e.total += n
+	if e.limits.MaxBytes > 0 && e.total > e.limits.MaxBytes {
+		return fmt.Errorf("%w: limit %d bytes", ErrTooLarge, e.limits.MaxBytes)
+	}
+	return nil
+}
+
+// countingReader fails with ErrTooLarge as soon as the bytes read through
+// it push the expander past MaxBytes. The overflowing read returns no data,
+// since io.ReadFull drops errors that come with a full buffer.
+type countingReader struct {
+	e *expander
+	r io.Reader
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		if chargeErr := c.e.charge(int64(n)); chargeErr != nil {
+			return 0, chargeErr
+		}
+	}
+	return n, err
+}
+
+// safePath rejects absolute paths, drive letters and any ".." component
+// (zip-slip), normalising backslash separators first.
+func safePath(name string) (string, error) {
+	normalized := strings.ReplaceAll(name, "\\", "/")
+	if normalized == "" || strings.HasPrefix(normalized, "/") || strings.ContainsRune(normalized, 0) {
+		return "", ErrUnsafePath
+	}
+	if len(normalized) >= 2 && normalized[1] == ':' {
+		return "", ErrUnsafePath
+	}
+	for _, part := range strings.Split(normalized, "/") {
+		if part == ".." {
+			return "", ErrUnsafePath
+		}
+	}
+	return path.Clean(normalized), nil
+}
@@ -0,0 +1,208 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+)
+
+type file struct {
+	name string
+	data []byte
+	kind byte
+}
+
+func zipOf(t *testing.T, files ...file) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarOf(t *testing.T, files ...file) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		kind := f.kind
+		if kind == 0 {
+			kind = tar.TypeReg
+		}
+		header := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.data)), Typeflag: kind, Format: tar.FormatUSTAR}
+		if kind != tar.TypeReg {
+			header.Size = 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if kind == tar.TypeReg {
+			if _, err := tw.Write(f.data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipOf(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type want struct {
+	path string
+	data string
+	err  error
+}
+
+func TestExpand(t *testing.T) {
+	svgz := gzipOf(t, []byte("<svg/>"))
+	nested := gzipOf(t, tarOf(t, file{name: "inner.png", data: []byte("png")}))
+	// A gzip stream that starts like a tar but is cut short.
+	truncated := gzipOf(t, tarOf(t, file{name: "cut.png", data: bytes.Repeat([]byte("x"), 2048)})[:1024])
+	links := make([]file, 100)
+	for i := range links {
+		links[i] = file{name: "link", kind: tar.TypeSymlink}
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		limits  Limits
+		want    []want
+		wantErr error
+	}{
+		{
+			name: "zip",
+			data: zipOf(t, file{name: "a.png", data: []byte("a")}, file{name: "dir/b.jpg", data: []byte("b")}),
+			want: []want{{path: "a.png", data: "a"}, {path: "dir/b.jpg", data: "b"}},
+		},
+		{
+			name: "zip slip",
+			data: zipOf(t,
+				file{name: "../evil.png", data: []byte("x")},
+				file{name: "/etc/passwd", data: []byte("x")},
+				file{name: `..\evil.png`, data: []byte("x")},
+				file{name: "C:/evil.png", data: []byte("x")},
+				file{name: "ok/../../evil.png", data: []byte("x")},
+				file{name: "ok.png", data: []byte("ok")},
+			),
+			want: []want{
+				{path: "../evil.png", err: ErrUnsafePath},
+				{path: "/etc/passwd", err: ErrUnsafePath},
+				{path: `..\evil.png`, err: ErrUnsafePath},
+				{path: "C:/evil.png", err: ErrUnsafePath},
+				{path: "ok/../../evil.png", err: ErrUnsafePath},
+				{path: "ok.png", data: "ok"},
+			},
+		},
+		{
+			name: "tar slip",
+			data: gzipOf(t, tarOf(t, file{name: "../../evil.png", data: []byte("x")})),
+			want: []want{{path: "../../evil.png", err: ErrUnsafePath}},
+		},
+		{
+			name: "nested tar.gz",
+			data: zipOf(t, file{name: "more.tar.gz", data: nested}),
+			want: []want{{path: "more.tar.gz/inner.png", data: "png"}},
+		},
+		{
+			name: "bare gzip is a plain entry",
+			data: zipOf(t, file{name: "logo.svgz", data: svgz}),
+			want: []want{{path: "logo.svgz", data: string(svgz)}},
+		},
+		{
+			name: "broken nested archive is reported",
+			data: zipOf(t, file{name: "cut.tar.gz", data: truncated}, file{name: "ok.png", data: []byte("ok")}),
+			want: []want{{path: "cut.tar.gz", err: ErrUnsupportedArchive}, {path: "ok.png", data: "ok"}},
+		},
+		{
+			name:   "nesting too deep",
+			data:   zipOf(t, file{name: "more.tar.gz", data: nested}),
+			limits: Limits{MaxDepth: 1},
+			want:   []want{{path: "more.tar.gz", err: ErrNestingTooDeep}},
+		},
+		{
+			name:    "too many entries",
+			data:    zipOf(t, file{name: "a", data: []byte("a")}, file{name: "b", data: []byte("b")}),
+			limits:  Limits{MaxEntries: 1},
+			wantErr: ErrTooManyEntries,
+		},
+		{
+			name:    "too many bytes",
+			data:    zipOf(t, file{name: "big", data: bytes.Repeat([]byte("x"), 4096)}),
+			limits:  Limits{MaxBytes: 1024},
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "skipped tar headers count toward bytes",
+			data:    gzipOf(t, tarOf(t, links...)),
+			limits:  Limits{MaxBytes: 10 << 10},
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "bare gzip upload",
+			data:    svgz,
+			wantErr: ErrUnsupportedArchive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := Expand(tt.data, tt.limits)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.want) {
+				t.Fatalf("got %d entries %+v, want %d", len(entries), entries, len(tt.want))
+			}
+			for i, w := range tt.want {
+				got := entries[i]
+				if got.Path != w.path {
+					t.Errorf("entry %d path = %q, want %q", i, got.Path, w.path)
+				}
+				if w.err != nil {
+					if !errors.Is(got.Err, w.err) {
+						t.Errorf("entry %d err = %v, want %v", i, got.Err, w.err)
+					}
+					continue
+				}
+				if got.Err != nil || string(got.Data) != w.data {
+					t.Errorf("entry %d = (%q, %v), want %q", i, got.Data, got.Err, w.data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package datauri
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrInvalidEncoding = errors.New("invalid base64 or data uri payload")
+	ErrTooLarge        = errors.New("payload too large")
+)
+
+type Payload struct {
+	MIME string
+	Data []byte
+}
+
+// Decode accepts either a bare base64 string (standard or URL alphabet,
+// padded or not) or an RFC 2397 data URI. maxBytes caps the decoded size and
+// is checked before any allocation for the decoded data.
+func Decode(input string, maxBytes int) (Payload, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return Payload{}, ErrInvalidEncoding
+	}
+
+	if !strings.HasPrefix(strings.ToLower(input), "data:") {
+		data, err := decodeBase64(input, maxBytes)
+		return Payload{Data: data}, err
+	}
+
+	header, body, ok := strings.Cut(input[len("data:"):], ",")
+	if !ok {
+		return Payload{}, fmt.Errorf("%w: missing data uri separator", ErrInvalidEncoding)
+	}
+
+	isBase64 := false
+	mediaType := header
+	if strings.HasSuffix(strings.ToLower(header), ";base64") {
+		isBase64 = true
+		mediaType = header[:len(header)-len(";base64")]
+	}
+
+	mimeType := ""
+	if mediaType != "" {
+		parsed, _, err := mime.ParseMediaType(mediaType)
+		if err != nil {
+			return Payload{}, fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+		}
+		mimeType = parsed
+	}
+
+	if isBase64 {
+		data, err := decodeBase64(body, maxBytes)
+		return Payload{MIME: mimeType, Data: data}, err
+	}
+
+	if maxBytes > 0 && int64(len(body)) > MaxEncodedLen(maxBytes) {
+		return Payload{}, ErrTooLarge
+	}
+	unescaped, err := url.PathUnescape(body)
+	if err != nil {
+		return Payload{}, fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	}
+	if maxBytes > 0 && len(unescaped) > maxBytes {
+		return Payload{}, ErrTooLarge
+	}
+	return Payload{MIME: mimeType, Data: []byte(unescaped)}, nil
+}
+
+// MaxEncodedLen bounds the payload Decode accepts for maxBytes of decoded
+// data, so callers can cap the request body before reading it: the base64
+// expansion plus room for a line break every 16 characters.
+func MaxEncodedLen(maxBytes int) int64 {
+	n := int64(base64.StdEncoding.EncodedLen(maxBytes))
+	return n + n/16
+}
+
+func decodeBase64(encoded string, maxBytes int) ([]byte, error) {
+	encoded = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		}
+		return r
+	}, encoded)
+	encoded = strings.TrimRight(encoded, "=")
+
+	encoding := base64.RawStdEncoding
+	if strings.ContainsAny(encoded, "-_") {
+		encoding = base64.RawURLEncoding
+	}
+
+	if maxBytes > 0 && encoding.DecodedLen(len(encoded)) > maxBytes {
+		return nil, ErrTooLarge
+	}
+
+	data, err := encoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,302 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+type bmffBox struct {
+	kind     string
+	start    int
+	contents int
+	end      int
+}
+
+type ilocExtent struct {
+	offset uint64
+	length uint64
+}
+
+type ilocItem struct {
+	constructionMethod int
+	extents            []ilocExtent
+}
+
+// stripAVIF zeroes the payload of Exif and XMP items in place. HEIC and HEIF
+// share the same item structure and go through here too. Rewriting the
+// iinf/iloc/iref boxes to drop the items would shift every offset in the
+// file, so the items stay declared but carry no data. Orientation in these
+// formats lives in the irot/imir properties, not in EXIF, so nothing needs
+// baking.
+func stripAVIF(data []byte) ([]byte, error) {
+	boxes, err := readBoxes(data, 0, len(data))
+	if err != nil {
+		return nil, err
+	}
+	meta, ok := findBox(boxes, "meta")
+	if !ok {
+		return data, nil
+	}
+	children, err := readBoxes(data, meta.contents+4, meta.end)
+	if err != nil {
+		return nil, err
+	}
+
+	iinf, ok := findBox(children, "iinf")
+	if !ok {
+		return data, nil
+	}
+	targets, err := metadataItemIDs(data, iinf)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return data, nil
+	}
+
+	iloc, ok := findBox(children, "iloc")
+	if !ok {
+		return nil, fmt.Errorf("%w: avif meta without iloc", ErrMalformed)
+	}
+	locations, err := parseILOC(data, iloc)
+	if err != nil {
+		return nil, err
+	}
+
+	idatStart := -1
+	if idat, ok := findBox(children, "idat"); ok {
+		idatStart = idat.contents
+	}
+
+	out := make([]byte, len(data))
+	copy(out, data)
+	for id := range targets {
+		item, ok := locations[id]
+		if !ok {
+			continue
+		}
+		base := 0
+		switch item.constructionMethod {
+		case 0:
+		case 1:
+			if idatStart < 0 {
+				return nil, fmt.Errorf("%w: avif item in missing idat", ErrMalformed)
+			}
+			base = idatStart
+		default:
+			continue
+		}
+		for _, extent := range item.extents {
+			start := uint64(base) + extent.offset
+			end := start + extent.length
+			if extent.length == 0 || end > uint64(len(out)) || end < start {
+				return nil, fmt.Errorf("%w: avif item extent out of range", ErrMalformed)
+			}
+			clear(out[start:end])
+		}
+	}
+	return out, nil
+}
+
+func readBoxes(data []byte, start, end int) ([]bmffBox, error) {
+	var boxes []bmffBox
+	pos := start
+	for pos+8 <= end {
+		size := uint64(binary.BigEndian.Uint32(data[pos : pos+4]))
+		kind := string(data[pos+4 : pos+8])
+		header := 8
+		switch size {
+		case 0:
+			size = uint64(end - pos)
+		case 1:
+			if pos+16 > end {
+				return nil, fmt.Errorf("%w: truncated largesize box", ErrMalformed)
+			}
+			size = binary.BigEndian.Uint64(data[pos+8 : pos+16])
+			header = 16
+		}
+		if size < uint64(header) || size > uint64(end-pos) {
+			return nil, fmt.Errorf("%w: box %q overruns parent", ErrMalformed, kind)
+		}
+		boxes = append(boxes, bmffBox{
+			kind:     kind,
+			start:    pos,
+			contents: pos + header,
+			end:      pos + int(size),
+		})
+		pos += int(size)
+	}
+	return boxes, nil
+}
+
+func findBox(boxes []bmffBox, kind string) (bmffBox, bool) {
+	for _, box := range boxes {
+		if box.kind == kind {
+			return box, true
+		}
+	}
+	return bmffBox{}, false
+}
+
+// metadataItemIDs returns the IDs of Exif items and of mime items carrying
+// XMP (application/rdf+xml).
+func metadataItemIDs(data []byte, iinf bmffBox) (map[uint32]struct{}, error) {
+	if iinf.contents+4 > iinf.end {
+		return nil, fmt.Errorf("%w: truncated iinf", ErrMalformed)
+	}
+	version := data[iinf.contents]
+	pos := iinf.contents + 4
+	if version == 0 {
+		pos += 2
+	} else {
+		pos += 4
+	}
+	if pos > iinf.end {
+		return nil, fmt.Errorf("%w: truncated iinf", ErrMalformed)
+	}
+
+	entries, err := readBoxes(data, pos, iinf.end)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make(map[uint32]struct{})
+	for _, infe := range entries {
+		if infe.kind != "infe" || infe.contents+4 > infe.end {
+			continue
+		}
+		version := data[infe.contents]
+		if version < 2 {
+			continue
+		}
+		p := infe.contents + 4
+		var id uint32
+		if version == 2 {
+			if p+2 > infe.end {
+				continue
+			}
+			id = uint32(binary.BigEndian.Uint16(data[p : p+2]))
+			p += 2
+		} else {
+			if p+4 > infe.end {
+				continue
+			}
+			id = binary.BigEndian.Uint32(data[p : p+4])
+			p += 4
+		}
+		p += 2 // item_protection_index
+		if p+4 > infe.end {
+			continue
+		}
+		itemType := string(data[p : p+4])
+		p += 4
+
+		switch itemType {
+		case "Exif":
+			targets[id] = struct{}{}
+		case "mime":
+			_, p = readCString(data, p, infe.end) // item_name
+			contentType, _ := readCString(data, p, infe.end)
+			if contentType == "application/rdf+xml" {
+				targets[id] = struct{}{}
+			}
+		}
+	}
+	return targets, nil
+}
+
+func readCString(data []byte, pos, end int) (string, int) {
+	for i := pos; i < end; i++ {
+		if data[i] == 0 {
+			return string(data[pos:i]), i + 1
+		}
+	}
+	return string(data[pos:end]), end
+}
+
+func parseILOC(data []byte, iloc bmffBox) (map[uint32]ilocItem, error) {
+	r := bmffReader{data: data, pos: iloc.contents, end: iloc.end}
+
+	version := r.uint(1)
+	r.skip(3)
+	sizes := r.uint(2)
+	offsetSize := int(sizes >> 12 & 0xf)
+	lengthSize := int(sizes >> 8 & 0xf)
+	baseOffsetSize := int(sizes >> 4 & 0xf)
+	indexSize := 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0xf)
+	}
+
+	var itemCount uint64
+	if version < 2 {
+		itemCount = r.uint(2)
+	} else {
+		itemCount = r.uint(4)
+	}
+
+	items := make(map[uint32]ilocItem)
+	for i := uint64(0); i < itemCount && r.err == nil; i++ {
+		var id uint64
+		if version < 2 {
+			id = r.uint(2)
+		} else {
+			id = r.uint(4)
+		}
+		item := ilocItem{}
+		if version == 1 || version == 2 {
+			item.constructionMethod = int(r.uint(2) & 0xf)
+		}
+		r.skip(2) // data_reference_index
+		baseOffset := r.uint(baseOffsetSize)
+		extentCount := r.uint(2)
+		for e := uint64(0); e < extentCount && r.err == nil; e++ {
+			r.skip(indexSize)
+			offset := r.uint(offsetSize)
+			length := r.uint(lengthSize)
+			item.extents = append(item.extents, ilocExtent{offset: baseOffset + offset, length: length})
+		}
+		items[uint32(id)] = item
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return items, nil
+}
+
+type bmffReader struct {
+	data []byte
+	pos  int
+	end  int
+	err  error
+}
+
+func (r *bmffReader) uint(size int) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if size == 0 {
+		return 0
+	}
+	if size > 8 || r.pos+size > r.end {
+		r.err = fmt.Errorf("%w: truncated iloc", ErrMalformed)
+		return 0
+	}
+	var v uint64
+	for _, b := range r.data[r.pos : r.pos+size] {
+		v = v<<8 | uint64(b)
+	}
+	r.pos += size
+	return v
+}
+
+func (r *bmffReader) skip(size int) {
+	if r.err != nil {
+		return
+	}
+	if r.pos+size > r.end {
+		r.err = fmt.Errorf("%w: truncated iloc", ErrMalformed)
+		return
+	}
+	r.pos += size
+}
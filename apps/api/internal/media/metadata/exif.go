@@ -0,0 +1,73 @@
+package metadata
+
+import (
+	"encoding/binary"
+)
+
+const (
+	exifTagOrientation = 0x0112
+	exifTypeShort      = 3
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// exifOrientation reads the Orientation tag from IFD0 of a TIFF-structured
+// EXIF block. It returns 1 (normal) when the tag is absent or unreadable.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	if order.Uint16(tiff[2:4]) != 42 {
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) != exifTagOrientation {
+			continue
+		}
+		if order.Uint16(tiff[entry+2:entry+4]) != exifTypeShort {
+			return 1
+		}
+		value := int(order.Uint16(tiff[entry+8 : entry+10]))
+		if value < 1 || value > 8 {
+			return 1
+		}
+		return value
+	}
+	return 1
+}
+
+// orientationOnlyEXIF builds a minimal big-endian TIFF block whose IFD0
+// holds nothing but the Orientation tag.
+func orientationOnlyEXIF(orientation int) []byte {
+	tiff := make([]byte, 26)
+	copy(tiff, "MM")
+	binary.BigEndian.PutUint16(tiff[2:4], 42)
+	binary.BigEndian.PutUint32(tiff[4:8], 8)
+	binary.BigEndian.PutUint16(tiff[8:10], 1)
+	binary.BigEndian.PutUint16(tiff[10:12], exifTagOrientation)
+	binary.BigEndian.PutUint16(tiff[12:14], exifTypeShort)
+	binary.BigEndian.PutUint32(tiff[14:18], 1)
+	binary.BigEndian.PutUint16(tiff[18:20], uint16(orientation))
+	// tiff[22:26] is the zero next-IFD offset.
+	return tiff
+}
@@ -0,0 +1,177 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+)
+
+const (
+	markerSOS   = 0xda
+	markerEOI   = 0xd9
+	markerAPP0  = 0xe0
+	markerAPP1  = 0xe1
+	markerAPP2  = 0xe2
+	markerAPP14 = 0xee
+	markerAPP15 = 0xef
+	markerCOM   = 0xfe
+
+	jpegReencodeQuality = 95
+)
+
+var (
+	iccProfileHeader = []byte("ICC_PROFILE\x00")
+	mpfHeader        = []byte("MPF\x00")
+)
+
+// stripJPEG drops APP1 (EXIF/XMP), APP3-APP13, APP15, COM and MPF segments,
+// keeping JFIF, ICC profiles and the Adobe APP14 colour transform marker.
+// Anything after EOI (e.g. MPF secondary images) is discarded as well.
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, fmt.Errorf("%w: missing jpeg SOI", ErrMalformed)
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xff, 0xd8)
+
+	var iccSegments [][]byte
+	orientation := 1
+	pos := 2
+	for {
+		if pos >= len(data) {
+			return nil, fmt.Errorf("%w: jpeg ended before SOS", ErrMalformed)
+		}
+		if data[pos] != 0xff {
+			return nil, fmt.Errorf("%w: expected jpeg marker at %d", ErrMalformed, pos)
+		}
+		for pos < len(data) && data[pos] == 0xff {
+			pos++
+		}
+		if pos >= len(data) {
+			return nil, fmt.Errorf("%w: truncated jpeg marker", ErrMalformed)
+		}
+		marker := data[pos]
+		start := pos - 1
+		pos++
+
+		if marker == markerEOI {
+			out = append(out, 0xff, markerEOI)
+			return reorientJPEG(out, orientation, iccSegments)
+		}
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			out = append(out, 0xff, marker)
+			continue
+		}
+
+		if pos+2 > len(data) {
+			return nil, fmt.Errorf("%w: truncated jpeg segment", ErrMalformed)
+		}
+		length := int(binary.BigEndian.Uint16(data[pos : pos+2]))
+		if length < 2 || pos+length > len(data) {
+			return nil, fmt.Errorf("%w: jpeg segment overruns file", ErrMalformed)
+		}
+		payload := data[pos+2 : pos+length]
+		end := pos + length
+
+		if marker == markerSOS {
+			scanEnd := findJPEGEOI(data, end)
+			out = append(out, data[start:scanEnd]...)
+			return reorientJPEG(out, orientation, iccSegments)
+		}
+
+		drop := false
+		switch {
+		case marker == markerAPP1:
+			drop = true
+			if bytes.HasPrefix(payload, exifHeader) {
+				orientation = exifOrientation(payload[len(exifHeader):])
+			}
+		case marker == markerAPP2:
+			if bytes.HasPrefix(payload, mpfHeader) {
+				drop = true
+			} else if bytes.HasPrefix(payload, iccProfileHeader) {
+				iccSegments = append(iccSegments, data[start:end])
+			}
+		case marker > markerAPP2 && marker <= markerAPP15 && marker != markerAPP14:
+			drop = true
+		case marker == markerCOM:
+			drop = true
+		}
+		if !drop {
+			out = append(out, data[start:end]...)
+		}
+		pos = end
+	}
+}
+
+// findJPEGEOI returns the offset just past the first EOI marker at or after
+// from. Inside entropy-coded data 0xFF is always byte-stuffed, so FFD9 can
+// only be a real EOI.
+func findJPEGEOI(data []byte, from int) int {
+	idx := bytes.Index(data[from:], []byte{0xff, markerEOI})
+	if idx < 0 {
+		return len(data)
+	}
+	return from + idx + 2
+}
+
+// reorientJPEG bakes a non-default orientation into the pixels. This is the
+// only case where the image is re-encoded; ICC profile segments from the
+// original are carried over so colours are preserved.
+//
+// image/jpeg can only write YCbCr and greyscale, so a CMYK (Adobe) source
+// would lose its colour space and its profile would no longer match. Those
+// are left as they are with an orientation-only EXIF segment instead.
+func reorientJPEG(stripped []byte, orientation int, iccSegments [][]byte) ([]byte, error) {
+	if orientation <= 1 {
+		return stripped, nil
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		return nil, fmt.Errorf("decode jpeg for orientation: %w", err)
+	}
+	if _, ok := img.(*image.CMYK); ok {
+		return insertJPEGOrientation(stripped, orientation), nil
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, applyOrientation(img, orientation), &jpeg.Options{Quality: jpegReencodeQuality}); err != nil {
+		return nil, fmt.Errorf("encode jpeg: %w", err)
+	}
+	encoded := buf.Bytes()
+
+	out := make([]byte, 0, len(encoded)+len(iccSegments)*1024)
+	out = append(out, encoded[:2]...)
+	for _, segment := range iccSegments {
+		out = append(out, segment...)
+	}
+	out = append(out, encoded[2:]...)
+	return out, nil
+}
+
+// insertJPEGOrientation adds an APP1 EXIF segment holding only the
+// Orientation tag after SOI, or after APP0 when the file starts with JFIF.
+func insertJPEGOrientation(data []byte, orientation int) []byte {
+	payload := append(append([]byte{}, exifHeader...), orientationOnlyEXIF(orientation)...)
+	segment := make([]byte, 4, 4+len(payload))
+	segment[0], segment[1] = 0xff, markerAPP1
+	binary.BigEndian.PutUint16(segment[2:4], uint16(2+len(payload)))
+	segment = append(segment, payload...)
+
+	at := 2
+	if len(data) >= 6 && data[2] == 0xff && data[3] == markerAPP0 {
+		if end := 4 + int(binary.BigEndian.Uint16(data[4:6])); end <= len(data) {
+			at = end
+		}
+	}
+
+	out := make([]byte, 0, len(data)+len(segment))
+	out = append(out, data[:at]...)
+	out = append(out, segment...)
+	out = append(out, data[at:]...)
+	return out
+}
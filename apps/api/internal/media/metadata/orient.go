@@ -0,0 +1,116 @@
+package metadata
+
+import (
+	"image"
+	"image/draw"
+)
+
+// applyOrientation returns img transformed so that it displays upright
+// without an EXIF Orientation tag. Values follow the EXIF specification.
+// Images with a flat pixel buffer keep their type, so bit depth, palette and
+// grey/CMYK models survive; others (YCbCr) are transformed as NRGBA.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	src := img
+	srcPix, srcStride, bpp, ok := pixelBuffer(src)
+	if !ok {
+		nrgba := image.NewNRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+		src = nrgba
+		srcPix, srcStride, bpp, _ = pixelBuffer(src)
+	}
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := newImageLike(src, image.Rect(0, 0, dw, dh))
+	dstPix, dstStride, _, _ := pixelBuffer(dst)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			si := y*srcStride + x*bpp
+			di := dy*dstStride + dx*bpp
+			copy(dstPix[di:di+bpp], srcPix[si:si+bpp])
+		}
+	}
+	return dst
+}
+
+// pixelBuffer exposes the pixels of image types stored as one interleaved
+// buffer, with Pix[0] at Bounds().Min.
+func pixelBuffer(img image.Image) ([]uint8, int, int, bool) {
+	switch m := img.(type) {
+	case *image.NRGBA:
+		return m.Pix, m.Stride, 4, true
+	case *image.RGBA:
+		return m.Pix, m.Stride, 4, true
+	case *image.NRGBA64:
+		return m.Pix, m.Stride, 8, true
+	case *image.RGBA64:
+		return m.Pix, m.Stride, 8, true
+	case *image.Gray:
+		return m.Pix, m.Stride, 1, true
+	case *image.Gray16:
+		return m.Pix, m.Stride, 2, true
+	case *image.Alpha:
+		return m.Pix, m.Stride, 1, true
+	case *image.Alpha16:
+		return m.Pix, m.Stride, 2, true
+	case *image.CMYK:
+		return m.Pix, m.Stride, 4, true
+	case *image.Paletted:
+		return m.Pix, m.Stride, 1, true
+	default:
+		return nil, 0, 0, false
+	}
+}
+
+// newImageLike allocates an empty image of the same type as img. It is only
+// called with types pixelBuffer accepts.
+func newImageLike(img image.Image, r image.Rectangle) image.Image {
+	switch m := img.(type) {
+	case *image.RGBA:
+		return image.NewRGBA(r)
+	case *image.NRGBA64:
+		return image.NewNRGBA64(r)
+	case *image.RGBA64:
+		return image.NewRGBA64(r)
+	case *image.Gray:
+		return image.NewGray(r)
+	case *image.Gray16:
+		return image.NewGray16(r)
+	case *image.Alpha:
+		return image.NewAlpha(r)
+	case *image.Alpha16:
+		return image.NewAlpha16(r)
+	case *image.CMYK:
+		return image.NewCMYK(r)
+	case *image.Paletted:
+		return image.NewPaletted(r, m.Palette)
+	default:
+		return image.NewNRGBA(r)
+	}
+}
@@ -0,0 +1,150 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image/png"
+)
+
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+
+var pngDroppedChunks = map[string]struct{}{
+	"eXIf": {},
+	"tEXt": {},
+	"zTXt": {},
+	"iTXt": {},
+	"tIME": {},
+}
+
+var pngColorChunks = map[string]struct{}{
+	"iCCP": {},
+	"sRGB": {},
+	"gAMA": {},
+	"cHRM": {},
+}
+
+type pngChunk struct {
+	kind string
+	raw  []byte
+}
+
+// stripPNG drops eXIf and the textual chunks (which carry XMP as iTXt). A
+// non-default orientation is baked into still images by re-encoding in the
+// source colour model. image/png only decodes the default image of an APNG,
+// so APNGs keep an orientation-only eXIf chunk instead and their frames
+// survive untouched.
+func stripPNG(data []byte) ([]byte, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	orientation := 1
+	animated := false
+	kept := make([]pngChunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		switch chunk.kind {
+		case "eXIf":
+			orientation = exifOrientation(chunk.raw[8 : len(chunk.raw)-4])
+		case "acTL":
+			animated = true
+		}
+		if _, drop := pngDroppedChunks[chunk.kind]; drop {
+			continue
+		}
+		kept = append(kept, chunk)
+	}
+
+	if orientation > 1 && animated {
+		kept = insertAfterIHDR(kept, []pngChunk{newPNGChunk("eXIf", orientationOnlyEXIF(orientation))})
+	}
+	out := writePNGChunks(kept)
+	if orientation <= 1 || animated {
+		return out, nil
+	}
+
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		return nil, fmt.Errorf("decode png for orientation: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, applyOrientation(img, orientation)); err != nil {
+		return nil, fmt.Errorf("encode png: %w", err)
+	}
+
+	encoded, err := readPNGChunks(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	var color []pngChunk
+	for _, chunk := range kept {
+		if _, ok := pngColorChunks[chunk.kind]; ok {
+			color = append(color, chunk)
+		}
+	}
+	return writePNGChunks(insertAfterIHDR(encoded, color)), nil
+}
+
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if len(data) < len(pngSignature) || !bytes.Equal(data[:len(pngSignature)], pngSignature) {
+		return nil, fmt.Errorf("%w: missing png signature", ErrMalformed)
+	}
+
+	var chunks []pngChunk
+	pos := len(pngSignature)
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, fmt.Errorf("%w: truncated png chunk header", ErrMalformed)
+		}
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) || end < pos {
+			return nil, fmt.Errorf("%w: png chunk overruns file", ErrMalformed)
+		}
+		kind := string(data[pos+4 : pos+8])
+		chunks = append(chunks, pngChunk{kind: kind, raw: data[pos:end]})
+		pos = end
+		if kind == "IEND" {
+			break
+		}
+	}
+	if len(chunks) == 0 || chunks[0].kind != "IHDR" {
+		return nil, fmt.Errorf("%w: png does not start with IHDR", ErrMalformed)
+	}
+	return chunks, nil
+}
+
+func writePNGChunks(chunks []pngChunk) []byte {
+	size := len(pngSignature)
+	for _, chunk := range chunks {
+		size += len(chunk.raw)
+	}
+	out := make([]byte, 0, size)
+	out = append(out, pngSignature...)
+	for _, chunk := range chunks {
+		out = append(out, chunk.raw...)
+	}
+	return out
+}
+
+func newPNGChunk(kind string, payload []byte) pngChunk {
+	raw := make([]byte, 12+len(payload))
+	binary.BigEndian.PutUint32(raw[0:4], uint32(len(payload)))
+	copy(raw[4:8], kind)
+	copy(raw[8:], payload)
+	binary.BigEndian.PutUint32(raw[8+len(payload):], crc32.ChecksumIEEE(raw[4:8+len(payload)]))
+	return pngChunk{kind: kind, raw: raw}
+}
+
+func insertAfterIHDR(chunks []pngChunk, extra []pngChunk) []pngChunk {
+	if len(extra) == 0 {
+		return chunks
+	}
+	out := make([]pngChunk, 0, len(chunks)+len(extra))
+	out = append(out, chunks[0])
+	out = append(out, extra...)
+	out = append(out, chunks[1:]...)
+	return out
+}
@@ -0,0 +1,28 @@
+package metadata
+
+import (
+	"errors"
+
+	"nodeimage/api/internal/media/sniffer"
+)
+
+var ErrMalformed = errors.New("malformed image container")
+
+// Strip removes EXIF (including GPS and maker notes), XMP and comment
+// metadata from the image. Orientation is applied to the pixels first where
+// the format can be re-encoded, so the result still displays upright.
+// Formats without metadata containers are returned unchanged.
+func Strip(mediaType sniffer.MediaType, data []byte) ([]byte, error) {
+	switch mediaType {
+	case sniffer.TypeJPEG:
+		return stripJPEG(data)
+	case sniffer.TypePNG, sniffer.TypeAPNG:
+		return stripPNG(data)
+	case sniffer.TypeWEBP:
+		return stripWEBP(data)
+	case sniffer.TypeAVIF, sniffer.TypeHEIC, sniffer.TypeHEIF:
+		return stripAVIF(data)
+	default:
+		return data, nil
+	}
+}
@@ -0,0 +1,272 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/webp"
+
+	"nodeimage/api/internal/media/sniffer"
+)
+
+// Orientation 6 means the stored pixels must be rotated 90° clockwise, so
+// source (x, y) lands at (h-1-y, x) in the upright image.
+const testOrientation = 6
+
+func testPattern(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(40 * x), G: uint8(60 * y), B: 200, A: 255})
+		}
+	}
+	return img
+}
+
+func pngWithOrientation(t *testing.T, img image.Image, extra ...pngChunk) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := readPNGChunks(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra = append(extra, newPNGChunk("eXIf", orientationOnlyEXIF(testOrientation)))
+	return writePNGChunks(insertAfterIHDR(chunks, extra))
+}
+
+func webpChunk(fourCC string, payload []byte) []byte {
+	chunk := make([]byte, 8, 8+len(payload)+1)
+	copy(chunk, fourCC)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func webpFile(chunks ...[]byte) []byte {
+	out := []byte("RIFF\x00\x00\x00\x00WEBP")
+	for _, chunk := range chunks {
+		out = append(out, chunk...)
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out
+}
+
+func webpVP8X(flags byte, w, h int) []byte {
+	payload := make([]byte, 10)
+	payload[0] = flags
+	putUint24(payload[4:7], w-1)
+	putUint24(payload[7:10], h-1)
+	return webpChunk("VP8X", payload)
+}
+
+func assertRotated(t *testing.T, src, got image.Image) {
+	t.Helper()
+	sb := src.Bounds()
+	if gb := got.Bounds(); gb.Dx() != sb.Dy() || gb.Dy() != sb.Dx() {
+		t.Fatalf("bounds = %v, want %dx%d", gb, sb.Dy(), sb.Dx())
+	}
+	for y := 0; y < sb.Dy(); y++ {
+		for x := 0; x < sb.Dx(); x++ {
+			want := color.NRGBA64Model.Convert(src.At(x, y))
+			have := color.NRGBA64Model.Convert(got.At(sb.Dy()-1-y, x))
+			if want != have {
+				t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, have, want)
+			}
+		}
+	}
+}
+
+func TestStripBakesPNGOrientation(t *testing.T) {
+	paletted := image.NewPaletted(image.Rect(0, 0, 3, 2), color.Palette{
+		color.NRGBA{R: 255, A: 255}, color.NRGBA{G: 255, A: 128}, color.NRGBA{B: 255, A: 0},
+	})
+	gray16 := image.NewGray16(image.Rect(0, 0, 3, 2))
+	nrgba64 := image.NewNRGBA64(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			paletted.SetColorIndex(x, y, uint8((x+y)%3))
+			gray16.SetGray16(x, y, color.Gray16{Y: uint16(1000*x + 20000*y + 7)})
+			nrgba64.SetNRGBA64(x, y, color.NRGBA64{R: uint16(300*x + 1), G: uint16(500*y + 3), B: 65535, A: 40000})
+		}
+	}
+
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"nrgba", testPattern(3, 2)},
+		{"paletted", paletted},
+		{"gray16", gray16},
+		{"nrgba64", nrgba64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iccp := newPNGChunk("iCCP", []byte("test\x00\x00profile"))
+			data := pngWithOrientation(t, tt.img, iccp)
+			src, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := Strip(sniffer.TypePNG, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(out, []byte("eXIf")) {
+				t.Fatal("eXIf chunk survived")
+			}
+			if !bytes.Contains(out, []byte("iCCP")) {
+				t.Fatal("iCCP chunk dropped")
+			}
+
+			got, err := png.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprintf("%T", got) != fmt.Sprintf("%T", src) {
+				t.Fatalf("decoded %T, want %T", got, src)
+			}
+			if p, ok := src.(*image.Paletted); ok && len(got.(*image.Paletted).Palette) != len(p.Palette) {
+				t.Fatal("palette changed")
+			}
+			assertRotated(t, src, got)
+		})
+	}
+}
+
+func TestStripKeepsAPNGOrientationTag(t *testing.T) {
+	img := testPattern(3, 2)
+	actl := newPNGChunk("acTL", []byte{0, 0, 0, 1, 0, 0, 0, 0})
+	out, err := Strip(sniffer.TypeAPNG, pngWithOrientation(t, img, actl))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, err := readPNGChunks(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, chunk := range chunks {
+		if chunk.kind == "eXIf" {
+			found = true
+			if got := exifOrientation(chunk.raw[8 : len(chunk.raw)-4]); got != testOrientation {
+				t.Fatalf("orientation = %d, want %d", got, testOrientation)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("orientation-only eXIf missing from APNG")
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 3 || cfg.Height != 2 {
+		t.Fatalf("APNG resized to %dx%d", cfg.Width, cfg.Height)
+	}
+}
+
+func TestStripBakesJPEGOrientation(t *testing.T) {
+	tests := []struct {
+		name  string
+		img   image.Image
+		model color.Model
+	}{
+		{"ycbcr", testPattern(32, 16), color.YCbCrModel},
+		{"gray", image.NewGray(image.Rect(0, 0, 32, 16)), color.GrayModel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, tt.img, &jpeg.Options{Quality: 90}); err != nil {
+				t.Fatal(err)
+			}
+			out, err := Strip(sniffer.TypeJPEG, insertJPEGOrientation(buf.Bytes(), testOrientation))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(out, exifHeader) {
+				t.Fatal("EXIF survived")
+			}
+			got, err := jpeg.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := got.Bounds(); b.Dx() != 16 || b.Dy() != 32 {
+				t.Fatalf("bounds = %v, want 16x32", b)
+			}
+			if got.ColorModel() != tt.model {
+				t.Fatalf("colour model = %v, want %v", got.ColorModel(), tt.model)
+			}
+		})
+	}
+}
+
+func TestStripBakesWEBPOrientation(t *testing.T) {
+	img := testPattern(3, 2)
+	var buf bytes.Buffer
+	if err := nativewebp.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	vp8l := buf.Bytes()[12:]
+	iccp := webpChunk("ICCP", []byte("profile"))
+	exif := webpChunk("EXIF", orientationOnlyEXIF(testOrientation))
+	xmp := webpChunk("XMP ", []byte("<x:xmpmeta/>"))
+	data := webpFile(webpVP8X(vp8xFlagICC|vp8xFlagEXIF|vp8xFlagXMP, 3, 2), iccp, vp8l, exif, xmp)
+
+	out, err := Strip(sniffer.TypeWEBP, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, []byte("EXIF")) || bytes.Contains(out, []byte("XMP ")) {
+		t.Fatal("metadata chunk survived")
+	}
+	if !bytes.Contains(out, iccp) {
+		t.Fatal("ICCP chunk dropped")
+	}
+
+	got, err := webp.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRotated(t, img, got)
+}
+
+func TestStripKeepsAnimatedWEBPOrientationTag(t *testing.T) {
+	anim := webpChunk("ANIM", make([]byte, 6))
+	exif := webpChunk("EXIF", orientationOnlyEXIF(testOrientation))
+	frame := webpChunk("ANMF", make([]byte, 16))
+	data := webpFile(webpVP8X(vp8xFlagAnimation|vp8xFlagEXIF, 3, 2), anim, frame, exif)
+
+	out, err := Strip(sniffer.TypeWEBP, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, frame) {
+		t.Fatal("animation frame changed")
+	}
+	idx := bytes.Index(out, []byte("EXIF"))
+	if idx < 0 {
+		t.Fatal("orientation-only EXIF missing from animated WebP")
+	}
+	size := int(binary.LittleEndian.Uint32(out[idx+4 : idx+8]))
+	if got := exifOrientation(out[idx+8 : idx+8+size]); got != testOrientation {
+		t.Fatalf("orientation = %d, want %d", got, testOrientation)
+	}
+	if out[20]&vp8xFlagEXIF == 0 {
+		t.Fatal("VP8X EXIF flag cleared")
+	}
+}
@@ -0,0 +1,138 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/webp"
+)
+
+const (
+	vp8xFlagICC       = 0x20
+	vp8xFlagAlpha     = 0x10
+	vp8xFlagEXIF      = 0x08
+	vp8xFlagXMP       = 0x04
+	vp8xFlagAnimation = 0x02
+)
+
+// stripWEBP removes the EXIF and XMP chunks and clears the matching VP8X
+// flags. A non-default orientation is baked into still images by
+// re-encoding them as lossless WebP, the only mode the pure-Go encoder
+// offers. x/image/webp cannot decode animation frames, so animated files
+// keep an orientation-only EXIF chunk instead and their frames survive
+// untouched.
+func stripWEBP(data []byte) ([]byte, error) {
+	if len(data) < 12 || !bytes.Equal(data[:4], []byte("RIFF")) || !bytes.Equal(data[8:12], []byte("WEBP")) {
+		return nil, fmt.Errorf("%w: missing webp RIFF header", ErrMalformed)
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+
+	orientation := 1
+	vp8xFlags := -1
+	animated := false
+	var iccp []byte
+	pos := 12
+	for pos+8 <= len(data) {
+		fourCC := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		end := pos + 8 + size + size%2
+		if size < 0 || pos+8+size > len(data) {
+			return nil, fmt.Errorf("%w: webp chunk overruns file", ErrMalformed)
+		}
+		if end > len(data) {
+			end = len(data)
+		}
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+			if fourCC == "EXIF" {
+				payload := bytes.TrimPrefix(data[pos+8:pos+8+size], exifHeader)
+				orientation = exifOrientation(payload)
+			}
+		default:
+			switch fourCC {
+			case "VP8X":
+				if size >= 1 {
+					vp8xFlags = len(out) + 8
+					animated = data[pos+8]&vp8xFlagAnimation != 0
+				}
+			case "ANIM":
+				animated = true
+			case "ICCP":
+				iccp = data[pos:end]
+			}
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+
+	if orientation > 1 && !animated {
+		return reorientWEBP(out, orientation, iccp)
+	}
+
+	if vp8xFlags >= 0 {
+		out[vp8xFlags] &^= vp8xFlagEXIF | vp8xFlagXMP
+		if orientation > 1 {
+			out[vp8xFlags] |= vp8xFlagEXIF
+			exif := orientationOnlyEXIF(orientation)
+			header := make([]byte, 8)
+			copy(header, "EXIF")
+			binary.LittleEndian.PutUint32(header[4:], uint32(len(exif)))
+			out = append(out, header...)
+			out = append(out, exif...)
+		}
+	}
+
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, nil
+}
+
+// reorientWEBP decodes a still WebP, applies the orientation and encodes it
+// again. The original ICC profile chunk is carried over behind a VP8X
+// header so colours are preserved.
+func reorientWEBP(stripped []byte, orientation int, iccp []byte) ([]byte, error) {
+	img, err := webp.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		return nil, fmt.Errorf("decode webp for orientation: %w", err)
+	}
+	oriented := applyOrientation(img, orientation)
+
+	var buf bytes.Buffer
+	if err := nativewebp.Encode(&buf, oriented, nil); err != nil {
+		return nil, fmt.Errorf("encode webp: %w", err)
+	}
+	encoded := buf.Bytes()
+	if iccp == nil {
+		return encoded, nil
+	}
+
+	var flags byte = vp8xFlagICC
+	if opaque, ok := oriented.(interface{ Opaque() bool }); !ok || !opaque.Opaque() {
+		flags |= vp8xFlagAlpha
+	}
+	bounds := oriented.Bounds()
+	vp8x := make([]byte, 18)
+	copy(vp8x, "VP8X")
+	binary.LittleEndian.PutUint32(vp8x[4:8], 10)
+	vp8x[8] = flags
+	putUint24(vp8x[12:15], bounds.Dx()-1)
+	putUint24(vp8x[15:18], bounds.Dy()-1)
+
+	out := make([]byte, 0, len(encoded)+len(vp8x)+len(iccp))
+	out = append(out, encoded[:12]...)
+	out = append(out, vp8x...)
+	out = append(out, iccp...)
+	out = append(out, encoded[12:]...)
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, nil
+}
+
+func putUint24(b []byte, v int) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
@@ -0,0 +1,154 @@
+package sniffer
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+type bmffBox struct {
+	kind     string
+	contents int
+	end      int
+}
+
+// readBoxes lists the ISO-BMFF boxes in data[start:end]. It stops at the
+// first box whose header or size does not fit, so truncated input yields the
+// boxes that could be read.
+func readBoxes(data []byte, start, end int) []bmffBox {
+	var boxes []bmffBox
+	pos := start
+	for pos+8 <= end {
+		size := uint64(binary.BigEndian.Uint32(data[pos : pos+4]))
+		kind := string(data[pos+4 : pos+8])
+		header := 8
+		switch size {
+		case 0:
+			size = uint64(end - pos)
+		case 1:
+			if pos+16 > end {
+				return boxes
+			}
+			size = binary.BigEndian.Uint64(data[pos+8 : pos+16])
+			header = 16
+		}
+		if size < uint64(header) || size > uint64(end-pos) {
+			return boxes
+		}
+		boxes = append(boxes, bmffBox{kind: kind, contents: pos + header, end: pos + int(size)})
+		pos += int(size)
+	}
+	return boxes
+}
+
+// isBoxType accepts printable four-character codes; anything else after the
+// last box is treated as trailing data rather than another box.
+func isBoxType(kind []byte) bool {
+	for _, b := range kind {
+		if b < 0x20 || b > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func findBox(boxes []bmffBox, kind string) (bmffBox, bool) {
+	for _, box := range boxes {
+		if box.kind == kind {
+			return box, true
+		}
+	}
+	return bmffBox{}, false
+}
+
+// findPath descends through nested boxes by type. fullBoxes lists the types
+// whose contents start with a 4-byte version/flags header.
+func findPath(data []byte, boxes []bmffBox, path ...string) (bmffBox, bool) {
+	var box bmffBox
+	for i, kind := range path {
+		var ok bool
+		box, ok = findBox(boxes, kind)
+		if !ok {
+			return bmffBox{}, false
+		}
+		if i < len(path)-1 {
+			start := box.contents
+			if _, full := fullBoxes[kind]; full {
+				start += 4
+			}
+			if start > box.end {
+				return bmffBox{}, false
+			}
+			boxes = readBoxes(data, start, box.end)
+		}
+	}
+	return box, true
+}
+
+var fullBoxes = map[string]struct{}{
+	"meta": {},
+}
+
+// ftypBrands is the parsed ftyp box: the major brand followed by the
+// compatible brands, which is how HEIF-family files declare their codec and
+// whether they hold a still image or a sequence.
+type ftypBrands struct {
+	major      string
+	compatible []string
+}
+
+var (
+	avifBrands         = []string{"avif", "avio", "avis"}
+	heicBrands         = []string{"heic", "heix", "heim", "heis", "hevc", "hevx", "hevm", "hevs"}
+	heicSequenceBrands = []string{"hevc", "hevx", "hevm", "hevs"}
+)
+
+// parseFTYP requires ftyp to be the first box and to fit in data, with a
+// printable major brand and whole compatible-brand entries.
+func parseFTYP(data []byte) (ftypBrands, bool) {
+	if len(data) < 16 || string(data[4:8]) != "ftyp" {
+		return ftypBrands{}, false
+	}
+	size := int(binary.BigEndian.Uint32(data[0:4]))
+	if size < 16 || size > len(data) || (size-16)%4 != 0 || !isBoxType(data[8:12]) {
+		return ftypBrands{}, false
+	}
+
+	brands := ftypBrands{major: string(data[8:12])}
+	for pos := 16; pos < size; pos += 4 {
+		brands.compatible = append(brands.compatible, string(data[pos:pos+4]))
+	}
+	return brands, true
+}
+
+func (b ftypBrands) has(candidates ...string) bool {
+	return slices.Contains(candidates, b.major) || slices.ContainsFunc(b.compatible, func(brand string) bool {
+		return slices.Contains(candidates, brand)
+	})
+}
+
+// mediaType checks codec brands before the generic mif1/msf1 structural
+// brands, which every HEIF-family file lists alongside its codec brand.
+func (b ftypBrands) mediaType() (MediaType, bool) {
+	switch {
+	case b.has(avifBrands...):
+		return TypeAVIF, true
+	case b.has(heicBrands...):
+		return TypeHEIC, true
+	case b.has("mif1", "msf1"):
+		return TypeHEIF, true
+	}
+	return "", false
+}
+
+// sequence reports an image sequence: avis anywhere marks animated AVIF,
+// while for HEIF the major brand says whether the primary content is a
+// still image or a track.
+func (b ftypBrands) sequence() bool {
+	switch {
+	case b.has("avis"):
+		return true
+	case slices.Contains(heicSequenceBrands, b.major), b.major == "msf1":
+		return true
+	}
+	return !b.has("mif1", "avif", "heic", "heix") && b.has("msf1")
+}
@@ -0,0 +1,378 @@
+package sniffer
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+type ColorType string
+
+const (
+	ColorGray    ColorType = "gray"
+	ColorRGB     ColorType = "rgb"
+	ColorPalette ColorType = "palette"
+	ColorYCbCr   ColorType = "ycbcr"
+	ColorCMYK    ColorType = "cmyk"
+)
+
+type pixelFormat struct {
+	bitDepth  int
+	colorType ColorType
+	hasAlpha  bool
+	animated  bool
+}
+
+// Inspect fills in the dimensions and pixel format of a detected result from
+// the container headers alone, so callers can store sizes and plan
+// processing without decoding pixel data.
+func Inspect(result Result, data []byte) (Result, error) {
+	dimensions, err := ParseDimensions(result.Type, data)
+	if err != nil {
+		return Result{}, err
+	}
+	result.Width = dimensions.Width
+	result.Height = dimensions.Height
+	result.Frames = dimensions.Frames
+
+	var format pixelFormat
+	switch result.Type {
+	case TypeJPEG:
+		format = jpegFormat(data)
+	case TypePNG, TypeAPNG:
+		format = pngFormat(data)
+	case TypeGIF:
+		format = gifFormat(data)
+	case TypeWEBP:
+		format = webpFormat(data)
+	case TypeAVIF, TypeHEIC, TypeHEIF:
+		format = avifFormat(data)
+	case TypeBMP:
+		format = bmpFormat(data)
+	case TypeTIFF:
+		format = tiffFormat(data)
+	case TypeICO:
+		format = icoFormat(data)
+	case TypeJXL:
+		format = jxlFormat(data)
+	case TypeSVG:
+		format = pixelFormat{colorType: ColorRGB, hasAlpha: true}
+	}
+	result.BitDepth = format.bitDepth
+	result.ColorType = format.colorType
+	result.HasAlpha = format.hasAlpha
+	result.Animated = result.Animated || format.animated || result.Frames > 1
+	return result, nil
+}
+
+func jpegFormat(data []byte) pixelFormat {
+	sof, err := jpegFrameHeader(data)
+	if err != nil {
+		return pixelFormat{}
+	}
+	format := pixelFormat{bitDepth: int(sof[0])}
+	switch sof[5] {
+	case 1:
+		format.colorType = ColorGray
+	case 3:
+		format.colorType = ColorYCbCr
+	case 4:
+		format.colorType = ColorCMYK
+	}
+	return format
+}
+
+// pngFormat reads IHDR and treats a tRNS chunk before the image data as
+// alpha, since palette and truecolor images can carry transparency there.
+func pngFormat(data []byte) pixelFormat {
+	if len(data) < 26 || string(data[12:16]) != "IHDR" {
+		return pixelFormat{}
+	}
+	format := pixelFormat{bitDepth: int(data[24])}
+	switch data[25] {
+	case 0:
+		format.colorType = ColorGray
+	case 2:
+		format.colorType = ColorRGB
+	case 3:
+		format.colorType = ColorPalette
+	case 4:
+		format.colorType = ColorGray
+		format.hasAlpha = true
+	case 6:
+		format.colorType = ColorRGB
+		format.hasAlpha = true
+	}
+
+	pos := 8
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		switch string(data[pos+4 : pos+8]) {
+		case "tRNS":
+			format.hasAlpha = true
+		case "acTL":
+			format.animated = true
+		case "IDAT", "IEND":
+			return format
+		}
+		if length < 0 || pos+12+length > len(data) {
+			break
+		}
+		pos += 12 + length
+	}
+	return format
+}
+
+// gifFormat reports the depth of the largest colour table and alpha if any
+// graphic control extension sets a transparent index.
+func gifFormat(data []byte) pixelFormat {
+	if len(data) < 13 {
+		return pixelFormat{}
+	}
+	format := pixelFormat{colorType: ColorPalette}
+
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		format.bitDepth = int(flags&0x07) + 1
+		pos += 3 << ((flags & 0x07) + 1)
+	}
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x2c:
+			if pos+10 > len(data) {
+				return format
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				format.bitDepth = max(format.bitDepth, int(flags&0x07)+1)
+				pos += 3 << ((flags & 0x07) + 1)
+			}
+			pos = skipGIFSubBlocks(data, pos+1)
+		case 0x21:
+			if pos+4 <= len(data) && data[pos+1] == 0xf9 && data[pos+2] >= 4 && data[pos+3]&0x01 != 0 {
+				format.hasAlpha = true
+			}
+			pos = skipGIFSubBlocks(data, pos+2)
+		default:
+			return format
+		}
+	}
+	return format
+}
+
+func webpFormat(data []byte) pixelFormat {
+	format := pixelFormat{bitDepth: 8, colorType: ColorYCbCr}
+	pos := 12
+	for pos+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		payload := data[pos+8 : min(len(data), pos+8+size)]
+
+		switch string(data[pos : pos+4]) {
+		case "VP8X":
+			if len(payload) > 0 {
+				format.hasAlpha = payload[0]&0x10 != 0
+				format.animated = payload[0]&0x02 != 0
+			}
+		case "VP8L":
+			format.colorType = ColorRGB
+			if len(payload) >= 5 && binary.LittleEndian.Uint32(payload[1:5])>>28&0x01 != 0 {
+				format.hasAlpha = true
+			}
+		case "ALPH":
+			format.hasAlpha = true
+		}
+		pos += 8 + size + size%2
+	}
+	return format
+}
+
+var alphaAuxiliaryTypes = [][]byte{
+	[]byte("urn:mpeg:mpegB:cicp:systems:auxiliary:alpha"),
+	[]byte("urn:mpeg:hevc:2015:auxid:1"),
+}
+
+// avifFormat reads the pixi property for channel count and depth, and treats
+// an auxC alpha property as alpha. A moov box means an image sequence.
+func avifFormat(data []byte) pixelFormat {
+	boxes := readBoxes(data, 0, len(data))
+	format := pixelFormat{bitDepth: 8, colorType: ColorYCbCr}
+	_, format.animated = findBox(boxes, "moov")
+
+	ipco, ok := findPath(data, boxes, "meta", "iprp", "ipco")
+	if !ok {
+		return format
+	}
+	for _, prop := range readBoxes(data, ipco.contents, ipco.end) {
+		switch prop.kind {
+		case "pixi":
+			if prop.contents+6 > prop.end {
+				continue
+			}
+			if channels := data[prop.contents+4]; channels == 1 {
+				format.colorType = ColorGray
+			}
+			format.bitDepth = max(format.bitDepth, int(data[prop.contents+5]))
+		case "auxC":
+			if prop.contents+4 > prop.end {
+				continue
+			}
+			auxType := data[prop.contents+4 : prop.end]
+			for _, alpha := range alphaAuxiliaryTypes {
+				if bytes.HasPrefix(auxType, alpha) {
+					format.hasAlpha = true
+				}
+			}
+		}
+	}
+	return format
+}
+
+// bmpFormat derives depth from bits per pixel; 32-bit bitmaps only count as
+// alpha when the header carries an alpha mask.
+func bmpFormat(data []byte) pixelFormat {
+	if len(data) < 30 {
+		return pixelFormat{}
+	}
+	headerSize := binary.LittleEndian.Uint32(data[14:18])
+	var bpp uint16
+	if headerSize == 12 {
+		bpp = binary.LittleEndian.Uint16(data[24:26])
+	} else {
+		bpp = binary.LittleEndian.Uint16(data[28:30])
+	}
+
+	switch {
+	case bpp <= 8:
+		return pixelFormat{bitDepth: int(bpp), colorType: ColorPalette}
+	case bpp == 16:
+		return pixelFormat{bitDepth: 5, colorType: ColorRGB}
+	}
+	format := pixelFormat{bitDepth: 8, colorType: ColorRGB}
+	if bpp == 32 && headerSize >= 56 && len(data) >= 70 {
+		format.hasAlpha = binary.LittleEndian.Uint32(data[66:70]) != 0
+	}
+	return format
+}
+
+const (
+	tiffTagBitsPerSample = 258
+	tiffTagPhotometric   = 262
+	tiffTagExtraSamples  = 338
+)
+
+// tiffFormat reads the first IFD only; later pages are assumed to match.
+func tiffFormat(data []byte) pixelFormat {
+	if len(data) < 8 {
+		return pixelFormat{}
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[0] == 'M' {
+		order = binary.BigEndian
+	}
+	pos := int(order.Uint32(data[4:8]))
+	if pos+2 > len(data) {
+		return pixelFormat{}
+	}
+	count := int(order.Uint16(data[pos : pos+2]))
+	if pos+2+count*12 > len(data) {
+		return pixelFormat{}
+	}
+
+	var format pixelFormat
+	for i := 0; i < count; i++ {
+		entry := data[pos+2+i*12 : pos+14+i*12]
+		switch order.Uint16(entry[0:2]) {
+		case tiffTagBitsPerSample:
+			format.bitDepth = tiffFirstShort(data, order, entry)
+		case tiffTagPhotometric:
+			switch order.Uint16(entry[8:10]) {
+			case 0, 1:
+				format.colorType = ColorGray
+			case 2:
+				format.colorType = ColorRGB
+			case 3:
+				format.colorType = ColorPalette
+			case 5:
+				format.colorType = ColorCMYK
+			case 6:
+				format.colorType = ColorYCbCr
+			}
+		case tiffTagExtraSamples:
+			format.hasAlpha = true
+		}
+	}
+	return format
+}
+
+// tiffFirstShort returns the first SHORT of an entry, following the value
+// offset when more than two values do not fit inline.
+func tiffFirstShort(data []byte, order binary.ByteOrder, entry []byte) int {
+	if order.Uint32(entry[4:8]) <= 2 {
+		return int(order.Uint16(entry[8:10]))
+	}
+	offset := int(order.Uint32(entry[8:12]))
+	if offset+2 > len(data) {
+		return 0
+	}
+	return int(order.Uint16(data[offset : offset+2]))
+}
+
+// icoFormat describes the largest directory entry. Entries stored as PNG
+// report zero bits per pixel in the directory and are read from the PNG.
+func icoFormat(data []byte) pixelFormat {
+	if len(data) < 6 {
+		return pixelFormat{}
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:6]))
+	if 6+count*16 > len(data) {
+		return pixelFormat{}
+	}
+
+	var best []byte
+	bestArea := -1
+	for i := 0; i < count; i++ {
+		entry := data[6+i*16 : 22+i*16]
+		width, height := int(entry[0]), int(entry[1])
+		if width == 0 {
+			width = 256
+		}
+		if height == 0 {
+			height = 256
+		}
+		if width*height > bestArea {
+			best, bestArea = entry, width*height
+		}
+	}
+	if best == nil {
+		return pixelFormat{}
+	}
+
+	size := int(binary.LittleEndian.Uint32(best[8:12]))
+	offset := int(binary.LittleEndian.Uint32(best[12:16]))
+	if offset >= 0 && offset+size <= len(data) && isPNG(data[offset:offset+size]) {
+		return pngFormat(data[offset : offset+size])
+	}
+	switch bpp := int(binary.LittleEndian.Uint16(best[6:8])); {
+	case bpp == 32:
+		return pixelFormat{bitDepth: 8, colorType: ColorRGB, hasAlpha: true}
+	case bpp > 8:
+		return pixelFormat{bitDepth: 8, colorType: ColorRGB}
+	default:
+		return pixelFormat{bitDepth: bpp, colorType: ColorPalette}
+	}
+}
+
+// jxlFormat only understands the all_default image metadata (8-bit sRGB, no
+// extra channels); anything else is left for the worker to read.
+func jxlFormat(data []byte) pixelFormat {
+	codestream := jxlCodestream(data)
+	if len(codestream) < 2 {
+		return pixelFormat{}
+	}
+	r := bitReader{data: codestream[2:]}
+	readJXLSize(&r)
+	if allDefault := r.bits(1); r.overrun || allDefault != 1 {
+		return pixelFormat{}
+	}
+	return pixelFormat{bitDepth: 8, colorType: ColorRGB}
+}
@@ -2,6 +2,7 @@ package sniffer
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"io"
 	"net/http"
@@ -13,17 +14,70 @@ type MediaType string
 const (
 	TypeJPEG MediaType = "jpeg"
 	TypePNG  MediaType = "png"
+	TypeAPNG MediaType = "apng"
 	TypeGIF  MediaType = "gif"
 	TypeWEBP MediaType = "webp"
 	TypeAVIF MediaType = "avif"
+	TypeHEIC MediaType = "heic"
+	TypeHEIF MediaType = "heif"
+	TypeBMP  MediaType = "bmp"
+	TypeTIFF MediaType = "tiff"
+	TypeICO  MediaType = "ico"
+	TypeJXL  MediaType = "jxl"
 	TypeSVG  MediaType = "svg"
 )
 
+var mimeTypes = map[MediaType]string{
+	TypeJPEG: "image/jpeg",
+	TypePNG:  "image/png",
+	TypeAPNG: "image/apng",
+	TypeGIF:  "image/gif",
+	TypeWEBP: "image/webp",
+	TypeAVIF: "image/avif",
+	TypeHEIC: "image/heic",
+	TypeHEIF: "image/heif",
+	TypeBMP:  "image/bmp",
+	TypeTIFF: "image/tiff",
+	TypeICO:  "image/x-icon",
+	TypeJXL:  "image/jxl",
+	TypeSVG:  "image/svg+xml",
+}
+
+// mimeAliases lists other Content-Type values clients commonly send for a
+// type. APNG files keep the .png extension, so browsers label them image/png.
+var mimeAliases = map[MediaType][]string{
+	TypeJPEG: {"image/jpg", "image/pjpeg"},
+	TypeAPNG: {"image/png", "image/vnd.mozilla.apng"},
+	TypeBMP:  {"image/x-bmp", "image/x-ms-bmp"},
+	TypeICO:  {"image/vnd.microsoft.icon"},
+	TypeAVIF: {"image/avif-sequence"},
+	TypeHEIC: {"image/heif", "image/heic-sequence"},
+	TypeHEIF: {"image/heif-sequence"},
+}
+
 var ErrUnknownType = errors.New("unknown media type")
 
+// Result describes a detected file. Detection only fills Type and MIME; the
+// remaining fields are read from the headers by Inspect. Zero values mean the
+// header does not say.
 type Result struct {
-	Type MediaType
-	MIME string
+	Type      MediaType
+	MIME      string
+	Width     int
+	Height    int
+	Frames    int
+	BitDepth  int
+	ColorType ColorType
+	HasAlpha  bool
+	Animated  bool
+
+	// Compressed marks gzip-wrapped SVG (svgz) that must be inflated with
+	// DecompressSVGZ before use.
+	Compressed bool
+}
+
+func (r Result) Dimensions() Dimensions {
+	return Dimensions{Width: r.Width, Height: r.Height, Frames: r.Frames}
 }
 
 func Detect(r io.Reader) (Result, []byte, error) {
@@ -43,26 +97,102 @@ func DetectHead(head []byte) (Result, error) {
 		return Result{}, ErrUnknownType
 	}
 
-	if isJPEG(head) {
-		return Result{Type: TypeJPEG, MIME: "image/jpeg"}, nil
+	if isSVGZ(head) {
+		return newSVGResult(head), nil
 	}
-	if isPNG(head) {
-		return Result{Type: TypePNG, MIME: "image/png"}, nil
+	mediaType, ok := detectType(head)
+	if !ok {
+		return Result{}, ErrUnknownType
 	}
-	if isGIF(head) {
-		return Result{Type: TypeGIF, MIME: "image/gif"}, nil
+	result := newResult(mediaType)
+	if brands, ok := parseFTYP(head); ok {
+		result.Animated = brands.sequence()
 	}
-	if isWEBP(head) {
-		return Result{Type: TypeWEBP, MIME: "image/webp"}, nil
+	return result, nil
+}
+
+// DetectBytes detects the type from the first 512 bytes like DetectHead, but
+// also scans the full data for an APNG acTL chunk, which can sit behind a
+// large iCCP chunk, and reads further into documents looking for an SVG root.
+func DetectBytes(data []byte) (Result, error) {
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
 	}
-	if isAVIF(head) {
-		return Result{Type: TypeAVIF, MIME: "image/avif"}, nil
+	result, err := DetectHead(head)
+	if errors.Is(err, ErrUnknownType) && len(data) > len(head) {
+		// The svg root can sit behind a long comment or DOCTYPE.
+		if sniff := data[:min(len(data), svgSniffLimit)]; isSVG(sniff) || isSVGZ(sniff) {
+			result, err = newSVGResult(sniff), nil
+		}
+	}
+	if err != nil {
+		return Result{}, err
 	}
-	if isSVG(head) {
-		return Result{Type: TypeSVG, MIME: "image/svg+xml"}, nil
+	if result.Type == TypePNG && isAPNG(data) {
+		return newResult(TypeAPNG), nil
 	}
+	return result, nil
+}
+
+func MIMEType(mediaType MediaType) string {
+	return mimeTypes[mediaType]
+}
+
+// MatchesMIME reports whether a client-declared Content-Type is acceptable
+// for the detected type.
+func MatchesMIME(declared string, result Result) bool {
+	if declared == result.MIME {
+		return true
+	}
+	for _, alias := range mimeAliases[result.Type] {
+		if declared == alias {
+			return true
+		}
+	}
+	return false
+}
 
-	return Result{}, ErrUnknownType
+func newResult(mediaType MediaType) Result {
+	return Result{Type: mediaType, MIME: mimeTypes[mediaType]}
+}
+
+func newSVGResult(head []byte) Result {
+	result := newResult(TypeSVG)
+	result.Compressed = bytes.HasPrefix(head, gzipMagic)
+	return result
+}
+
+func detectType(head []byte) (MediaType, bool) {
+	switch {
+	case isJPEG(head):
+		return TypeJPEG, true
+	case isPNG(head):
+		if isAPNG(head) {
+			return TypeAPNG, true
+		}
+		return TypePNG, true
+	case isGIF(head):
+		return TypeGIF, true
+	case isWEBP(head):
+		return TypeWEBP, true
+	}
+	if brands, ok := parseFTYP(head); ok {
+		return brands.mediaType()
+	}
+	switch {
+	case isJXL(head):
+		return TypeJXL, true
+	case isTIFF(head):
+		return TypeTIFF, true
+	case isBMP(head):
+		return TypeBMP, true
+	case isICO(head):
+		return TypeICO, true
+	case isSVG(head):
+		return TypeSVG, true
+	}
+	return "", false
 }
 
 func isJPEG(head []byte) bool {
@@ -72,11 +202,31 @@ func isJPEG(head []byte) bool {
 		head[2] == 0xff
 }
 
+var pngMagic = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+
 func isPNG(head []byte) bool {
-	pngMagic := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
 	return len(head) >= len(pngMagic) && bytes.Equal(head[:len(pngMagic)], pngMagic)
 }
 
+// isAPNG walks the PNG chunks preceding the first IDAT looking for acTL.
+func isAPNG(data []byte) bool {
+	pos := 8
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		switch string(data[pos+4 : pos+8]) {
+		case "acTL":
+			return true
+		case "IDAT", "IEND":
+			return false
+		}
+		if length < 0 || length > len(data) {
+			return false
+		}
+		pos += 12 + length
+	}
+	return false
+}
+
 func isGIF(head []byte) bool {
 	return len(head) >= 6 && (bytes.Equal(head[:6], []byte("GIF87a")) || bytes.Equal(head[:6], []byte("GIF89a")))
 }
@@ -87,17 +237,39 @@ func isWEBP(head []byte) bool {
 		bytes.Equal(head[8:12], []byte("WEBP"))
 }
 
-func isAVIF(head []byte) bool {
-	if len(head) < 12 {
+var jxlContainerMagic = []byte{0x00, 0x00, 0x00, 0x0c, 'J', 'X', 'L', ' ', 0x0d, 0x0a, 0x87, 0x0a}
+
+func isJXL(head []byte) bool {
+	return bytes.HasPrefix(head, []byte{0xff, 0x0a}) || bytes.HasPrefix(head, jxlContainerMagic)
+}
+
+func isTIFF(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("II*\x00")) ||
+		bytes.HasPrefix(head, []byte("MM\x00*")) ||
+		bytes.HasPrefix(head, []byte("II+\x00")) ||
+		bytes.HasPrefix(head, []byte("MM\x00+"))
+}
+
+// isBMP requires a known DIB header size after the "BM" magic, since two
+// ASCII letters alone match plenty of text files.
+func isBMP(head []byte) bool {
+	if len(head) < 18 || !bytes.HasPrefix(head, []byte("BM")) {
 		return false
 	}
-	boxType := string(head[8:12])
-	return boxType == "ftyp" && bytes.Contains(head[12:], []byte("avif"))
+	switch binary.LittleEndian.Uint32(head[14:18]) {
+	case 12, 16, 40, 52, 56, 64, 108, 124:
+		return true
+	}
+	return false
 }
 
-func isSVG(head []byte) bool {
-	trimmed := strings.TrimSpace(string(head))
-	return strings.HasPrefix(trimmed, "<svg") || strings.HasPrefix(trimmed, "<?xml")
+func isICO(head []byte) bool {
+	if len(head) < 22 || !bytes.HasPrefix(head, []byte{0x00, 0x00, 0x01, 0x00}) {
+		return false
+	}
+	count := binary.LittleEndian.Uint16(head[4:6])
+	// The first directory entry's reserved byte must be zero.
+	return count > 0 && head[9] == 0
 }
 
 func MimeTypeFromHTTP(header http.Header) string {
@@ -0,0 +1,92 @@
+package sniffer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	svgNamespace = "http://www.w3.org/2000/svg"
+
+	// svgSniffLimit bounds how much of a document DetectBytes reads looking
+	// for the root element behind long license comments.
+	svgSniffLimit = 64 << 10
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	utf8BOM   = []byte{0xef, 0xbb, 0xbf}
+
+	ErrSVGTooLarge = errors.New("svg exceeds size limit")
+)
+
+// isSVG skips the XML declaration, comments, DOCTYPE and whitespace, then
+// requires the root element to be svg in the SVG namespace. A truncated head
+// that ends before the root element is not SVG.
+func isSVG(head []byte) bool {
+	decoder := xml.NewDecoder(bytes.NewReader(bytes.TrimPrefix(head, utf8BOM)))
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		// The prolog and root tag are ASCII in any charset worth accepting.
+		return input, nil
+	}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+		switch t := token.(type) {
+		case xml.ProcInst, xml.Comment, xml.Directive:
+			continue
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) > 0 {
+				return false
+			}
+		case xml.StartElement:
+			return t.Name.Local == "svg" && t.Name.Space == svgNamespace
+		default:
+			return false
+		}
+	}
+}
+
+// isSVGZ inflates as much of a gzip stream as the head holds and checks that
+// for an SVG root.
+func isSVGZ(head []byte) bool {
+	if !bytes.HasPrefix(head, gzipMagic) {
+		return false
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(head))
+	if err != nil {
+		return false
+	}
+	inflated, _ := io.ReadAll(io.LimitReader(reader, svgSniffLimit))
+	return isSVG(inflated)
+}
+
+// DecompressSVGZ inflates an svgz upload, failing once the output would
+// exceed maxBytes so a small file cannot expand without bound. Zero means
+// unlimited.
+func DecompressSVGZ(data []byte, maxBytes int64) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedHeader, err)
+	}
+	defer reader.Close()
+
+	var source io.Reader = reader
+	if maxBytes > 0 {
+		source = io.LimitReader(reader, maxBytes+1)
+	}
+	inflated, err := io.ReadAll(source)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedHeader, err)
+	}
+	if maxBytes > 0 && int64(len(inflated)) > maxBytes {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrSVGTooLarge, maxBytes)
+	}
+	return inflated, nil
+}
@@ -0,0 +1,450 @@
+package sniffer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+var ErrInvalidStructure = errors.New("invalid file structure")
+
+type Reason string
+
+const (
+	ReasonTruncated       Reason = "truncated"
+	ReasonBadChunkLength  Reason = "bad_chunk_length"
+	ReasonBadChunkCRC     Reason = "bad_chunk_crc"
+	ReasonMissingChunk    Reason = "missing_chunk"
+	ReasonBadSegment      Reason = "bad_segment"
+	ReasonMissingEOI      Reason = "missing_eoi"
+	ReasonBadBlock        Reason = "bad_block"
+	ReasonBadRIFFSize     Reason = "bad_riff_size"
+	ReasonBadBoxSize      Reason = "bad_box_size"
+	ReasonTrailingData    Reason = "trailing_data"
+	ReasonEmbeddedMarkup  Reason = "embedded_markup"
+	ReasonEmbeddedArchive Reason = "embedded_archive"
+)
+
+type ValidationError struct {
+	Reason Reason
+	Detail string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrInvalidStructure, e.Reason, e.Detail)
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidStructure
+}
+
+func invalid(reason Reason, format string, args ...any) error {
+	return &ValidationError{Reason: reason, Detail: fmt.Sprintf(format, args...)}
+}
+
+// region is a byte range of a file that holds metadata rather than
+// compressed pixels, and so is worth scanning for smuggled payloads.
+type region struct {
+	start, end int
+}
+
+// Validate walks the whole container rather than just its magic bytes, so a
+// valid header followed by a second payload (an HTML page, a ZIP archive)
+// is rejected. It returns a *ValidationError describing the first problem.
+func Validate(mediaType MediaType, data []byte) error {
+	var end int
+	var err error
+	var regions []region
+	walked := true
+	switch mediaType {
+	case TypeJPEG:
+		end, err = validateJPEG(data, &regions)
+	case TypePNG, TypeAPNG:
+		end, err = validatePNG(data, &regions)
+	case TypeGIF:
+		end, err = validateGIF(data, &regions)
+	case TypeWEBP:
+		end, err = validateRIFF(data, &regions)
+	case TypeAVIF, TypeHEIC, TypeHEIF:
+		end, err = validateBMFF(data, &regions)
+	case TypeJXL:
+		end = len(data)
+		walked = bytes.HasPrefix(data, jxlContainerMagic)
+		if walked {
+			end, err = validateBMFF(data, &regions)
+		}
+	case TypeBMP:
+		end, err = validateBMP(data, &regions)
+	default:
+		end = len(data)
+		walked = false
+	}
+	if err != nil {
+		return err
+	}
+	if end < len(data) {
+		return invalid(ReasonTrailingData, "%d bytes after end of %s", len(data)-end, mediaType)
+	}
+
+	if mediaType == TypeSVG {
+		return nil
+	}
+	if !walked {
+		regions = []region{{0, len(data)}}
+	}
+	return scanEmbedded(data, regions)
+}
+
+// validatePNG records ancillary chunks other than APNG frame data as
+// regions; IDAT, fdAT and the critical chunks hold pixels and palettes.
+func validatePNG(data []byte, regions *[]region) (int, error) {
+	if len(data) < len(pngMagic) || !bytes.Equal(data[:len(pngMagic)], pngMagic) {
+		return 0, invalid(ReasonTruncated, "png signature missing")
+	}
+
+	pos := len(pngMagic)
+	first := true
+	for {
+		if pos+12 > len(data) {
+			return 0, invalid(ReasonMissingChunk, "png ended without IEND")
+		}
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		if length > 0x7fffffff || pos+12+length > len(data) {
+			return 0, invalid(ReasonBadChunkLength, "png chunk at %d overruns file", pos)
+		}
+		kind := string(data[pos+4 : pos+8])
+		if first && kind != "IHDR" {
+			return 0, invalid(ReasonMissingChunk, "png does not start with IHDR")
+		}
+		first = false
+
+		crc := binary.BigEndian.Uint32(data[pos+8+length : pos+12+length])
+		if crc32.ChecksumIEEE(data[pos+4:pos+8+length]) != crc {
+			return 0, invalid(ReasonBadChunkCRC, "png %q chunk at %d", kind, pos)
+		}
+		if kind[0] >= 'a' && kind[0] <= 'z' && kind != "fdAT" {
+			*regions = append(*regions, region{pos + 8, pos + 8 + length})
+		}
+		pos += 12 + length
+		if kind == "IEND" {
+			return pos, nil
+		}
+	}
+}
+
+// validateJPEG follows marker segments and entropy-coded scans through EOI.
+// Phones append MPF secondary images after EOI; those are accepted only when
+// an MPF segment announced them and each is itself a valid JPEG. APPn and
+// COM segments are recorded as regions.
+func validateJPEG(data []byte, regions *[]region) (int, error) {
+	end, hasMPF, err := validateJPEGStream(data, 0, regions)
+	if err != nil {
+		return 0, err
+	}
+	for hasMPF && end < len(data) && bytes.HasPrefix(data[end:], []byte{0xff, 0xd8}) {
+		next, _, err := validateJPEGStream(data[end:], end, regions)
+		if err != nil {
+			return 0, err
+		}
+		end += next
+	}
+	return end, nil
+}
+
+// validateJPEGStream walks one JPEG; base is its offset in the whole file.
+func validateJPEGStream(data []byte, base int, regions *[]region) (int, bool, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 0, false, invalid(ReasonTruncated, "jpeg SOI missing")
+	}
+
+	hasMPF := false
+	pos := 2
+	for {
+		if pos+2 > len(data) {
+			return 0, false, invalid(ReasonMissingEOI, "jpeg ended without EOI")
+		}
+		if data[pos] != 0xff {
+			return 0, false, invalid(ReasonBadSegment, "expected marker at %d", pos)
+		}
+		marker := data[pos+1]
+		switch {
+		case marker == 0xff:
+			pos++
+			continue
+		case marker == 0xd9:
+			return pos + 2, hasMPF, nil
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			pos += 2
+			continue
+		case marker == 0x00 || marker == 0xd8:
+			return 0, false, invalid(ReasonBadSegment, "unexpected marker %#x at %d", marker, pos)
+		}
+
+		if pos+4 > len(data) {
+			return 0, false, invalid(ReasonTruncated, "jpeg segment header at %d", pos)
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if length < 2 || pos+2+length > len(data) {
+			return 0, false, invalid(ReasonBadSegment, "jpeg segment %#x at %d overruns file", marker, pos)
+		}
+		if marker == 0xe2 && bytes.HasPrefix(data[pos+4:pos+2+length], []byte("MPF\x00")) {
+			hasMPF = true
+		}
+		if (marker >= 0xe0 && marker <= 0xef) || marker == 0xfe {
+			*regions = append(*regions, region{base + pos + 4, base + pos + 2 + length})
+		}
+		pos += 2 + length
+
+		if marker == 0xda {
+			pos = skipEntropyData(data, pos)
+		}
+	}
+}
+
+// skipEntropyData returns the offset of the first marker that ends an
+// entropy-coded scan: 0xFF followed by anything but a stuffed 0x00 or RSTn.
+func skipEntropyData(data []byte, pos int) int {
+	for pos+1 < len(data) {
+		if data[pos] != 0xff {
+			pos++
+			continue
+		}
+		switch next := data[pos+1]; {
+		case next == 0xff:
+			pos++
+		case next == 0x00 || (next >= 0xd0 && next <= 0xd7):
+			pos += 2
+		default:
+			return pos
+		}
+	}
+	return len(data)
+}
+
+// validateGIF records extension blocks as regions; image data sub-blocks
+// are LZW-coded pixels.
+func validateGIF(data []byte, regions *[]region) (int, error) {
+	if len(data) < 13 {
+		return 0, invalid(ReasonTruncated, "gif header")
+	}
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << ((flags & 0x07) + 1)
+	}
+
+	for {
+		if pos >= len(data) {
+			return 0, invalid(ReasonTruncated, "gif ended without trailer")
+		}
+		switch data[pos] {
+		case 0x3b:
+			return pos + 1, nil
+		case 0x2c:
+			if pos+10 > len(data) {
+				return 0, invalid(ReasonTruncated, "gif image descriptor at %d", pos)
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << ((flags & 0x07) + 1)
+			}
+			pos++
+		case 0x21:
+			start := pos + 2
+			end, err := walkGIFSubBlocks(data, start)
+			if err != nil {
+				return 0, err
+			}
+			*regions = append(*regions, region{start, end})
+			pos = end
+			continue
+		default:
+			return 0, invalid(ReasonBadBlock, "unknown gif block %#x at %d", data[pos], pos)
+		}
+		var err error
+		if pos, err = walkGIFSubBlocks(data, pos); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func walkGIFSubBlocks(data []byte, pos int) (int, error) {
+	for {
+		if pos >= len(data) {
+			return 0, invalid(ReasonTruncated, "gif sub-block chain")
+		}
+		size := int(data[pos])
+		pos++
+		if size == 0 {
+			return pos, nil
+		}
+		pos += size
+	}
+}
+
+// validateRIFF records every chunk except the bitstream and frame chunks as
+// regions.
+func validateRIFF(data []byte, regions *[]region) (int, error) {
+	if len(data) < 12 {
+		return 0, invalid(ReasonTruncated, "riff header")
+	}
+	riffEnd := 8 + int(binary.LittleEndian.Uint32(data[4:8]))
+	if riffEnd > len(data) || riffEnd < 12 {
+		return 0, invalid(ReasonBadRIFFSize, "riff size %d, file %d", riffEnd-8, len(data))
+	}
+
+	pos := 12
+	for pos < riffEnd {
+		if pos+8 > riffEnd {
+			return 0, invalid(ReasonBadChunkLength, "riff chunk header at %d", pos)
+		}
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		next := pos + 8 + size + size%2
+		if pos+8+size > riffEnd {
+			return 0, invalid(ReasonBadChunkLength, "riff chunk %q at %d overruns file", data[pos:pos+4], pos)
+		}
+		switch string(data[pos : pos+4]) {
+		case "VP8 ", "VP8L", "ALPH", "ANMF":
+		default:
+			*regions = append(*regions, region{pos + 8, pos + 8 + size})
+		}
+		pos = min(next, riffEnd)
+	}
+	return riffEnd, nil
+}
+
+// validateBMFF records top-level boxes other than media and codestream data
+// as regions.
+func validateBMFF(data []byte, regions *[]region) (int, error) {
+	pos := 0
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return pos, nil
+		}
+		size := uint64(binary.BigEndian.Uint32(data[pos : pos+4]))
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data) - pos)
+		case 1:
+			if pos+16 > len(data) {
+				return 0, invalid(ReasonBadBoxSize, "largesize box at %d", pos)
+			}
+			size = binary.BigEndian.Uint64(data[pos+8 : pos+16])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)-pos) {
+			return 0, invalid(ReasonBadBoxSize, "box %q at %d overruns file", data[pos+4:pos+8], pos)
+		}
+		if !isBoxType(data[pos+4 : pos+8]) {
+			return pos, nil
+		}
+		switch string(data[pos+4 : pos+8]) {
+		case "mdat", "jxlc", "jxlp":
+		default:
+			*regions = append(*regions, region{pos + int(header), pos + int(size)})
+		}
+		pos += int(size)
+	}
+	return pos, nil
+}
+
+// validateBMP records the headers and palette before the pixel array as a
+// region.
+func validateBMP(data []byte, regions *[]region) (int, error) {
+	if len(data) < 14 {
+		return 0, invalid(ReasonTruncated, "bmp header")
+	}
+	size := int(binary.LittleEndian.Uint32(data[2:6]))
+	if size == 0 {
+		size = len(data)
+	}
+	if size > len(data) {
+		return 0, invalid(ReasonTruncated, "bmp declares %d bytes, file %d", size, len(data))
+	}
+	pixels := int(binary.LittleEndian.Uint32(data[10:14]))
+	*regions = append(*regions, region{0, min(max(pixels, 14), size)})
+	return size, nil
+}
+
+// Signatures are at least five bytes long; shorter ones match binary data
+// by chance.
+var markupSignatures = [][]byte{
+	[]byte("<html"),
+	[]byte("<!doctype html"),
+	[]byte("<script"),
+	[]byte("<iframe"),
+	[]byte("<?php"),
+	[]byte("javascript:"),
+}
+
+var archiveSignatures = [][]byte{
+	[]byte("Rar!\x1a\x07"),
+	[]byte("7z\xbc\xaf\x27\x1c"),
+	[]byte("%PDF-"),
+}
+
+// scanEmbedded looks for payloads smuggled inside otherwise valid images.
+// Only metadata regions are searched: compressed pixel data is effectively
+// random and would match by chance. Markup is matched with ASCII-only case
+// folding so offsets stay those of the original bytes. ZIP is detected
+// through a consistent end-of-central-directory record over the whole file,
+// as unzip tools locate archives from the end of the file.
+func scanEmbedded(data []byte, regions []region) error {
+	for _, r := range regions {
+		chunk := data[r.start:r.end]
+		lower := asciiLower(chunk)
+		for _, sig := range markupSignatures {
+			if idx := bytes.Index(lower, sig); idx >= 0 {
+				return invalid(ReasonEmbeddedMarkup, "%q at %d", sig, r.start+idx)
+			}
+		}
+		for _, sig := range archiveSignatures {
+			if idx := bytes.Index(chunk, sig); idx >= 0 {
+				return invalid(ReasonEmbeddedArchive, "%q at %d", sig, r.start+idx)
+			}
+		}
+	}
+	if idx, ok := findZipEOCD(data); ok {
+		return invalid(ReasonEmbeddedArchive, "zip end of central directory at %d", idx)
+	}
+	return nil
+}
+
+// asciiLower lowers A-Z only. bytes.ToLower decodes UTF-8 and would change
+// the length of binary input.
+func asciiLower(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		if b >= 'A' && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		out[i] = b
+	}
+	return out
+}
+
+func findZipEOCD(data []byte) (int, bool) {
+	const eocdLen = 22
+	start := max(0, len(data)-eocdLen-0xffff)
+	for idx := len(data) - eocdLen; idx >= start; idx-- {
+		if !bytes.Equal(data[idx:idx+4], []byte("PK\x05\x06")) {
+			continue
+		}
+		cdSize := int(binary.LittleEndian.Uint32(data[idx+12 : idx+16]))
+		cdOffset := int(binary.LittleEndian.Uint32(data[idx+16 : idx+20]))
+		commentLen := int(binary.LittleEndian.Uint16(data[idx+20 : idx+22]))
+		if cdOffset+cdSize > idx || idx+eocdLen+commentLen > len(data) {
+			continue
+		}
+		// unzip finds the central directory just before the EOCD, even when
+		// data was prepended; require it there so random bytes do not match.
+		cdStart := idx - cdSize
+		if cdSize > 0 && bytes.HasPrefix(data[cdStart:], []byte("PK\x01\x02")) {
+			return idx, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,207 @@
+package sniffer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+func noise(w, h int) *image.NRGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	rng.Read(img.Pix)
+	return img
+}
+
+// pixelsOf lays text out as the raw bytes of a one-row greyscale image.
+func pixelsOf(text string) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, len(text), 1))
+	copy(img.Pix, text)
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image, level png.CompressionLevel) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: level}).Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func pngChunk(kind string, payload []byte) []byte {
+	chunk := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	copy(chunk[4:], kind)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// withPNGChunk inserts a chunk just before IEND.
+func withPNGChunk(data []byte, chunk []byte) []byte {
+	iend := len(data) - 12
+	out := append([]byte{}, data[:iend]...)
+	out = append(out, chunk...)
+	return append(out, data[iend:]...)
+}
+
+func zipBytes(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("payload.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("hello"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withJPEGSegment inserts a marker segment straight after SOI.
+func withJPEGSegment(data []byte, marker byte, payload []byte) []byte {
+	segment := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	out = append(out, payload...)
+	return append(out, data[2:]...)
+}
+
+func encodeGIF(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withGIFComment inserts a comment extension before the trailer.
+func withGIFComment(data []byte, text string) []byte {
+	out := append([]byte{}, data[:len(data)-1]...)
+	out = append(out, 0x21, 0xfe, byte(len(text)))
+	out = append(out, text...)
+	return append(out, 0x00, 0x3b)
+}
+
+func riffChunk(fourCC string, payload []byte) []byte {
+	chunk := make([]byte, 8, 9+len(payload))
+	copy(chunk, fourCC)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func webpOf(chunks ...[]byte) []byte {
+	out := []byte("RIFF\x00\x00\x00\x00WEBP")
+	for _, chunk := range chunks {
+		out = append(out, chunk...)
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out
+}
+
+func bmffBoxOf(kind string, payload []byte) []byte {
+	box := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(box, uint32(8+len(payload)))
+	copy(box[4:], kind)
+	return append(box, payload...)
+}
+
+func TestValidate(t *testing.T) {
+	noisePNG := encodePNG(t, noise(256, 256), png.DefaultCompression)
+	// Stored pixels that spell out markup are still just pixels.
+	markupPixels := encodePNG(t, pixelsOf("<%@ <HTML <script javascript: %PDF-"), png.NoCompression)
+	badCRC := append([]byte{}, noisePNG...)
+	badCRC[len(badCRC)-5] ^= 0xff
+	// VP8L payload is never decoded here; only its container is checked.
+	vp8l := append([]byte{0x2f}, []byte("\x00\xc0<HTML<script\xff\xfe")...)
+	ftyp := bmffBoxOf("ftyp", []byte("avif\x00\x00\x00\x00avifmif1"))
+
+	tests := []struct {
+		name      string
+		mediaType MediaType
+		data      []byte
+		want      Reason
+	}{
+		{"png noise", TypePNG, noisePNG, ""},
+		{"png pixels spelling markup", TypePNG, markupPixels, ""},
+		{"png markup in text chunk", TypePNG, withPNGChunk(noisePNG, pngChunk("tEXt", []byte("Comment\x00<ScRiPt>alert(1)</script>"))), ReasonEmbeddedMarkup},
+		{"png zip in private chunk", TypePNG, withPNGChunk(noisePNG, pngChunk("zzZz", zipBytes(t))), ReasonEmbeddedArchive},
+		{"png rar in private chunk", TypePNG, withPNGChunk(noisePNG, pngChunk("zzZz", []byte("Rar!\x1a\x07\x00"))), ReasonEmbeddedArchive},
+		{"png zip after IEND", TypePNG, append(append([]byte{}, noisePNG...), zipBytes(t)...), ReasonTrailingData},
+		{"png bad crc", TypePNG, badCRC, ReasonBadChunkCRC},
+		{"jpeg noise", TypeJPEG, encodeJPEG(t, noise(64, 64)), ""},
+		{"jpeg markup in comment", TypeJPEG, withJPEGSegment(encodeJPEG(t, noise(8, 8)), 0xfe, []byte("<?PHP system($_GET[0]);")), ReasonEmbeddedMarkup},
+		{"jpeg markup in APP1", TypeJPEG, withJPEGSegment(encodeJPEG(t, noise(8, 8)), 0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00<iframe src=x>")), ReasonEmbeddedMarkup},
+		{"jpeg missing EOI", TypeJPEG, bytes.TrimSuffix(encodeJPEG(t, noise(8, 8)), []byte{0xff, 0xd9}), ReasonMissingEOI},
+		{"gif", TypeGIF, encodeGIF(t), ""},
+		{"gif markup in comment", TypeGIF, withGIFComment(encodeGIF(t), "<IFRAME src=x>"), ReasonEmbeddedMarkup},
+		{"webp bitstream bytes", TypeWEBP, webpOf(riffChunk("VP8L", vp8l)), ""},
+		{"webp markup in XMP", TypeWEBP, webpOf(riffChunk("VP8L", vp8l), riffChunk("XMP ", []byte("<a href=\"JavaScript:alert(1)\"/>"))), ReasonEmbeddedMarkup},
+		{"webp bad riff size", TypeWEBP, webpOf(riffChunk("VP8L", vp8l))[:20], ReasonBadRIFFSize},
+		{"avif pdf magic in mdat", TypeAVIF, append(append([]byte{}, ftyp...), bmffBoxOf("mdat", []byte("%PDF-1.7 <html"))...), ""},
+		{"avif pdf in free box", TypeAVIF, append(append([]byte{}, ftyp...), bmffBoxOf("free", []byte("%PDF-1.7"))...), ReasonEmbeddedArchive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.mediaType, tt.data)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Validate = %v, want nil", err)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Validate = %v, want %s", err, tt.want)
+			}
+			if verr.Reason != tt.want {
+				t.Fatalf("reason = %s (%s), want %s", verr.Reason, verr.Detail, tt.want)
+			}
+			if !errors.Is(err, ErrInvalidStructure) {
+				t.Fatal("error does not match ErrInvalidStructure")
+			}
+		})
+	}
+}
+
+func TestScanEmbeddedOffsets(t *testing.T) {
+	// Bytes that are not valid UTF-8 before the match must not shift the
+	// reported offset.
+	data := append(bytes.Repeat([]byte{0xff, 0xc3}, 50), []byte("<SCRIPT>")...)
+	err := scanEmbedded(data, []region{{10, len(data)}})
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("scanEmbedded = %v", err)
+	}
+	if want := `"<script" at 100`; verr.Detail != want {
+		t.Fatalf("detail = %q, want %q", verr.Detail, want)
+	}
+}
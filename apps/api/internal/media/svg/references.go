@@ -0,0 +1,60 @@
+package svg
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	cssURLPattern      = regexp.MustCompile(`(?i)url\s*\(\s*("[^"]*"|'[^']*'|[^)]*)\s*\)`)
+	cssImportPattern   = regexp.MustCompile(`(?i)@import\b[^;]*;?`)
+	cssFunctionPattern = regexp.MustCompile(`(?i)(image-set|expression)\s*\(`)
+	dataImagePattern   = regexp.MustCompile(`(?i)^data:image/(png|jpeg|gif|webp|avif);base64,[a-z0-9+/=\s]*$`)
+)
+
+// isSafeReference allows same-document fragments and inline raster images.
+// data:image/svg+xml is excluded since it would carry its own markup.
+func isSafeReference(value string) bool {
+	value = strings.TrimSpace(value)
+	return strings.HasPrefix(value, "#") || dataImagePattern.MatchString(value)
+}
+
+func hasExternalURL(value string) bool {
+	for _, match := range cssURLPattern.FindAllStringSubmatch(value, -1) {
+		if !isFragmentURL(match[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func isFragmentURL(target string) bool {
+	return strings.HasPrefix(strings.Trim(strings.TrimSpace(target), `"'`), "#")
+}
+
+// sanitizeCSS removes @import rules and rewrites non-fragment url() values to
+// none. CSS escapes can spell out url( or @import in ways the patterns miss,
+// so stylesheets containing a backslash are dropped entirely, as are
+// functions that fetch resources without url().
+func sanitizeCSS(css string, report *Report) string {
+	if strings.Contains(css, `\`) || cssFunctionPattern.MatchString(css) {
+		report.add(RemovedCSS, "", "unsafe css")
+		return ""
+	}
+	if cssImportPattern.MatchString(css) {
+		css = cssImportPattern.ReplaceAllString(css, "")
+		report.add(RemovedCSS, "@import", "css import")
+	}
+	rewritten := false
+	css = cssURLPattern.ReplaceAllStringFunc(css, func(match string) string {
+		if isFragmentURL(cssURLPattern.FindStringSubmatch(match)[1]) {
+			return match
+		}
+		rewritten = true
+		return "none"
+	})
+	if rewritten {
+		report.add(RemovedCSS, "url", "external reference")
+	}
+	return strings.TrimSpace(css)
+}
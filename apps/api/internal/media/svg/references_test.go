@@ -0,0 +1,202 @@
+package svg
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const billionLaughs = `<?xml version="1.0"?>
+<!DOCTYPE svg [
+  <!ENTITY lol "lol">
+  <!ENTITY lol1 "&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;">
+  <!ENTITY lol2 "&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;">
+]>
+<svg xmlns="http://www.w3.org/2000/svg"><text>&lol2;</text></svg>`
+
+func TestSanitizeReferences(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		removed []Removal
+		wantErr error
+	}{
+		{
+			name:  "fragment references are kept",
+			input: `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"><use href="#a"/><use xlink:href=" #b"/><rect fill="url(#g)"/></svg>`,
+			want:  root + `><use href="#a"/><use xlink:href=" #b"/><rect fill="url(#g)"/></svg>`,
+		},
+		{
+			name:    "external href",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><use href="https://evil.example/sprite.svg#a"/></svg>`,
+			want:    root + `><use/></svg>`,
+			removed: []Removal{{RemovedAttribute, "href", "external reference"}},
+		},
+		{
+			name:    "external xlink:href",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"><image xlink:href="//evil.example/beacon.png"/></svg>`,
+			want:    root + `><image/></svg>`,
+			removed: []Removal{{RemovedAttribute, "xlink:href", "external reference"}},
+		},
+		{
+			name:  "inline raster image",
+			input: `<svg xmlns="http://www.w3.org/2000/svg"><image href="data:image/png;base64,iVBORw0KGgo="/></svg>`,
+			want:  root + `><image href="data:image/png;base64,iVBORw0KGgo="/></svg>`,
+		},
+		{
+			name:    "inline svg image",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><image href="data:image/svg+xml;base64,PHN2Zy8+"/></svg>`,
+			want:    root + `><image/></svg>`,
+			removed: []Removal{{RemovedAttribute, "href", "external reference"}},
+		},
+		{
+			name:    "inline raster image that is not base64",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><image href="data:image/png,&lt;svg&gt;"/></svg>`,
+			want:    root + `><image/></svg>`,
+			removed: []Removal{{RemovedAttribute, "href", "external reference"}},
+		},
+		{
+			name:    "external url in presentation attribute",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><rect fill="url(https://evil.example/p.svg#g)" width="1"/></svg>`,
+			want:    root + `><rect width="1"/></svg>`,
+			removed: []Removal{{RemovedAttribute, "fill", "external reference"}},
+		},
+		{
+			name:  "stylesheet url and import",
+			input: `<svg xmlns="http://www.w3.org/2000/svg"><style>@import url(https://evil.example/a.css); rect { fill: url(#g); stroke: url('https://evil.example/b') }</style></svg>`,
+			want:  root + `><style>rect { fill: url(#g); stroke: none }</style></svg>`,
+			removed: []Removal{
+				{RemovedCSS, "@import", "css import"},
+				{RemovedCSS, "url", "external reference"},
+			},
+		},
+		{
+			name:  "stylesheet import without url",
+			input: `<svg xmlns="http://www.w3.org/2000/svg"><style>@IMPORT "https://evil.example/a.css";rect{fill:red}</style></svg>`,
+			want:  root + `><style>rect{fill:red}</style></svg>`,
+			removed: []Removal{
+				{RemovedCSS, "@import", "css import"},
+			},
+		},
+		{
+			name:    "style attribute url",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><rect style="fill: URL( &quot;https://evil.example/&quot; ); stroke: red"/></svg>`,
+			want:    root + `><rect style="fill: none; stroke: red"/></svg>`,
+			removed: []Removal{{RemovedCSS, "url", "external reference"}},
+		},
+		{
+			name:    "style attribute import",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><rect style="@import 'https://evil.example/a.css'"/></svg>`,
+			want:    root + `><rect/></svg>`,
+			removed: []Removal{{RemovedCSS, "@import", "css import"}},
+		},
+		{
+			name:    "escaped stylesheet",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><style>rect { fill: u\72l(https://evil.example/) }</style></svg>`,
+			want:    root + `><style/></svg>`,
+			removed: []Removal{{RemovedCSS, "", "unsafe css"}},
+		},
+		{
+			name:    "escaped style attribute",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><rect style="fill: \75rl(https://evil.example/)" width="1"/></svg>`,
+			want:    root + `><rect width="1"/></svg>`,
+			removed: []Removal{{RemovedCSS, "", "unsafe css"}},
+		},
+		{
+			name:    "image-set without url",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><rect style="fill: image-set('https://evil.example/a.png' 1x)"/></svg>`,
+			want:    root + `><rect/></svg>`,
+			removed: []Removal{{RemovedCSS, "", "unsafe css"}},
+		},
+		{
+			name:    "doctype without entities",
+			input:   `<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd"><svg xmlns="http://www.w3.org/2000/svg"/>`,
+			want:    root + `/>`,
+			removed: []Removal{{RemovedDirective, "DOCTYPE", "not allowed"}},
+		},
+		{
+			name:    "billion laughs",
+			input:   billionLaughs,
+			wantErr: ErrInvalidSVG,
+		},
+		{
+			name:    "external entity",
+			input:   `<!DOCTYPE svg [<!ENTITY xxe SYSTEM "file:///etc/passwd">]><svg xmlns="http://www.w3.org/2000/svg"><text>&xxe;</text></svg>`,
+			wantErr: ErrInvalidSVG,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, report, err := Sanitize([]byte(tt.input), Limits{})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("output:\n got %s\nwant %s", got, tt.want)
+			}
+			if !reflect.DeepEqual(report.Removed, tt.removed) {
+				t.Fatalf("removed = %+v, want %+v", report.Removed, tt.removed)
+			}
+		})
+	}
+}
+
+// nested returns an svg root with depth-1 levels of g below it.
+func nested(depth int) string {
+	return `<svg xmlns="http://www.w3.org/2000/svg">` +
+		strings.Repeat("<g>", depth-1) + strings.Repeat("</g>", depth-1) + `</svg>`
+}
+
+// flat returns an svg root with count-1 children, half of them dropped.
+func flat(count int) string {
+	var b strings.Builder
+	b.WriteString(`<svg xmlns="http://www.w3.org/2000/svg">`)
+	for i := 1; i < count; i++ {
+		if i%2 == 0 {
+			b.WriteString("<script/>")
+		} else {
+			b.WriteString("<rect/>")
+		}
+	}
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+func TestSanitizeLimits(t *testing.T) {
+	limits := Limits{MaxDepth: 8, MaxElements: 100}
+	tests := []struct {
+		name    string
+		input   string
+		limits  Limits
+		wantErr bool
+	}{
+		{name: "depth at limit", input: nested(8), limits: limits},
+		{name: "depth over limit", input: nested(9), limits: limits, wantErr: true},
+		{name: "depth inside dropped element", input: `<svg xmlns="http://www.w3.org/2000/svg"><script>` + strings.Repeat("<g>", 8) + strings.Repeat("</g>", 8) + `</script></svg>`, limits: limits, wantErr: true},
+		{name: "elements at limit", input: flat(100), limits: limits},
+		{name: "elements over limit", input: flat(101), limits: limits, wantErr: true},
+		{name: "zero limits are unlimited", input: nested(200), limits: Limits{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Sanitize([]byte(tt.input), tt.limits)
+			if tt.wantErr {
+				if !errors.Is(err, ErrTooComplex) {
+					t.Fatalf("err = %v, want ErrTooComplex", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -2,22 +2,295 @@ package svg
 
 import (
 	"bytes"
+	"encoding/xml"
 	"errors"
-	"regexp"
+	"fmt"
+	"io"
+	"strings"
 )
 
+const (
+	svgNamespace   = "http://www.w3.org/2000/svg"
+	xlinkNamespace = "http://www.w3.org/1999/xlink"
+	xmlNamespace   = "http://www.w3.org/XML/1998/namespace"
+)
+
+var (
+	ErrInvalidSVG = errors.New("invalid svg document")
+	ErrTooComplex = errors.New("svg exceeds complexity limits")
+)
+
+// Limits bounds the parse itself, counting dropped elements too. Zero values
+// are treated as unlimited.
+type Limits struct {
+	MaxDepth    int
+	MaxElements int
+}
+
+var allowedElements = map[string]struct{}{
+	"svg": {}, "g": {}, "defs": {}, "use": {}, "symbol": {},
+	"path": {}, "rect": {}, "circle": {}, "ellipse": {}, "line": {}, "polyline": {}, "polygon": {},
+	"linearGradient": {}, "radialGradient": {}, "stop": {}, "pattern": {},
+	"clipPath": {}, "mask": {}, "marker": {},
+	"text": {}, "tspan": {}, "title": {}, "desc": {}, "image": {}, "style": {},
+}
+
+var allowedAttributes = map[string]struct{}{
+	"id": {}, "class": {}, "width": {}, "height": {}, "viewBox": {}, "preserveAspectRatio": {}, "version": {},
+	"x": {}, "y": {}, "x1": {}, "y1": {}, "x2": {}, "y2": {}, "cx": {}, "cy": {}, "r": {}, "rx": {}, "ry": {},
+	"fx": {}, "fy": {}, "fr": {}, "dx": {}, "dy": {}, "d": {}, "points": {}, "pathLength": {}, "transform": {},
+	"fill": {}, "fill-opacity": {}, "fill-rule": {}, "opacity": {}, "color": {},
+	"stroke": {}, "stroke-width": {}, "stroke-opacity": {}, "stroke-linecap": {}, "stroke-linejoin": {},
+	"stroke-miterlimit": {}, "stroke-dasharray": {}, "stroke-dashoffset": {},
+	"offset": {}, "stop-color": {}, "stop-opacity": {},
+	"gradientUnits": {}, "gradientTransform": {}, "spreadMethod": {},
+	"patternUnits": {}, "patternContentUnits": {}, "patternTransform": {},
+	"clip-path": {}, "clip-rule": {}, "clipPathUnits": {}, "mask": {}, "maskUnits": {}, "maskContentUnits": {},
+	"marker-start": {}, "marker-mid": {}, "marker-end": {}, "markerWidth": {}, "markerHeight": {},
+	"markerUnits": {}, "refX": {}, "refY": {}, "orient": {},
+	"font-family": {}, "font-size": {}, "font-style": {}, "font-weight": {}, "text-anchor": {},
+	"dominant-baseline": {}, "letter-spacing": {}, "display": {}, "visibility": {},
+	"href": {}, "xlink:href": {}, "xml:space": {}, "style": {}, "type": {}, "media": {},
+}
+
 var (
-	scriptTagPattern = regexp.MustCompile(`(?is)<\s*script[\s>].*?<\s*/\s*script\s*>`)
-	eventAttrPattern = regexp.MustCompile(`(?is)\son[a-z]+\s*=\s*"[^"]*"`)
+	textEscaper      = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attributeEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "\n", "&#xA;", "\r", "&#xD;", "\t", "&#x9;")
 )
 
-func Sanitize(input []byte) ([]byte, error) {
-	if !bytes.Contains(bytes.ToLower(input), []byte("<svg")) {
-		return nil, errors.New("not an svg document")
+type RemovalKind string
+
+const (
+	RemovedElement     RemovalKind = "element"
+	RemovedAttribute   RemovalKind = "attribute"
+	RemovedComment     RemovalKind = "comment"
+	RemovedDirective   RemovalKind = "directive"
+	RemovedInstruction RemovalKind = "processing_instruction"
+	RemovedCSS         RemovalKind = "css"
+)
+
+type Removal struct {
+	Kind   RemovalKind `json:"kind"`
+	Name   string      `json:"name,omitempty"`
+	Reason string      `json:"reason"`
+}
+
+// Report lists everything Sanitize dropped from the input.
+type Report struct {
+	Removed []Removal
+}
+
+func (r *Report) add(kind RemovalKind, name, reason string) {
+	r.Removed = append(r.Removed, Removal{Kind: kind, Name: name, Reason: reason})
+}
+
+// Sanitize parses the document as a token stream and re-serializes only
+// allow-listed elements and attributes in the SVG namespace. A disallowed
+// element is dropped together with its content. Undeclared entities,
+// entity declarations and non-UTF-8 encodings fail the parse rather than
+// being passed through.
+func Sanitize(input []byte, limits Limits) ([]byte, Report, error) {
+	var report Report
+	decoder := xml.NewDecoder(bytes.NewReader(bytes.TrimPrefix(input, []byte{0xef, 0xbb, 0xbf})))
+
+	var out bytes.Buffer
+	var open []string
+	var style strings.Builder
+	skipDepth := 0
+	depth := 0
+	elements := 0
+	pendingStart := false
+	rootSeen := false
+
+	closePending := func() {
+		if pendingStart {
+			out.WriteByte('>')
+			pendingStart = false
+		}
+	}
+
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, Report{}, fmt.Errorf("%w: %v", ErrInvalidSVG, err)
+		}
+
+		switch token.(type) {
+		case xml.StartElement:
+			depth++
+			elements++
+			if limits.MaxDepth > 0 && depth > limits.MaxDepth {
+				return nil, Report{}, fmt.Errorf("%w: nesting deeper than %d", ErrTooComplex, limits.MaxDepth)
+			}
+			if limits.MaxElements > 0 && elements > limits.MaxElements {
+				return nil, Report{}, fmt.Errorf("%w: more than %d elements", ErrTooComplex, limits.MaxElements)
+			}
+		case xml.EndElement:
+			depth--
+		}
+
+		if skipDepth > 0 {
+			switch token.(type) {
+			case xml.StartElement:
+				skipDepth++
+			case xml.EndElement:
+				skipDepth--
+			}
+			continue
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if !rootSeen {
+				if t.Name.Space != svgNamespace || t.Name.Local != "svg" {
+					return nil, Report{}, fmt.Errorf("%w: root element is not svg", ErrInvalidSVG)
+				}
+				rootSeen = true
+			} else if len(open) == 0 {
+				return nil, Report{}, fmt.Errorf("%w: content after root element", ErrInvalidSVG)
+			}
+
+			if _, ok := allowedElements[t.Name.Local]; !ok || t.Name.Space != svgNamespace {
+				report.add(RemovedElement, qualifiedName(t.Name), "not allowed")
+				skipDepth = 1
+				continue
+			}
+
+			closePending()
+			out.WriteByte('<')
+			out.WriteString(t.Name.Local)
+			if len(open) == 0 {
+				out.WriteString(` xmlns="` + svgNamespace + `" xmlns:xlink="` + xlinkNamespace + `"`)
+			}
+			writeAttributes(&out, t.Attr, &report)
+			open = append(open, t.Name.Local)
+			pendingStart = true
+		case xml.EndElement:
+			name := open[len(open)-1]
+			if name == "style" {
+				if css := sanitizeCSS(style.String(), &report); css != "" {
+					closePending()
+					textEscaper.WriteString(&out, css)
+				}
+				style.Reset()
+			}
+			if pendingStart {
+				out.WriteString("/>")
+				pendingStart = false
+			} else {
+				out.WriteString("</" + name + ">")
+			}
+			open = open[:len(open)-1]
+		case xml.CharData:
+			if len(open) == 0 {
+				continue
+			}
+			if open[len(open)-1] == "style" {
+				// CDATA sections arrive as separate tokens; the stylesheet is
+				// checked as a whole once the element closes.
+				style.Write(t)
+				continue
+			}
+			closePending()
+			textEscaper.WriteString(&out, string(t))
+		case xml.Comment:
+			report.add(RemovedComment, "", "comment")
+		case xml.ProcInst:
+			if t.Target != "xml" {
+				report.add(RemovedInstruction, t.Target, "not allowed")
+			}
+		case xml.Directive:
+			if bytes.Contains(t, []byte("<!ENTITY")) {
+				return nil, Report{}, fmt.Errorf("%w: doctype declares entities", ErrInvalidSVG)
+			}
+			report.add(RemovedDirective, firstWord(t), "not allowed")
+		}
+	}
+
+	if !rootSeen {
+		return nil, Report{}, fmt.Errorf("%w: no root element", ErrInvalidSVG)
 	}
+	return out.Bytes(), report, nil
+}
+
+// writeAttributes keeps allow-listed attributes in source order. Namespace
+// declarations are dropped; the root element gets canonical ones instead.
+func writeAttributes(out *bytes.Buffer, attrs []xml.Attr, report *Report) {
+	seen := make(map[string]struct{}, len(attrs))
+	for _, attr := range attrs {
+		if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			continue
+		}
+		name := qualifiedName(attr.Name)
+		if _, ok := allowedAttributes[name]; !ok {
+			report.add(RemovedAttribute, name, "not allowed")
+			continue
+		}
+		if _, dup := seen[name]; dup {
+			report.add(RemovedAttribute, name, "duplicate")
+			continue
+		}
+		value := attr.Value
+		if isScriptURI(value) {
+			report.add(RemovedAttribute, name, "script uri")
+			continue
+		}
+		switch {
+		case name == "href" || name == "xlink:href":
+			if !isSafeReference(value) {
+				report.add(RemovedAttribute, name, "external reference")
+				continue
+			}
+		case name == "style":
+			if value = sanitizeCSS(value, report); value == "" {
+				continue
+			}
+		case hasExternalURL(value):
+			report.add(RemovedAttribute, name, "external reference")
+			continue
+		}
+		seen[name] = struct{}{}
+
+		out.WriteString(" " + name + `="`)
+		attributeEscaper.WriteString(out, value)
+		out.WriteByte('"')
+	}
+}
 
-	clean := scriptTagPattern.ReplaceAll(input, nil)
-	clean = eventAttrPattern.ReplaceAll(clean, nil)
+// qualifiedName maps resolved namespaces back to the conventional prefixes so
+// attribute names can be checked against the allow-list.
+func qualifiedName(name xml.Name) string {
+	switch name.Space {
+	case "", svgNamespace:
+		return name.Local
+	case xlinkNamespace:
+		return "xlink:" + name.Local
+	case xmlNamespace, "xml":
+		return "xml:" + name.Local
+	default:
+		return name.Space + ":" + name.Local
+	}
+}
 
-	return clean, nil
+// isScriptURI ignores whitespace and control characters, which browsers also
+// skip when parsing a URL scheme.
+func isScriptURI(value string) bool {
+	compact := strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(value))
+	return strings.Contains(compact, "javascript:") || strings.Contains(compact, "vbscript:")
+}
+
+func firstWord(directive xml.Directive) string {
+	fields := strings.Fields(string(directive))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
 }
@@ -0,0 +1,176 @@
+package svg
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// root is the canonical root start tag Sanitize writes.
+const root = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"`
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		removed []Removal
+		wantErr error
+	}{
+		{
+			name:  "allowed content is kept",
+			input: `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><g fill="red"><rect width="10" height="10"/></g><text x="1">a &amp; b</text></svg>`,
+			want:  root + ` viewBox="0 0 10 10"><g fill="red"><rect width="10" height="10"/></g><text x="1">a &amp; b</text></svg>`,
+		},
+		{
+			name:    "double-quoted handler",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"><rect onclick="alert(2)" width="1"/></svg>`,
+			want:    root + `><rect width="1"/></svg>`,
+			removed: []Removal{{RemovedAttribute, "onload", "not allowed"}, {RemovedAttribute, "onclick", "not allowed"}},
+		},
+		{
+			name:    "single-quoted handler",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><rect onmouseover='alert(1)' width='1'/></svg>`,
+			want:    root + `><rect width="1"/></svg>`,
+			removed: []Removal{{RemovedAttribute, "onmouseover", "not allowed"}},
+		},
+		{
+			name:    "mixed-case handler",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg" OnLoad="alert(1)"/>`,
+			want:    root + `/>`,
+			removed: []Removal{{RemovedAttribute, "OnLoad", "not allowed"}},
+		},
+		{
+			name:    "unquoted handler",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><rect onclick=alert(1) width="1"/></svg>`,
+			wantErr: ErrInvalidSVG,
+		},
+		{
+			name:    "script element",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script><rect width="1"/></svg>`,
+			want:    root + `><rect width="1"/></svg>`,
+			removed: []Removal{{RemovedElement, "script", "not allowed"}},
+		},
+		{
+			name:    "script in CDATA inside script",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><script><![CDATA[alert(1)]]></script></svg>`,
+			want:    root + `/>`,
+			removed: []Removal{{RemovedElement, "script", "not allowed"}},
+		},
+		{
+			name:  "script markup in CDATA text",
+			input: `<svg xmlns="http://www.w3.org/2000/svg"><text><![CDATA[<script>alert(1)</script>]]></text></svg>`,
+			want:  root + `><text>&lt;script&gt;alert(1)&lt;/script&gt;</text></svg>`,
+		},
+		{
+			name:  "script markup in CDATA stylesheet",
+			input: `<svg xmlns="http://www.w3.org/2000/svg"><style><![CDATA[</style><script>alert(1)</script>]]></style></svg>`,
+			want:  root + `><style>&lt;/style&gt;&lt;script&gt;alert(1)&lt;/script&gt;</style></svg>`,
+		},
+		{
+			name:    "foreignObject with html",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><foreignObject><body xmlns="http://www.w3.org/1999/xhtml"><img src="x" onerror="alert(1)"/></body></foreignObject></svg>`,
+			want:    root + `/>`,
+			removed: []Removal{{RemovedElement, "foreignObject", "not allowed"}},
+		},
+		{
+			name:    "animate href to javascript",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><a href="#x"><animate attributeName="href" values="javascript:alert(1)"/><text>x</text></a></svg>`,
+			want:    root + `/>`,
+			removed: []Removal{{RemovedElement, "a", "not allowed"}},
+		},
+		{
+			name:    "animate and set inside allowed element",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"><use href="#a"><animate attributeName="href" to="javascript:alert(1)"/><set attributeName="xlink:href" to="javascript:alert(2)"/></use></svg>`,
+			want:    root + `><use href="#a"/></svg>`,
+			removed: []Removal{{RemovedElement, "animate", "not allowed"}, {RemovedElement, "set", "not allowed"}},
+		},
+		{
+			name:    "javascript href",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"><use xlink:href=" java&#x09;script:alert(1)"/></svg>`,
+			want:    root + `><use/></svg>`,
+			removed: []Removal{{RemovedAttribute, "xlink:href", "script uri"}},
+		},
+		{
+			name:    "element in unknown namespace",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg" xmlns:x="urn:evil"><x:rect width="1"/><rect width="2"/></svg>`,
+			want:    root + `><rect width="2"/></svg>`,
+			removed: []Removal{{RemovedElement, "urn:evil:rect", "not allowed"}},
+		},
+		{
+			name:    "html script by namespace",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><script xmlns="http://www.w3.org/1999/xhtml">alert(1)</script></svg>`,
+			want:    root + `/>`,
+			removed: []Removal{{RemovedElement, "http://www.w3.org/1999/xhtml:script", "not allowed"}},
+		},
+		{
+			name:    "attribute in unknown namespace",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg" xmlns:x="urn:evil"><rect x:width="1" width="2"/></svg>`,
+			want:    root + `><rect width="2"/></svg>`,
+			removed: []Removal{{RemovedAttribute, "urn:evil:width", "not allowed"}},
+		},
+		{
+			name:    "root in another namespace",
+			input:   `<svg xmlns="http://www.w3.org/1999/xhtml"><rect/></svg>`,
+			wantErr: ErrInvalidSVG,
+		},
+		{
+			name:    "comments and processing instructions",
+			input:   `<?xml version="1.0"?><?xml-stylesheet href="x.css"?><!-- hi --><svg xmlns="http://www.w3.org/2000/svg"><!-- there --></svg>`,
+			want:    root + `/>`,
+			removed: []Removal{{RemovedInstruction, "xml-stylesheet", "not allowed"}, {RemovedComment, "", "comment"}, {RemovedComment, "", "comment"}},
+		},
+		{
+			name:    "undeclared entity",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"><text>&xxe;</text></svg>`,
+			wantErr: ErrInvalidSVG,
+		},
+		{
+			name:    "content after root",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg"/><svg xmlns="http://www.w3.org/2000/svg"/>`,
+			wantErr: ErrInvalidSVG,
+		},
+		{
+			name:    "no root",
+			input:   `<!-- empty -->`,
+			wantErr: ErrInvalidSVG,
+		},
+		{
+			name:  "attribute values are re-escaped",
+			input: `<svg xmlns="http://www.w3.org/2000/svg"><text font-family='a"b&lt;c'>x</text></svg>`,
+			want:  root + `><text font-family="a&quot;b&lt;c">x</text></svg>`,
+		},
+		{
+			name:    "duplicate attribute through prefixes",
+			input:   `<svg xmlns="http://www.w3.org/2000/svg" xmlns:s="http://www.w3.org/2000/svg"><rect width="1" s:width="2"/></svg>`,
+			want:    root + `><rect width="1"/></svg>`,
+			removed: []Removal{{RemovedAttribute, "width", "duplicate"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, report, err := Sanitize([]byte(tt.input), Limits{})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("output:\n got %s\nwant %s", got, tt.want)
+			}
+			if !reflect.DeepEqual(report.Removed, tt.removed) {
+				t.Fatalf("removed = %+v, want %+v", report.Removed, tt.removed)
+			}
+
+			// Sanitized output must be stable when sanitized again.
+			again, report, err := Sanitize(got, Limits{})
+			if err != nil || string(again) != string(got) || len(report.Removed) != 0 {
+				t.Fatalf("second pass: %s, removed %+v, err %v", again, report.Removed, err)
+			}
+		})
+	}
+}
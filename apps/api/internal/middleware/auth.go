@@ -6,13 +6,17 @@ import (
 
 	"github.com/gin-gonic/gin"
 
-	"nodeimage/api/internal/config"
 	"nodeimage/api/internal/repository"
 	"nodeimage/api/internal/security"
 )
 
-func Auth(cfg *config.AppConfig, users *repository.UserRepository, sessions *repository.SessionRepository) gin.HandlerFunc {
+func Auth(keys *security.KeySet, users *repository.UserRepository, sessions *repository.SessionRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if authenticatedByAPIKey(c) {
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing_token"})
@@ -21,7 +25,7 @@ func Auth(cfg *config.AppConfig, users *repository.UserRepository, sessions *rep
 
 		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
 
-		claims, err := security.ParseAccessToken(tokenStr, cfg.Security.JWTAccessSecret)
+		claims, err := security.ParseAccessToken(tokenStr, keys)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
 			return
@@ -49,6 +53,8 @@ func Auth(cfg *config.AppConfig, users *repository.UserRepository, sessions *rep
 			return
 		}
 
+		claims.Scopes = security.EffectiveScopes(claims.Scopes, user.Role)
+
 		_ = sessions.Touch(c.Request.Context(), session.ID, c.ClientIP(), c.GetHeader("User-Agent"))
 
 		c.Set("access_token", tokenStr)
@@ -6,6 +6,7 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"nodeimage/api/internal/models"
+	"nodeimage/api/internal/security"
 )
 
 func RequireRoles(roles ...models.UserRole) gin.HandlerFunc {
@@ -34,3 +35,62 @@ func RequireRoles(roles ...models.UserRole) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// RequireScopes checks the scopes on the access claims, which Auth and
+// APIKey have already narrowed to what the user's role grants.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claimsVal, exists := c.Get("access_claims")
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		claims, ok := claimsVal.(security.AccessClaims)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_access_claims"})
+			return
+		}
+
+		if !claims.HasScopes(scopes...) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient_scope"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireMFA blocks users whose role is in requiredRoles unless their access
+// token came from a login that passed a second factor. API keys never do.
+func RequireMFA(requiredRoles []string) gin.HandlerFunc {
+	roleSet := make(map[models.UserRole]struct{}, len(requiredRoles))
+	for _, role := range requiredRoles {
+		roleSet[models.UserRole(role)] = struct{}{}
+	}
+
+	return func(c *gin.Context) {
+		userVal, exists := c.Get("current_user")
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		user, ok := userVal.(models.User)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_user"})
+			return
+		}
+		if _, required := roleSet[user.Role]; !required {
+			c.Next()
+			return
+		}
+
+		claimsVal, _ := c.Get("access_claims")
+		claims, ok := claimsVal.(security.AccessClaims)
+		if !ok || !claims.MFA {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "mfa_required"})
+			return
+		}
+
+		c.Next()
+	}
+}
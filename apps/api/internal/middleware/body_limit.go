@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	bodyLimitKey = "body_limit"
+
+	// defaultMaxBodyBytes caps buffered bodies on routes without BodyLimit.
+	defaultMaxBodyBytes = 1 << 20
+)
+
+// BodyLimit caps the request body at limit bytes; reads past it fail with
+// *http.MaxBytesError. It must run before anything that reads the body,
+// including Signature and Idempotency. A limit of zero or less is unlimited.
+func BodyLimit(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limit > 0 && c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		}
+		c.Set(bodyLimitKey, limit)
+		c.Next()
+	}
+}
+
+// readBody buffers the whole request body for middlewares that hash it and
+// puts it back for the handler. It aborts with 413 once the route's limit,
+// or defaultMaxBodyBytes without BodyLimit, is exceeded.
+func readBody(c *gin.Context) ([]byte, bool) {
+	if _, limited := c.Get(bodyLimitKey); !limited {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, defaultMaxBodyBytes)
+	}
+
+	rawBody, err := c.GetRawData()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request_too_large"})
+			return nil, false
+		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_body"})
+		return nil, false
+	}
+	c.Request.Body = NewReadCloser(rawBody)
+	return rawBody, true
+}
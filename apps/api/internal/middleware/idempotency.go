@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"nodeimage/api/internal/security"
+)
+
+const (
+	HeaderIdempotencyKey      = "Idempotency-Key"
+	HeaderIdempotencyReplayed = "Idempotency-Replayed"
+
+	idempotencyStatePending = "pending"
+	idempotencyStateDone    = "done"
+	idempotencyLockTTL      = 5 * time.Minute
+	maxIdempotencyKeyLength = 255
+)
+
+type idempotencyRecord struct {
+	State       string `json:"state"`
+	BodyHash    []byte `json:"bodyHash"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency replays the stored response for a POST retried with the same
+// Idempotency-Key and body, and rejects reuse of a key with a different body.
+// It must run after Auth so keys are scoped to the calling user.
+func Idempotency(redisClient *redis.Client, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(HeaderIdempotencyKey)
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_idempotency_key"})
+			return
+		}
+
+		claimsVal, ok := c.Get("access_claims")
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing_access_claims"})
+			return
+		}
+		claims, ok := claimsVal.(security.AccessClaims)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_access_claims"})
+			return
+		}
+
+		rawBody, ok := readBody(c)
+		if !ok {
+			return
+		}
+
+		sum := sha256.Sum256(append([]byte(c.Request.URL.Path+"\n"), rawBody...))
+		bodyHash := sum[:]
+		redisKey := fmt.Sprintf("idem:%s:%s", claims.UserID, key)
+
+		pending, _ := json.Marshal(idempotencyRecord{State: idempotencyStatePending, BodyHash: bodyHash})
+		acquired, err := redisClient.SetNX(c, redisKey, pending, idempotencyLockTTL).Result()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "idempotency_unavailable"})
+			return
+		}
+
+		if !acquired {
+			replayIdempotent(c, redisClient, redisKey, bodyHash)
+			return
+		}
+
+		writer := &idempotencyWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		status := writer.Status()
+		if status >= http.StatusInternalServerError {
+			redisClient.Del(c, redisKey)
+			return
+		}
+
+		record, err := json.Marshal(idempotencyRecord{
+			State:       idempotencyStateDone,
+			BodyHash:    bodyHash,
+			Status:      status,
+			ContentType: writer.Header().Get("Content-Type"),
+			Body:        writer.body.Bytes(),
+		})
+		if err != nil {
+			redisClient.Del(c, redisKey)
+			return
+		}
+		redisClient.Set(c, redisKey, record, ttl)
+	}
+}
+
+func replayIdempotent(c *gin.Context, redisClient *redis.Client, redisKey string, bodyHash []byte) {
+	raw, err := redisClient.Get(c, redisKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "idempotency_request_in_progress"})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "idempotency_unavailable"})
+		return
+	}
+
+	var record idempotencyRecord
+	if err := json.Unmarshal(raw, &record); err != nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "idempotency_unavailable"})
+		return
+	}
+
+	if !bytes.Equal(record.BodyHash, bodyHash) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "idempotency_key_reused"})
+		return
+	}
+	if record.State != idempotencyStateDone {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "idempotency_request_in_progress"})
+		return
+	}
+
+	c.Header(HeaderIdempotencyReplayed, "true")
+	contentType := record.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Data(record.Status, contentType, record.Body)
+	c.Abort()
+}
@@ -14,12 +14,19 @@ import (
 	"nodeimage/api/internal/security"
 )
 
-func NewReadCloser(b []byte) http.ReadCloser {
+func NewReadCloser(b []byte) io.ReadCloser {
 	return io.NopCloser(bytes.NewReader(b))
 }
 
 func Signature(cfg *config.AppConfig, redisClient *redis.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// API keys are bearer secrets for scripts and have no device key
+		// to sign with.
+		if authenticatedByAPIKey(c) {
+			c.Next()
+			return
+		}
+
 		date, nonce, signature, err := security.ExtractSignatureHeaders(c)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "signature_required"})
@@ -37,12 +44,10 @@ func Signature(cfg *config.AppConfig, redisClient *redis.Client) gin.HandlerFunc
 			return
 		}
 
-		rawBody, err := c.GetRawData()
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_body"})
+		rawBody, ok := readBody(c)
+		if !ok {
 			return
 		}
-		c.Request.Body = NewReadCloser(rawBody)
 
 		claims, ok := c.Get("access_claims")
 		if !ok {
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+type APIKey struct {
+	ID         string
+	UserID     string
+	Name       string
+	KeyHash    []byte
+	KeyPrefix  string
+	Scopes     []string
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
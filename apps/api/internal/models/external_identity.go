@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// ExternalIdentity links a user to an account at an OIDC provider.
+type ExternalIdentity struct {
+	ID          string
+	UserID      string
+	Provider    string
+	Subject     string
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt *time.Time
+}
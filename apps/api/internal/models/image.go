@@ -9,6 +9,8 @@ const (
 	ImageStatusReady      ImageStatus = "ready"
 	ImageStatusBlocked    ImageStatus = "blocked"
 	ImageStatusDeleted    ImageStatus = "deleted"
+	// ImageStatusFailed marks images the worker rejected and will not retry.
+	ImageStatusFailed ImageStatus = "failed"
 )
 
 type Image struct {
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// UserMFA holds a user's TOTP enrollment. The secret is sealed; EnabledAt
+// stays nil until the user confirms a first code.
+type UserMFA struct {
+	UserID       string
+	TOTPSecret   []byte
+	EnabledAt    *time.Time
+	LastUsedStep int64
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (m UserMFA) Enabled() bool {
+	return m.EnabledAt != nil
+}
@@ -19,15 +19,16 @@ const (
 )
 
 type User struct {
-	ID           string
-	Email        string
-	PasswordHash []byte
-	DisplayName  string
-	Role         UserRole
-	Status       UserStatus
-	AvatarURL    *string
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID            string
+	Email         string
+	PasswordHash  []byte
+	DisplayName   string
+	Role          UserRole
+	Status        UserStatus
+	AvatarURL     *string
+	StripMetadata bool
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
 
 type Session struct {
@@ -36,9 +37,22 @@ type Session struct {
 	DeviceID         string
 	DeviceName       string
 	RefreshTokenHash []byte
+	Scopes           []string
+	MFA              bool
 	IPAddress        string
 	UserAgent        string
 	CreatedAt        time.Time
 	LastSeenAt       time.Time
 	ExpiresAt        time.Time
 }
+
+// RotatedRefreshToken records a refresh token hash its session has already
+// replaced.
+type RotatedRefreshToken struct {
+	RefreshTokenHash []byte
+	SessionID        string
+	UserID           string
+	DeviceID         string
+	RotatedAt        time.Time
+	ExpiresAt        time.Time
+}
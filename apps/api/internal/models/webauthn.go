@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+type WebAuthnCredential struct {
+	ID              string
+	UserID          string
+	CredentialID    []byte
+	PublicKey       []byte
+	Name            string
+	AttestationType string
+	Transports      []string
+	AAGUID          []byte
+	SignCount       uint32
+	BackupEligible  bool
+	BackupState     bool
+	CloneWarning    bool
+	LastUsedAt      *time.Time
+	CreatedAt       time.Time
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"nodeimage/api/internal/models"
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+type APIKeyRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewAPIKeyRepository(pool *pgxpool.Pool) *APIKeyRepository {
+	return &APIKeyRepository{pool: pool}
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, key models.APIKey) error {
+	const query = `
+		INSERT INTO api_keys (
+			id, user_id, name, key_hash, key_prefix, scopes, created_at, updated_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, NOW(), NOW()
+		)
+	`
+
+	_, err := r.pool.Exec(ctx, query,
+		key.ID,
+		key.UserID,
+		key.Name,
+		key.KeyHash,
+		key.KeyPrefix,
+		key.Scopes,
+	)
+	return err
+}
+
+func (r *APIKeyRepository) FindByHash(ctx context.Context, keyHash []byte) (models.APIKey, error) {
+	const query = `
+		SELECT id, user_id, name, key_hash, key_prefix, scopes, last_used_at, created_at, updated_at
+		FROM api_keys
+		WHERE key_hash = $1
+	`
+
+	row := r.pool.QueryRow(ctx, query, keyHash)
+	var key models.APIKey
+	if err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.KeyHash,
+		&key.KeyPrefix,
+		&key.Scopes,
+		&key.LastUsedAt,
+		&key.CreatedAt,
+		&key.UpdatedAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.APIKey{}, ErrAPIKeyNotFound
+		}
+		return models.APIKey{}, err
+	}
+	return key, nil
+}
+
+func (r *APIKeyRepository) ListByUser(ctx context.Context, userID string) ([]models.APIKey, error) {
+	const query = `
+		SELECT id, user_id, name, key_hash, key_prefix, scopes, last_used_at, created_at, updated_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		var key models.APIKey
+		if err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.KeyHash,
+			&key.KeyPrefix,
+			&key.Scopes,
+			&key.LastUsedAt,
+			&key.CreatedAt,
+			&key.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (r *APIKeyRepository) CountByUser(ctx context.Context, userID string) (int, error) {
+	const query = `SELECT COUNT(*) FROM api_keys WHERE user_id = $1`
+	row := r.pool.QueryRow(ctx, query, userID)
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *APIKeyRepository) Delete(ctx context.Context, userID string, id string) error {
+	const query = `DELETE FROM api_keys WHERE user_id = $1 AND id = $2`
+	cmd, err := r.pool.Exec(ctx, query, userID, id)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (r *APIKeyRepository) TouchLastUsed(ctx context.Context, id string) error {
+	const query = `UPDATE api_keys SET last_used_at = NOW() WHERE id = $1`
+	_, err := r.pool.Exec(ctx, query, id)
+	return err
+}
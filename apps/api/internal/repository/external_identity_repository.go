@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"nodeimage/api/internal/models"
+)
+
+var ErrExternalIdentityNotFound = errors.New("external identity not found")
+
+type ExternalIdentityRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewExternalIdentityRepository(pool *pgxpool.Pool) *ExternalIdentityRepository {
+	return &ExternalIdentityRepository{pool: pool}
+}
+
+func (r *ExternalIdentityRepository) Create(ctx context.Context, identity models.ExternalIdentity) error {
+	const query = `
+		INSERT INTO external_identities (
+			id, user_id, provider, subject, email, created_at, last_login_at
+		) VALUES (
+			$1, $2, $3, $4, $5, NOW(), NOW()
+		)
+	`
+
+	_, err := r.pool.Exec(ctx, query,
+		identity.ID,
+		identity.UserID,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+	)
+	return err
+}
+
+func (r *ExternalIdentityRepository) FindBySubject(ctx context.Context, provider string, subject string) (models.ExternalIdentity, error) {
+	const query = `
+		SELECT id, user_id, provider, subject, email, created_at, last_login_at
+		FROM external_identities
+		WHERE provider = $1 AND subject = $2
+	`
+
+	row := r.pool.QueryRow(ctx, query, provider, subject)
+	var identity models.ExternalIdentity
+	if err := row.Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.CreatedAt,
+		&identity.LastLoginAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.ExternalIdentity{}, ErrExternalIdentityNotFound
+		}
+		return models.ExternalIdentity{}, err
+	}
+	return identity, nil
+}
+
+func (r *ExternalIdentityRepository) ListByUser(ctx context.Context, userID string) ([]models.ExternalIdentity, error) {
+	const query = `
+		SELECT id, user_id, provider, subject, email, created_at, last_login_at
+		FROM external_identities
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := r.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []models.ExternalIdentity
+	for rows.Next() {
+		var identity models.ExternalIdentity
+		if err := rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Provider,
+			&identity.Subject,
+			&identity.Email,
+			&identity.CreatedAt,
+			&identity.LastLoginAt,
+		); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func (r *ExternalIdentityRepository) TouchLogin(ctx context.Context, id string, email string) error {
+	const query = `UPDATE external_identities SET last_login_at = NOW(), email = $2 WHERE id = $1`
+	_, err := r.pool.Exec(ctx, query, id, email)
+	return err
+}
+
+func (r *ExternalIdentityRepository) Delete(ctx context.Context, userID string, id string) error {
+	const query = `DELETE FROM external_identities WHERE user_id = $1 AND id = $2`
+	cmd, err := r.pool.Exec(ctx, query, userID, id)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrExternalIdentityNotFound
+	}
+	return nil
+}
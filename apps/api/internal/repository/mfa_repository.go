@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"nodeimage/api/internal/ids"
+	"nodeimage/api/internal/models"
+)
+
+var (
+	ErrMFANotFound          = errors.New("mfa not configured")
+	ErrTOTPStepUsed         = errors.New("totp code already used")
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+)
+
+type MFARepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewMFARepository(pool *pgxpool.Pool) *MFARepository {
+	return &MFARepository{pool: pool}
+}
+
+func (r *MFARepository) GetByUser(ctx context.Context, userID string) (models.UserMFA, error) {
+	const query = `
+		SELECT user_id, totp_secret, enabled_at, last_used_step, created_at, updated_at
+		FROM user_mfa
+		WHERE user_id = $1
+	`
+	row := r.pool.QueryRow(ctx, query, userID)
+	var mfa models.UserMFA
+	if err := row.Scan(
+		&mfa.UserID,
+		&mfa.TOTPSecret,
+		&mfa.EnabledAt,
+		&mfa.LastUsedStep,
+		&mfa.CreatedAt,
+		&mfa.UpdatedAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.UserMFA{}, ErrMFANotFound
+		}
+		return models.UserMFA{}, err
+	}
+	return mfa, nil
+}
+
+// SavePending stores a new secret awaiting confirmation, replacing any
+// earlier unconfirmed one. An enabled enrollment is left untouched.
+func (r *MFARepository) SavePending(ctx context.Context, userID string, sealedSecret []byte) error {
+	const query = `
+		INSERT INTO user_mfa (user_id, totp_secret, created_at, updated_at)
+		VALUES ($1, $2, NOW(), NOW())
+		ON CONFLICT (user_id) DO UPDATE SET
+			totp_secret = EXCLUDED.totp_secret,
+			last_used_step = 0,
+			updated_at = NOW()
+		WHERE user_mfa.enabled_at IS NULL
+	`
+	_, err := r.pool.Exec(ctx, query, userID, sealedSecret)
+	return err
+}
+
+// Enable confirms the pending secret, records the step of the confirming
+// code and replaces the recovery codes in one transaction.
+func (r *MFARepository) Enable(ctx context.Context, userID string, step int64, codeHashes [][]byte) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	const enable = `
+		UPDATE user_mfa
+		SET enabled_at = NOW(), last_used_step = $2, updated_at = NOW()
+		WHERE user_id = $1 AND enabled_at IS NULL
+	`
+	cmd, err := tx.Exec(ctx, enable, userID, step)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrMFANotFound
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *MFARepository) Delete(ctx context.Context, userID string) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// AdvanceStep records step as used, failing when it is not newer than the
+// last accepted code; this is what makes each TOTP code single-use.
+func (r *MFARepository) AdvanceStep(ctx context.Context, userID string, step int64) error {
+	const query = `
+		UPDATE user_mfa
+		SET last_used_step = $2, updated_at = NOW()
+		WHERE user_id = $1 AND last_used_step < $2
+	`
+	cmd, err := r.pool.Exec(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrTOTPStepUsed
+	}
+	return nil
+}
+
+func (r *MFARepository) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes [][]byte) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *MFARepository) ConsumeRecoveryCode(ctx context.Context, userID string, codeHash []byte) error {
+	const query = `
+		UPDATE mfa_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+	cmd, err := r.pool.Exec(ctx, query, userID, codeHash)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrRecoveryCodeNotFound
+	}
+	return nil
+}
+
+func (r *MFARepository) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	const query = `SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	row := r.pool.QueryRow(ctx, query, userID)
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID string, codeHashes [][]byte) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	const insert = `
+		INSERT INTO mfa_recovery_codes (id, user_id, code_hash, created_at)
+		VALUES ($1, $2, $3, NOW())
+	`
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(ctx, insert, ids.New(), userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
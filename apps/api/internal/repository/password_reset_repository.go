@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"nodeimage/api/internal/models"
+)
+
+var ErrPasswordResetNotFound = errors.New("password reset not found")
+
+type PasswordResetRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewPasswordResetRepository(pool *pgxpool.Pool) *PasswordResetRepository {
+	return &PasswordResetRepository{pool: pool}
+}
+
+// Create stores a new reset and drops the user's earlier ones, so only the
+// most recent email link works.
+func (r *PasswordResetRepository) Create(ctx context.Context, reset models.PasswordReset) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM password_resets WHERE user_id = $1`, reset.UserID); err != nil {
+		return err
+	}
+
+	const query = `
+		INSERT INTO password_resets (
+			id, user_id, token_hash, expires_at, created_at
+		) VALUES (
+			$1, $2, $3, $4, NOW()
+		)
+	`
+	if _, err := tx.Exec(ctx, query, reset.ID, reset.UserID, reset.TokenHash, reset.ExpiresAt); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Consume marks an unused, unexpired reset as used and returns it. Of two
+// concurrent attempts with the same token only one succeeds.
+func (r *PasswordResetRepository) Consume(ctx context.Context, tokenHash []byte) (models.PasswordReset, error) {
+	const query = `
+		UPDATE password_resets
+		SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING id, user_id, token_hash, expires_at, used_at, created_at
+	`
+	row := r.pool.QueryRow(ctx, query, tokenHash)
+	var reset models.PasswordReset
+	if err := row.Scan(
+		&reset.ID,
+		&reset.UserID,
+		&reset.TokenHash,
+		&reset.ExpiresAt,
+		&reset.UsedAt,
+		&reset.CreatedAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.PasswordReset{}, ErrPasswordResetNotFound
+		}
+		return models.PasswordReset{}, err
+	}
+	return reset, nil
+}
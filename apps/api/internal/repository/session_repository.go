@@ -23,14 +23,16 @@ func NewSessionRepository(pool *pgxpool.Pool) *SessionRepository {
 func (r *SessionRepository) Create(ctx context.Context, session models.Session) error {
 	const query = `
 		INSERT INTO user_sessions (
-			id, user_id, device_id, device_name, refresh_token_hash, ip_address, user_agent, created_at, last_seen_at, expires_at
+			id, user_id, device_id, device_name, refresh_token_hash, scopes, mfa, ip_address, user_agent, created_at, last_seen_at, expires_at
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, NOW(), NOW(), $8
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, NOW(), NOW(), $10
 		)
 	ON CONFLICT (user_id, device_id)
 	DO UPDATE SET
 			id = EXCLUDED.id,
 			refresh_token_hash = EXCLUDED.refresh_token_hash,
+			scopes = EXCLUDED.scopes,
+			mfa = EXCLUDED.mfa,
 			ip_address = EXCLUDED.ip_address,
 			user_agent = EXCLUDED.user_agent,
 			last_seen_at = NOW(),
@@ -43,6 +45,8 @@ func (r *SessionRepository) Create(ctx context.Context, session models.Session)
 		session.DeviceID,
 		session.DeviceName,
 		session.RefreshTokenHash,
+		session.Scopes,
+		session.MFA,
 		session.IPAddress,
 		session.UserAgent,
 		session.ExpiresAt,
@@ -76,7 +80,7 @@ func (r *SessionRepository) DeleteOldestSessions(ctx context.Context, userID str
 
 func (r *SessionRepository) GetByID(ctx context.Context, id string) (models.Session, error) {
 	const query = `
-		SELECT id, user_id, device_id, device_name, refresh_token_hash, ip_address, user_agent, created_at, last_seen_at, expires_at
+		SELECT id, user_id, device_id, device_name, refresh_token_hash, scopes, mfa, ip_address, user_agent, created_at, last_seen_at, expires_at
 		FROM user_sessions
 		WHERE id = $1
 	`
@@ -89,6 +93,8 @@ func (r *SessionRepository) GetByID(ctx context.Context, id string) (models.Sess
 		&session.DeviceID,
 		&session.DeviceName,
 		&session.RefreshTokenHash,
+		&session.Scopes,
+		&session.MFA,
 		&session.IPAddress,
 		&session.UserAgent,
 		&session.CreatedAt,
@@ -121,37 +127,22 @@ func (r *SessionRepository) DeleteByDevice(ctx context.Context, userID string, d
 	return err
 }
 
-func (r *SessionRepository) FindByRefreshHash(ctx context.Context, userID string, refreshHash []byte) (models.Session, error) {
-	const query = `
-		SELECT id, user_id, device_id, device_name, refresh_token_hash, ip_address, user_agent, created_at, last_seen_at, expires_at
-		FROM user_sessions
-		WHERE user_id = $1 AND refresh_token_hash = $2
-	`
-	row := r.pool.QueryRow(ctx, query, userID, refreshHash)
-	var session models.Session
-	if err := row.Scan(
-		&session.ID,
-		&session.UserID,
-		&session.DeviceID,
-		&session.DeviceName,
-		&session.RefreshTokenHash,
-		&session.IPAddress,
-		&session.UserAgent,
-		&session.CreatedAt,
-		&session.LastSeenAt,
-		&session.ExpiresAt,
-	); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return models.Session{}, ErrSessionNotFound
-		}
-		return models.Session{}, err
-	}
-	return session, nil
+// MarkMFA records that the session has passed a second factor.
+func (r *SessionRepository) MarkMFA(ctx context.Context, sessionID string) error {
+	const query = `UPDATE user_sessions SET mfa = TRUE WHERE id = $1`
+	_, err := r.pool.Exec(ctx, query, sessionID)
+	return err
+}
+
+func (r *SessionRepository) DeleteByUser(ctx context.Context, userID string) error {
+	const query = `DELETE FROM user_sessions WHERE user_id = $1`
+	_, err := r.pool.Exec(ctx, query, userID)
+	return err
 }
 
 func (r *SessionRepository) ListByUser(ctx context.Context, userID string) ([]models.Session, error) {
 	const query = `
-		SELECT id, user_id, device_id, device_name, refresh_token_hash, ip_address, user_agent, created_at, last_seen_at, expires_at
+		SELECT id, user_id, device_id, device_name, refresh_token_hash, scopes, mfa, ip_address, user_agent, created_at, last_seen_at, expires_at
 		FROM user_sessions
 		WHERE user_id = $1
 		ORDER BY last_seen_at DESC
@@ -172,6 +163,8 @@ func (r *SessionRepository) ListByUser(ctx context.Context, userID string) ([]mo
 			&session.DeviceID,
 			&session.DeviceName,
 			&session.RefreshTokenHash,
+			&session.Scopes,
+			&session.MFA,
 			&session.IPAddress,
 			&session.UserAgent,
 			&session.CreatedAt,
@@ -196,3 +189,72 @@ func (r *SessionRepository) Touch(ctx context.Context, sessionID string, ip stri
 	_, err := r.pool.Exec(ctx, query, sessionID, ip, userAgent)
 	return err
 }
+
+// RotateRefreshHash swaps the session's refresh hash only if it still holds
+// oldHash, and records oldHash as rotated. Two concurrent refreshes with the
+// same token cannot both succeed; the loser gets ErrSessionNotFound.
+func (r *SessionRepository) RotateRefreshHash(ctx context.Context, session models.Session, oldHash []byte) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	const rotate = `
+		UPDATE user_sessions
+		SET refresh_token_hash = $3,
+		    expires_at = $4,
+		    last_seen_at = NOW()
+		WHERE id = $1 AND refresh_token_hash = $2
+	`
+	cmd, err := tx.Exec(ctx, rotate, session.ID, oldHash, session.RefreshTokenHash, session.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+
+	const record = `
+		INSERT INTO session_refresh_history (
+			refresh_token_hash, session_id, user_id, device_id, rotated_at, expires_at
+		) VALUES (
+			$1, $2, $3, $4, NOW(), $5
+		)
+		ON CONFLICT (refresh_token_hash) DO NOTHING
+	`
+	if _, err := tx.Exec(ctx, record, oldHash, session.ID, session.UserID, session.DeviceID, session.ExpiresAt); err != nil {
+		return err
+	}
+
+	const prune = `DELETE FROM session_refresh_history WHERE user_id = $1 AND expires_at < NOW()`
+	if _, err := tx.Exec(ctx, prune, session.UserID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *SessionRepository) FindRotatedRefreshHash(ctx context.Context, sessionID string, refreshHash []byte) (models.RotatedRefreshToken, error) {
+	const query = `
+		SELECT refresh_token_hash, session_id, user_id, device_id, rotated_at, expires_at
+		FROM session_refresh_history
+		WHERE session_id = $1 AND refresh_token_hash = $2
+	`
+	row := r.pool.QueryRow(ctx, query, sessionID, refreshHash)
+	var rotated models.RotatedRefreshToken
+	if err := row.Scan(
+		&rotated.RefreshTokenHash,
+		&rotated.SessionID,
+		&rotated.UserID,
+		&rotated.DeviceID,
+		&rotated.RotatedAt,
+		&rotated.ExpiresAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.RotatedRefreshToken{}, ErrSessionNotFound
+		}
+		return models.RotatedRefreshToken{}, err
+	}
+	return rotated, nil
+}
@@ -23,9 +23,9 @@ func NewUserRepository(pool *pgxpool.Pool) *UserRepository {
 func (r *UserRepository) Create(ctx context.Context, user models.User) error {
 	const query = `
 		INSERT INTO users (
-			id, email, password_hash, display_name, role, status, avatar_url, created_at, updated_at
+			id, email, password_hash, display_name, role, status, avatar_url, strip_metadata, created_at, updated_at
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, NOW(), NOW()
+			$1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW()
 		)
 	`
 
@@ -37,13 +37,14 @@ func (r *UserRepository) Create(ctx context.Context, user models.User) error {
 		user.Role,
 		user.Status,
 		user.AvatarURL,
+		user.StripMetadata,
 	)
 	return err
 }
 
 func (r *UserRepository) FindByEmail(ctx context.Context, email string) (models.User, error) {
 	const query = `
-		SELECT id, email, password_hash, display_name, role, status, avatar_url, created_at, updated_at
+		SELECT id, email, password_hash, display_name, role, status, avatar_url, strip_metadata, created_at, updated_at
 		FROM users WHERE email = $1
 	`
 
@@ -57,6 +58,7 @@ func (r *UserRepository) FindByEmail(ctx context.Context, email string) (models.
 		&user.Role,
 		&user.Status,
 		&user.AvatarURL,
+		&user.StripMetadata,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	); err != nil {
@@ -70,7 +72,7 @@ func (r *UserRepository) FindByEmail(ctx context.Context, email string) (models.
 
 func (r *UserRepository) GetByID(ctx context.Context, id string) (models.User, error) {
 	const query = `
-		SELECT id, email, password_hash, display_name, role, status, avatar_url, created_at, updated_at
+		SELECT id, email, password_hash, display_name, role, status, avatar_url, strip_metadata, created_at, updated_at
 		FROM users WHERE id = $1
 	`
 
@@ -84,6 +86,7 @@ func (r *UserRepository) GetByID(ctx context.Context, id string) (models.User, e
 		&user.Role,
 		&user.Status,
 		&user.AvatarURL,
+		&user.StripMetadata,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	); err != nil {
@@ -108,3 +111,31 @@ func (r *UserRepository) UpdateStatus(ctx context.Context, id string, status mod
 	}
 	return nil
 }
+
+func (r *UserRepository) UpdateStripMetadata(ctx context.Context, id string, strip bool) error {
+	const query = `
+		UPDATE users SET strip_metadata = $2, updated_at = NOW() WHERE id = $1
+	`
+	cmd, err := r.pool.Exec(ctx, query, id, strip)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) UpdatePassword(ctx context.Context, id string, passwordHash []byte) error {
+	const query = `
+		UPDATE users SET password_hash = $2, updated_at = NOW() WHERE id = $1
+	`
+	cmd, err := r.pool.Exec(ctx, query, id, passwordHash)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"nodeimage/api/internal/models"
+)
+
+var ErrWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+
+type WebAuthnRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewWebAuthnRepository(pool *pgxpool.Pool) *WebAuthnRepository {
+	return &WebAuthnRepository{pool: pool}
+}
+
+const webAuthnColumns = `
+	id, user_id, credential_id, public_key, name, attestation_type, transports,
+	aaguid, sign_count, backup_eligible, backup_state, clone_warning, last_used_at, created_at
+`
+
+func (r *WebAuthnRepository) Create(ctx context.Context, cred models.WebAuthnCredential) error {
+	const query = `
+		INSERT INTO webauthn_credentials (
+			id, user_id, credential_id, public_key, name, attestation_type, transports,
+			aaguid, sign_count, backup_eligible, backup_state, created_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW()
+		)
+	`
+
+	_, err := r.pool.Exec(ctx, query,
+		cred.ID,
+		cred.UserID,
+		cred.CredentialID,
+		cred.PublicKey,
+		cred.Name,
+		cred.AttestationType,
+		cred.Transports,
+		cred.AAGUID,
+		int64(cred.SignCount),
+		cred.BackupEligible,
+		cred.BackupState,
+	)
+	return err
+}
+
+func (r *WebAuthnRepository) ListByUser(ctx context.Context, userID string) ([]models.WebAuthnCredential, error) {
+	query := `SELECT` + webAuthnColumns + `FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at`
+
+	rows, err := r.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creds []models.WebAuthnCredential
+	for rows.Next() {
+		cred, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, cred)
+	}
+	return creds, rows.Err()
+}
+
+func (r *WebAuthnRepository) FindByCredentialID(ctx context.Context, credentialID []byte) (models.WebAuthnCredential, error) {
+	query := `SELECT` + webAuthnColumns + `FROM webauthn_credentials WHERE credential_id = $1`
+
+	cred, err := scanWebAuthnCredential(r.pool.QueryRow(ctx, query, credentialID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.WebAuthnCredential{}, ErrWebAuthnCredentialNotFound
+		}
+		return models.WebAuthnCredential{}, err
+	}
+	return cred, nil
+}
+
+func (r *WebAuthnRepository) CountByUser(ctx context.Context, userID string) (int, error) {
+	const query = `SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = $1`
+	row := r.pool.QueryRow(ctx, query, userID)
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// RecordUse stores the authenticator state after a successful assertion.
+func (r *WebAuthnRepository) RecordUse(ctx context.Context, id string, signCount uint32, backupState bool, cloneWarning bool) error {
+	const query = `
+		UPDATE webauthn_credentials
+		SET sign_count = $2,
+		    backup_state = $3,
+		    clone_warning = clone_warning OR $4,
+		    last_used_at = NOW()
+		WHERE id = $1
+	`
+	_, err := r.pool.Exec(ctx, query, id, int64(signCount), backupState, cloneWarning)
+	return err
+}
+
+func (r *WebAuthnRepository) Delete(ctx context.Context, userID string, id string) error {
+	const query = `DELETE FROM webauthn_credentials WHERE user_id = $1 AND id = $2`
+	cmd, err := r.pool.Exec(ctx, query, userID, id)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
+
+func scanWebAuthnCredential(row pgx.Row) (models.WebAuthnCredential, error) {
+	var cred models.WebAuthnCredential
+	var signCount int64
+	if err := row.Scan(
+		&cred.ID,
+		&cred.UserID,
+		&cred.CredentialID,
+		&cred.PublicKey,
+		&cred.Name,
+		&cred.AttestationType,
+		&cred.Transports,
+		&cred.AAGUID,
+		&signCount,
+		&cred.BackupEligible,
+		&cred.BackupState,
+		&cred.CloneWarning,
+		&cred.LastUsedAt,
+		&cred.CreatedAt,
+	); err != nil {
+		return models.WebAuthnCredential{}, err
+	}
+	cred.SignCount = uint32(signCount)
+	return cred, nil
+}
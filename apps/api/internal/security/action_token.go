@@ -0,0 +1,94 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidActionToken = errors.New("invalid action token")
+	ErrExpiredActionToken = errors.New("action token expired")
+)
+
+// ActionToken is the signed content of an emailed link. Signing only proves
+// the server issued it; callers make it single-use by storing a hash of
+// Nonce and consuming it on first use.
+type ActionToken struct {
+	Purpose   string
+	Subject   string
+	Nonce     string
+	ExpiresAt time.Time
+}
+
+// GenerateActionToken signs a token for one purpose (e.g. "verify_email")
+// and subject (a user ID). Tokens for one purpose never parse as another.
+func GenerateActionToken(secret string, purpose string, subject string, ttl time.Duration) (string, ActionToken, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", ActionToken{}, fmt.Errorf("generate action token: %w", err)
+	}
+	action := ActionToken{
+		Purpose:   purpose,
+		Subject:   subject,
+		Nonce:     base64.RawURLEncoding.EncodeToString(buf),
+		ExpiresAt: time.Now().Add(ttl).Truncate(time.Second),
+	}
+
+	payload := strings.Join([]string{purpose, subject, action.Nonce, strconv.FormatInt(action.ExpiresAt.Unix(), 10)}, "|")
+	token := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(actionMAC(secret, payload))
+	return token, action, nil
+}
+
+func ParseActionToken(secret string, purpose string, token string) (ActionToken, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return ActionToken{}, ErrInvalidActionToken
+	}
+	rawPayload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ActionToken{}, ErrInvalidActionToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, actionMAC(secret, string(rawPayload))) {
+		return ActionToken{}, ErrInvalidActionToken
+	}
+
+	parts := strings.Split(string(rawPayload), "|")
+	if len(parts) != 4 || parts[0] != purpose {
+		return ActionToken{}, ErrInvalidActionToken
+	}
+	expires, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return ActionToken{}, ErrInvalidActionToken
+	}
+	action := ActionToken{
+		Purpose:   parts[0],
+		Subject:   parts[1],
+		Nonce:     parts[2],
+		ExpiresAt: time.Unix(expires, 0),
+	}
+	if time.Now().After(action.ExpiresAt) {
+		return ActionToken{}, ErrExpiredActionToken
+	}
+	return action, nil
+}
+
+// HashNonce is what callers store to make an action token single-use.
+func HashNonce(nonce string) []byte {
+	sum := sha256.Sum256([]byte(nonce))
+	return sum[:]
+}
+
+func actionMAC(secret string, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,35 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// APIKeyPrefix marks API keys so the auth middleware can tell them apart
+// from JWTs in the same Authorization header, and secret scanners can find
+// leaked keys.
+const APIKeyPrefix = "ni_"
+
+// GenerateAPIKey returns the key to show the user once, its hash for
+// storage, and a short non-secret prefix for identifying it in listings.
+func GenerateAPIKey() (string, []byte, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, "", fmt.Errorf("generate api key: %w", err)
+	}
+
+	key := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	return key, HashAPIKey(key), key[:len(APIKeyPrefix)+6], nil
+}
+
+func HashAPIKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
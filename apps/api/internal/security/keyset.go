@@ -0,0 +1,250 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgHS512 = "HS512"
+	AlgEdDSA = "EdDSA"
+	AlgES256 = "ES256"
+)
+
+var ErrNoActiveKey = errors.New("no active signing key")
+
+// KeySpec describes one access-token signing key. HS512 keys use Secret;
+// EdDSA and ES256 keys use a PEM private key (PKCS#8, or SEC 1 for EC).
+// A key signs from ActiveFrom until a later key becomes active, and is
+// accepted for verification until RetireAt. Zero times mean unbounded.
+type KeySpec struct {
+	ID            string
+	Algorithm     string
+	Secret        string
+	PrivateKeyPEM []byte
+	ActiveFrom    time.Time
+	RetireAt      time.Time
+}
+
+type signingKey struct {
+	id         string
+	method     jwt.SigningMethod
+	signKey    any
+	verifyKey  any
+	activeFrom time.Time
+	retireAt   time.Time
+}
+
+func (k *signingKey) retired(now time.Time) bool {
+	return !k.retireAt.IsZero() && !now.Before(k.retireAt)
+}
+
+// KeySet signs access tokens with the currently active key and verifies them
+// with any key that has not been retired, picked by the kid header. Tokens
+// without a kid are checked against the legacy secret, so tokens issued
+// before key IDs existed keep working until they expire.
+type KeySet struct {
+	keys []*signingKey
+	byID map[string]*signingKey
+}
+
+// NewKeySet builds a key set from specs. A non-empty legacySecret adds an
+// HS512 key without a kid that signs only while no configured key is active.
+func NewKeySet(specs []KeySpec, legacySecret string) (*KeySet, error) {
+	ks := &KeySet{byID: make(map[string]*signingKey)}
+	if legacySecret != "" {
+		key := &signingKey{
+			method:    jwt.SigningMethodHS512,
+			signKey:   []byte(legacySecret),
+			verifyKey: []byte(legacySecret),
+		}
+		ks.keys = append(ks.keys, key)
+		ks.byID[""] = key
+	}
+
+	for _, spec := range specs {
+		if spec.ID == "" {
+			return nil, fmt.Errorf("jwt key: id required")
+		}
+		if _, exists := ks.byID[spec.ID]; exists {
+			return nil, fmt.Errorf("jwt key %q: duplicate id", spec.ID)
+		}
+		key, err := newSigningKey(spec)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", spec.ID, err)
+		}
+		ks.keys = append(ks.keys, key)
+		ks.byID[spec.ID] = key
+	}
+
+	if len(ks.keys) == 0 {
+		return nil, fmt.Errorf("jwt keys: none configured")
+	}
+	return ks, nil
+}
+
+func newSigningKey(spec KeySpec) (*signingKey, error) {
+	key := &signingKey{
+		id:         spec.ID,
+		activeFrom: spec.ActiveFrom,
+		retireAt:   spec.RetireAt,
+	}
+
+	switch spec.Algorithm {
+	case "", AlgHS512:
+		if len(spec.Secret) < 32 {
+			return nil, fmt.Errorf("HS512 secret must be at least 32 bytes")
+		}
+		key.method = jwt.SigningMethodHS512
+		key.signKey = []byte(spec.Secret)
+		key.verifyKey = []byte(spec.Secret)
+	case AlgEdDSA:
+		private, err := parsePrivateKey(spec.PrivateKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		edKey, ok := private.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("EdDSA needs an Ed25519 private key")
+		}
+		key.method = jwt.SigningMethodEdDSA
+		key.signKey = edKey
+		key.verifyKey = edKey.Public()
+	case AlgES256:
+		private, err := parsePrivateKey(spec.PrivateKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		ecKey, ok := private.(*ecdsa.PrivateKey)
+		if !ok || ecKey.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ES256 needs a P-256 private key")
+		}
+		key.method = jwt.SigningMethodES256
+		key.signKey = ecKey
+		key.verifyKey = &ecKey.PublicKey
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", spec.Algorithm)
+	}
+	return key, nil
+}
+
+func parsePrivateKey(data []byte) (any, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM")
+	}
+	if block.Type == "EC PRIVATE KEY" {
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+// active returns the key that signs at now: the one with the latest
+// ActiveFrom not after now, later configured keys winning ties.
+func (ks *KeySet) active(now time.Time) (*signingKey, error) {
+	var best *signingKey
+	for _, key := range ks.keys {
+		if key.retired(now) || key.activeFrom.After(now) {
+			continue
+		}
+		if best == nil || !key.activeFrom.Before(best.activeFrom) {
+			best = key
+		}
+	}
+	if best == nil {
+		return nil, ErrNoActiveKey
+	}
+	return best, nil
+}
+
+// Sign signs claims with the active key and sets the kid header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key, err := ks.active(time.Now())
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	if key.id != "" {
+		token.Header["kid"] = key.id
+	}
+	return token.SignedString(key.signKey)
+}
+
+// Parse verifies tokenStr with the key named by its kid and decodes it into
+// claims. The token's alg must match the key's algorithm.
+func (ks *KeySet) Parse(tokenStr string, claims jwt.Claims) (*jwt.Token, error) {
+	now := time.Now()
+	return jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ks.byID[kid]
+		if !ok || key.retired(now) {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.verifyKey, nil
+	})
+}
+
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public halves of asymmetric keys that are not retired,
+// including ones scheduled to become active, so verifiers can fetch them
+// before the switch. HS512 keys are never published.
+func (ks *KeySet) JWKS() JWKSet {
+	now := time.Now()
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		if key.id == "" || key.retired(now) {
+			continue
+		}
+		switch public := key.verifyKey.(type) {
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.id,
+				Use:       "sig",
+				Algorithm: AlgEdDSA,
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		case *ecdsa.PublicKey:
+			x := make([]byte, 32)
+			y := make([]byte, 32)
+			public.X.FillBytes(x)
+			public.Y.FillBytes(y)
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "EC",
+				KeyID:     key.id,
+				Use:       "sig",
+				Algorithm: AlgES256,
+				Curve:     "P-256",
+				X:         base64.RawURLEncoding.EncodeToString(x),
+				Y:         base64.RawURLEncoding.EncodeToString(y),
+			})
+		}
+	}
+	return set
+}
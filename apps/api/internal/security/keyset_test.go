@@ -0,0 +1,272 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	legacySecret = "legacy-secret-legacy-secret-0000"
+	hmacSecret   = "hmac-secret-hmac-secret-hmac-000"
+)
+
+func ed25519PEM(t *testing.T) []byte {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func es256PEM(t *testing.T) []byte {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func mustKeySet(t *testing.T, specs []KeySpec, legacy string) *KeySet {
+	t.Helper()
+	ks, err := NewKeySet(specs, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func testClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Subject:   "user-1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+}
+
+func TestKeySetSign(t *testing.T) {
+	now := time.Now()
+	edPEM := ed25519PEM(t)
+	ecPEM := es256PEM(t)
+
+	tests := []struct {
+		name    string
+		specs   []KeySpec
+		legacy  string
+		wantKid string
+		wantAlg string
+		wantErr error
+	}{
+		{
+			name:    "legacy only",
+			legacy:  legacySecret,
+			wantAlg: AlgHS512,
+		},
+		{
+			name:    "configured key replaces legacy",
+			specs:   []KeySpec{{ID: "k1", Secret: hmacSecret}},
+			legacy:  legacySecret,
+			wantKid: "k1",
+			wantAlg: AlgHS512,
+		},
+		{
+			name: "latest active key signs",
+			specs: []KeySpec{
+				{ID: "new", Algorithm: AlgEdDSA, PrivateKeyPEM: edPEM, ActiveFrom: now.Add(-time.Hour)},
+				{ID: "old", Algorithm: AlgES256, PrivateKeyPEM: ecPEM, ActiveFrom: now.Add(-2 * time.Hour)},
+			},
+			wantKid: "new",
+			wantAlg: AlgEdDSA,
+		},
+		{
+			name: "future key does not sign yet",
+			specs: []KeySpec{
+				{ID: "old", Algorithm: AlgES256, PrivateKeyPEM: ecPEM, ActiveFrom: now.Add(-time.Hour)},
+				{ID: "next", Algorithm: AlgEdDSA, PrivateKeyPEM: edPEM, ActiveFrom: now.Add(time.Hour)},
+			},
+			wantKid: "old",
+			wantAlg: AlgES256,
+		},
+		{
+			name: "retired key stops signing",
+			specs: []KeySpec{
+				{ID: "old", Secret: hmacSecret, ActiveFrom: now.Add(-time.Hour), RetireAt: now.Add(-time.Minute)},
+			},
+			legacy:  legacySecret,
+			wantAlg: AlgHS512,
+		},
+		{
+			name: "later configured key wins a tie",
+			specs: []KeySpec{
+				{ID: "a", Secret: hmacSecret, ActiveFrom: now.Add(-time.Hour)},
+				{ID: "b", Algorithm: AlgEdDSA, PrivateKeyPEM: edPEM, ActiveFrom: now.Add(-time.Hour)},
+			},
+			wantKid: "b",
+			wantAlg: AlgEdDSA,
+		},
+		{
+			name: "no active key",
+			specs: []KeySpec{
+				{ID: "next", Secret: hmacSecret, ActiveFrom: now.Add(time.Hour)},
+			},
+			wantErr: ErrNoActiveKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks := mustKeySet(t, tt.specs, tt.legacy)
+			signed, err := ks.Sign(testClaims())
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, _, err := jwt.NewParser().ParseUnverified(signed, &jwt.RegisteredClaims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			kid, hasKid := token.Header["kid"].(string)
+			if kid != tt.wantKid || hasKid != (tt.wantKid != "") {
+				t.Fatalf("kid = %q (present %v), want %q", kid, hasKid, tt.wantKid)
+			}
+			if token.Method.Alg() != tt.wantAlg {
+				t.Fatalf("alg = %s, want %s", token.Method.Alg(), tt.wantAlg)
+			}
+			claims := &jwt.RegisteredClaims{}
+			if _, err := ks.Parse(signed, claims); err != nil || claims.Subject != "user-1" {
+				t.Fatalf("round trip: subject %q, err %v", claims.Subject, err)
+			}
+		})
+	}
+}
+
+func TestKeySetParseAcrossRotation(t *testing.T) {
+	now := time.Now()
+	edPEM := ed25519PEM(t)
+	ecPEM := es256PEM(t)
+	oldSpec := KeySpec{ID: "old", Algorithm: AlgES256, PrivateKeyPEM: ecPEM, ActiveFrom: now.Add(-2 * time.Hour)}
+	newSpec := KeySpec{ID: "new", Algorithm: AlgEdDSA, PrivateKeyPEM: edPEM, ActiveFrom: now.Add(-time.Hour)}
+
+	// Tokens issued by each generation of keys.
+	sign := func(ks *KeySet) string {
+		signed, err := ks.Sign(testClaims())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	legacyToken := sign(mustKeySet(t, nil, legacySecret))
+	oldToken := sign(mustKeySet(t, []KeySpec{oldSpec}, ""))
+	newToken := sign(mustKeySet(t, []KeySpec{oldSpec, newSpec}, ""))
+
+	// Forged tokens: an HS512 token claiming the EdDSA key's kid, signed
+	// with that key's public half, and a kid nothing knows.
+	ks := mustKeySet(t, []KeySpec{oldSpec, newSpec}, "")
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS512, testClaims())
+	confused.Header["kid"] = "new"
+	confusedToken, err := confused.SignedString([]byte(ks.byID["new"].verifyKey.(ed25519.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS512, testClaims())
+	unknown.Header["kid"] = "missing"
+	unknownToken, err := unknown.SignedString([]byte(hmacSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retiredOld := oldSpec
+	retiredOld.RetireAt = now.Add(-time.Minute)
+	retiringOld := oldSpec
+	retiringOld.RetireAt = now.Add(time.Hour)
+	otherEd := newSpec
+	otherEd.PrivateKeyPEM = ed25519PEM(t)
+
+	tests := []struct {
+		name    string
+		specs   []KeySpec
+		legacy  string
+		token   string
+		wantErr bool
+	}{
+		{name: "current key", specs: []KeySpec{oldSpec, newSpec}, token: newToken},
+		{name: "previous key before retirement", specs: []KeySpec{retiringOld, newSpec}, token: oldToken},
+		{name: "previous key after retirement", specs: []KeySpec{retiredOld, newSpec}, token: oldToken, wantErr: true},
+		{name: "legacy token with legacy secret", specs: []KeySpec{oldSpec, newSpec}, legacy: legacySecret, token: legacyToken},
+		{name: "legacy token without legacy secret", specs: []KeySpec{oldSpec, newSpec}, token: legacyToken, wantErr: true},
+		{name: "kid with different key material", specs: []KeySpec{oldSpec, otherEd}, token: newToken, wantErr: true},
+		{name: "alg mismatch", specs: []KeySpec{oldSpec, newSpec}, token: confusedToken, wantErr: true},
+		{name: "unknown kid", specs: []KeySpec{oldSpec, newSpec}, legacy: legacySecret, token: unknownToken, wantErr: true},
+		{name: "tampered payload", specs: []KeySpec{oldSpec, newSpec}, token: tamper(newToken), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &jwt.RegisteredClaims{}
+			_, err := mustKeySet(t, tt.specs, tt.legacy).Parse(tt.token, claims)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("token accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Subject != "user-1" {
+				t.Fatalf("subject = %q", claims.Subject)
+			}
+		})
+	}
+}
+
+func TestNewKeySetRejectsBadSpecs(t *testing.T) {
+	tests := []struct {
+		name  string
+		specs []KeySpec
+	}{
+		{name: "none"},
+		{name: "missing id", specs: []KeySpec{{Secret: hmacSecret}}},
+		{name: "duplicate id", specs: []KeySpec{{ID: "a", Secret: hmacSecret}, {ID: "a", Secret: hmacSecret}}},
+		{name: "short secret", specs: []KeySpec{{ID: "a", Secret: "short"}}},
+		{name: "unknown algorithm", specs: []KeySpec{{ID: "a", Algorithm: "RS256", Secret: hmacSecret}}},
+		{name: "not pem", specs: []KeySpec{{ID: "a", Algorithm: AlgEdDSA, PrivateKeyPEM: []byte("nope")}}},
+		{name: "wrong key type", specs: []KeySpec{{ID: "a", Algorithm: AlgEdDSA, PrivateKeyPEM: es256PEM(t)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeySet(tt.specs, ""); err == nil {
+				t.Fatal("NewKeySet accepted bad specs")
+			}
+		})
+	}
+}
+
+// tamper swaps the payload for one naming another subject.
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-2"}`))
+	return parts[0] + "." + payload + "." + parts[2]
+}
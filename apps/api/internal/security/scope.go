@@ -0,0 +1,74 @@
+package security
+
+import (
+	"fmt"
+	"slices"
+
+	"nodeimage/api/internal/models"
+)
+
+const (
+	ScopeMediaRead      = "media:read"
+	ScopeMediaWrite     = "media:write"
+	ScopeSessionsManage = "sessions:manage"
+	ScopeAPIKeysManage  = "api_keys:manage"
+	ScopeAdminImages    = "admin:images"
+	ScopeAdminUsers     = "admin:users"
+)
+
+var userScopes = []string{ScopeMediaRead, ScopeMediaWrite, ScopeSessionsManage, ScopeAPIKeysManage}
+
+// roleScopes is the most a token or key of each role can carry; a role not
+// listed here gets no scopes.
+var roleScopes = map[models.UserRole][]string{
+	models.UserRoleUser:       userScopes,
+	models.UserRoleAdmin:      append(slices.Clone(userScopes), ScopeAdminImages),
+	models.UserRoleSuperAdmin: append(slices.Clone(userScopes), ScopeAdminImages, ScopeAdminUsers),
+}
+
+func RoleScopes(role models.UserRole) []string {
+	return slices.Clone(roleScopes[role])
+}
+
+// ValidateScopes rejects unknown scopes and any scope outside allowed, so a
+// credential can only ever be narrowed from the one that created it.
+func ValidateScopes(requested []string, allowed []string) error {
+	known := roleScopes[models.UserRoleSuperAdmin]
+	for _, scope := range requested {
+		if !slices.Contains(known, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+		if !slices.Contains(allowed, scope) {
+			return fmt.Errorf("scope %q not allowed", scope)
+		}
+	}
+	return nil
+}
+
+// EffectiveScopes narrows a credential's stored scopes to what the owner's
+// role currently grants, so a demotion takes effect on existing tokens and
+// keys. No stored scopes means the credential is unrestricted: sessions
+// opened without requesting scopes, and API keys created before keys stored
+// explicit scopes.
+func EffectiveScopes(stored []string, role models.UserRole) []string {
+	granted := RoleScopes(role)
+	if len(stored) == 0 {
+		return granted
+	}
+	effective := make([]string, 0, len(stored))
+	for _, scope := range granted {
+		if slices.Contains(stored, scope) {
+			effective = append(effective, scope)
+		}
+	}
+	return effective
+}
+
+func (c AccessClaims) HasScopes(scopes ...string) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(c.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+var ErrSealedSecret = errors.New("cannot open sealed secret")
+
+// SealSecret encrypts small secrets (such as TOTP seeds) for storage with
+// AES-256-GCM under a key derived from the configured passphrase. The nonce
+// is prepended to the ciphertext.
+func SealSecret(passphrase string, plaintext []byte) ([]byte, error) {
+	aead, err := secretAEAD(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("seal secret: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func OpenSecret(passphrase string, sealed []byte) ([]byte, error) {
+	aead, err := secretAEAD(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrSealedSecret
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrSealedSecret
+	}
+	return plaintext, nil
+}
+
+func secretAEAD(passphrase string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(passphrase))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -1,10 +1,13 @@
 package security
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -16,10 +19,12 @@ type AccessClaims struct {
 	DeviceID string   `json:"did"`
 	Role     string   `json:"role"`
 	Scopes   []string `json:"scopes"`
+	// MFA is set when the session behind the token passed a second factor.
+	MFA bool `json:"mfa,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateAccessToken(secret string, userID string, sessionID string, deviceID string, role string, scopes []string, ttl time.Duration) (string, error) {
+func GenerateAccessToken(keys *KeySet, userID string, sessionID string, deviceID string, role string, scopes []string, mfa bool, ttl time.Duration) (string, error) {
 	now := time.Now()
 	claims := AccessClaims{
 		UserID:   userID,
@@ -27,6 +32,7 @@ func GenerateAccessToken(secret string, userID string, sessionID string, deviceI
 		DeviceID: deviceID,
 		Role:     role,
 		Scopes:   scopes,
+		MFA:      mfa,
 		RegisteredClaims: jwt.RegisteredClaims{
 			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
@@ -35,21 +41,15 @@ func GenerateAccessToken(secret string, userID string, sessionID string, deviceI
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
-	signed, err := token.SignedString([]byte(secret))
+	signed, err := keys.Sign(claims)
 	if err != nil {
 		return "", fmt.Errorf("sign jwt: %w", err)
 	}
 	return signed, nil
 }
 
-func ParseAccessToken(tokenStr string, secret string) (*AccessClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &AccessClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(secret), nil
-	})
+func ParseAccessToken(tokenStr string, keys *KeySet) (*AccessClaims, error) {
+	token, err := keys.Parse(tokenStr, &AccessClaims{})
 	if err != nil {
 		return nil, err
 	}
@@ -59,18 +59,48 @@ func ParseAccessToken(tokenStr string, secret string) (*AccessClaims, error) {
 	return nil, fmt.Errorf("invalid token")
 }
 
-func GenerateRefreshToken(length int) (string, []byte, error) {
-	if length <= 0 {
-		length = 64
-	}
-	buf := make([]byte, length)
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// GenerateRefreshToken returns "<sessionID>.<random>.<mac>", where mac is an
+// HMAC over the first two parts keyed by the refresh secret. The token names
+// its own session, so refreshing needs nothing else from the client, and a
+// forged or mangled token is rejected before touching the database. Only the
+// SHA-256 hash of the whole token is stored.
+func GenerateRefreshToken(secret string, sessionID string) (string, []byte, error) {
+	buf := make([]byte, 32)
 	if _, err := rand.Read(buf); err != nil {
 		return "", nil, fmt.Errorf("generate refresh token: %w", err)
 	}
 
-	token := base64.RawURLEncoding.EncodeToString(buf)
-	hash := HashRefreshToken(token)
-	return token, hash, nil
+	payload := sessionID + "." + base64.RawURLEncoding.EncodeToString(buf)
+	token := payload + "." + base64.RawURLEncoding.EncodeToString(refreshMAC(secret, payload))
+	return token, HashRefreshToken(token), nil
+}
+
+// ParseRefreshToken checks the MAC and returns the session ID the token was
+// issued for.
+func ParseRefreshToken(token string, secret string) (string, error) {
+	idx := strings.LastIndexByte(token, '.')
+	if idx < 0 {
+		return "", ErrInvalidRefreshToken
+	}
+	payload := token[:idx]
+	mac, err := base64.RawURLEncoding.DecodeString(token[idx+1:])
+	if err != nil || !hmac.Equal(mac, refreshMAC(secret, payload)) {
+		return "", ErrInvalidRefreshToken
+	}
+
+	sessionID, _, ok := strings.Cut(payload, ".")
+	if !ok || sessionID == "" {
+		return "", ErrInvalidRefreshToken
+	}
+	return sessionID, nil
+}
+
+func refreshMAC(secret string, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
 }
 
 func HashRefreshToken(token string) []byte {
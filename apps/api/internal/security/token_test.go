@@ -0,0 +1,67 @@
+package security
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseRefreshToken(t *testing.T) {
+	const secret = "refresh-secret"
+	token, hash, err := GenerateRefreshToken(secret, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(hash, HashRefreshToken(token)) {
+		t.Fatal("returned hash does not match HashRefreshToken")
+	}
+	other, _, err := GenerateRefreshToken(secret, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == token {
+		t.Fatal("two refresh tokens for one session are identical")
+	}
+
+	parts := strings.Split(token, ".")
+	emptySession, _, err := GenerateRefreshToken(secret, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		secret  string
+		want    string
+		wantErr bool
+	}{
+		{name: "valid", token: token, secret: secret, want: "session-1"},
+		{name: "wrong secret", token: token, secret: "other-secret", wantErr: true},
+		{name: "session swapped", token: "session-2." + parts[1] + "." + parts[2], secret: secret, wantErr: true},
+		{name: "random part changed", token: parts[0] + "." + parts[1] + "x." + parts[2], secret: secret, wantErr: true},
+		{name: "mac truncated", token: token[:len(token)-2], secret: secret, wantErr: true},
+		{name: "mac not base64", token: parts[0] + "." + parts[1] + ".!!!", secret: secret, wantErr: true},
+		{name: "no separator", token: "garbage", secret: secret, wantErr: true},
+		{name: "empty", token: "", secret: secret, wantErr: true},
+		{name: "empty session", token: emptySession, secret: secret, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRefreshToken(tt.token, tt.secret)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidRefreshToken) {
+					t.Fatalf("err = %v, want ErrInvalidRefreshToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("session = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package security
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the RFC 6238 SHA-1 test key "12345678901234567890" in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTP(t *testing.T) {
+	// Codes are the last six digits of the RFC 6238 appendix B values.
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		now      int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "rfc 59", secret: rfcSecret, code: "287082", now: 59, wantStep: 1, wantOK: true},
+		{name: "rfc 1111111109", secret: rfcSecret, code: "081804", now: 1111111109, wantStep: 37037036, wantOK: true},
+		{name: "rfc 1111111111", secret: rfcSecret, code: "050471", now: 1111111111, wantStep: 37037037, wantOK: true},
+		{name: "rfc 1234567890", secret: rfcSecret, code: "005924", now: 1234567890, wantStep: 41152263, wantOK: true},
+		{name: "rfc 2000000000", secret: rfcSecret, code: "279037", now: 2000000000, wantStep: 66666666, wantOK: true},
+		{name: "lowercase secret", secret: "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code: "005924", now: 1234567890, wantStep: 41152263, wantOK: true},
+		{name: "one step late", secret: rfcSecret, code: "005924", now: 1234567890 + 30, wantStep: 41152263, wantOK: true},
+		{name: "one step early", secret: rfcSecret, code: "005924", now: 1234567890 - 30, wantStep: 41152263, wantOK: true},
+		{name: "two steps late", secret: rfcSecret, code: "005924", now: 1234567890 + 60},
+		{name: "two steps early", secret: rfcSecret, code: "005924", now: 1234567890 - 60},
+		{name: "wrong code", secret: rfcSecret, code: "005925", now: 1234567890},
+		{name: "eight digits", secret: rfcSecret, code: "89005924", now: 1234567890},
+		{name: "short code", secret: rfcSecret, code: "05924", now: 1234567890},
+		{name: "bad secret", secret: "not base32!", code: "005924", now: 1234567890},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(tt.secret, tt.code, time.Unix(tt.now, 0))
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Fatalf("ValidateTOTP = (%d, %v), want (%d, %v)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecretRoundTrip(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("secret %q decodes to %d bytes, err %v", secret, len(key), err)
+	}
+	now := time.Unix(1700000000, 0)
+	code := totpCode(key, now.Unix()/totpPeriod)
+	if step, ok := ValidateTOTP(secret, code, now); !ok || step != now.Unix()/totpPeriod {
+		t.Fatalf("generated code %s rejected", code)
+	}
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"nodeimage/api/internal/config"
+	"nodeimage/api/internal/ids"
+	"nodeimage/api/internal/models"
+	"nodeimage/api/internal/repository"
+	"nodeimage/api/internal/security"
+)
+
+var (
+	ErrAPIKeyLimit   = errors.New("api key limit reached")
+	ErrAPIKeyInvalid = errors.New("invalid api key")
+)
+
+type APIKeyService struct {
+	keys  *repository.APIKeyRepository
+	users *repository.UserRepository
+	cfg   *config.AppConfig
+	log   zerolog.Logger
+}
+
+func NewAPIKeyService(
+	keys *repository.APIKeyRepository,
+	users *repository.UserRepository,
+	cfg *config.AppConfig,
+	log zerolog.Logger,
+) *APIKeyService {
+	return &APIKeyService{
+		keys:  keys,
+		users: users,
+		cfg:   cfg,
+		log:   log,
+	}
+}
+
+type CreateAPIKeyInput struct {
+	UserID string
+	Name   string
+	Scopes []string
+	// Allowed holds the scopes of the credential creating the key; the key
+	// gets all of them when Scopes is empty and can never exceed them.
+	Allowed []string
+}
+
+// CreatedAPIKey carries the plaintext key, which is never stored and can
+// only be returned from the create call.
+type CreatedAPIKey struct {
+	Key    string
+	APIKey models.APIKey
+}
+
+func (s *APIKeyService) Create(ctx context.Context, input CreateAPIKeyInput) (CreatedAPIKey, error) {
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return CreatedAPIKey{}, fmt.Errorf("name required")
+	}
+
+	if s.cfg.Security.MaxAPIKeys > 0 {
+		count, err := s.keys.CountByUser(ctx, input.UserID)
+		if err != nil {
+			return CreatedAPIKey{}, err
+		}
+		if count >= s.cfg.Security.MaxAPIKeys {
+			return CreatedAPIKey{}, ErrAPIKeyLimit
+		}
+	}
+
+	plaintext, hash, prefix, err := security.GenerateAPIKey()
+	if err != nil {
+		return CreatedAPIKey{}, err
+	}
+
+	scopes := input.Scopes
+	if len(scopes) == 0 {
+		scopes = slices.Clone(input.Allowed)
+	}
+	if err := security.ValidateScopes(scopes, input.Allowed); err != nil {
+		return CreatedAPIKey{}, fmt.Errorf("%w: %v", ErrInvalidScope, err)
+	}
+	key := models.APIKey{
+		ID:        ids.New(),
+		UserID:    input.UserID,
+		Name:      name,
+		KeyHash:   hash,
+		KeyPrefix: prefix,
+		Scopes:    scopes,
+	}
+	if err := s.keys.Create(ctx, key); err != nil {
+		return CreatedAPIKey{}, err
+	}
+
+	s.log.Info().Str("user_id", key.UserID).Str("api_key_id", key.ID).Msg("api key created")
+	return CreatedAPIKey{Key: plaintext, APIKey: key}, nil
+}
+
+func (s *APIKeyService) List(ctx context.Context, userID string) ([]models.APIKey, error) {
+	return s.keys.ListByUser(ctx, userID)
+}
+
+func (s *APIKeyService) Revoke(ctx context.Context, userID, id string) error {
+	if err := s.keys.Delete(ctx, userID, id); err != nil {
+		return err
+	}
+	s.log.Info().Str("user_id", userID).Str("api_key_id", id).Msg("api key revoked")
+	return nil
+}
+
+// Authenticate resolves a presented key to the key record and its owner.
+func (s *APIKeyService) Authenticate(ctx context.Context, plaintext string) (models.APIKey, models.User, error) {
+	key, err := s.keys.FindByHash(ctx, security.HashAPIKey(plaintext))
+	if err != nil {
+		if errors.Is(err, repository.ErrAPIKeyNotFound) {
+			return models.APIKey{}, models.User{}, ErrAPIKeyInvalid
+		}
+		return models.APIKey{}, models.User{}, err
+	}
+
+	user, err := s.users.GetByID(ctx, key.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return models.APIKey{}, models.User{}, ErrAPIKeyInvalid
+		}
+		return models.APIKey{}, models.User{}, err
+	}
+	return key, user, nil
+}
+
+// TouchLastUsed records key usage off the request path. Updates are skipped
+// while the stored timestamp is under a minute old to spare the table from a
+// write per request.
+func (s *APIKeyService) TouchLastUsed(key models.APIKey) {
+	if key.LastUsedAt != nil && time.Since(*key.LastUsedAt) < time.Minute {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.keys.TouchLastUsed(ctx, key.ID); err != nil {
+			s.log.Warn().Err(err).Str("api_key_id", key.ID).Msg("update api key last_used_at failed")
+		}
+	}()
+}
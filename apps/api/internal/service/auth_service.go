@@ -2,11 +2,14 @@ package service
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/go-webauthn/webauthn/protocol"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
 
@@ -20,29 +23,50 @@ import (
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrUserSuspended      = errors.New("user suspended")
+	ErrInvalidScope       = errors.New("invalid scope")
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	ErrEmailNotVerified   = errors.New("email not verified")
 )
 
 type AuthService struct {
-	users    *repository.UserRepository
-	sessions *repository.SessionRepository
-	cache    *redis.Client
-	cfg      *config.AppConfig
-	log      zerolog.Logger
+	users        *repository.UserRepository
+	sessions     *repository.SessionRepository
+	verification *VerificationService
+	mfa          *MFAService
+	passkeys     *WebAuthnService
+	oidc         *OIDCService
+	throttle     *LoginThrottle
+	keys         *security.KeySet
+	cache        *redis.Client
+	cfg          *config.AppConfig
+	log          zerolog.Logger
 }
 
 func NewAuthService(
 	users *repository.UserRepository,
 	sessions *repository.SessionRepository,
+	verification *VerificationService,
+	mfa *MFAService,
+	passkeys *WebAuthnService,
+	oidc *OIDCService,
+	throttle *LoginThrottle,
+	keys *security.KeySet,
 	cache *redis.Client,
 	cfg *config.AppConfig,
 	log zerolog.Logger,
 ) *AuthService {
 	return &AuthService{
-		users:    users,
-		sessions: sessions,
-		cache:    cache,
-		cfg:      cfg,
-		log:      log,
+		users:        users,
+		sessions:     sessions,
+		verification: verification,
+		mfa:          mfa,
+		passkeys:     passkeys,
+		oidc:         oidc,
+		throttle:     throttle,
+		keys:         keys,
+		cache:        cache,
+		cfg:          cfg,
+		log:          log,
 	}
 }
 
@@ -57,6 +81,13 @@ type AuthResult struct {
 	RefreshToken string
 	User         models.User
 	DeviceID     string
+	// PendingVerification is set instead of tokens when the new account
+	// must verify its email before logging in.
+	PendingVerification bool
+	// MFARequired is set instead of tokens when the password was right but
+	// a second factor is needed; MFAToken identifies the challenge.
+	MFARequired bool
+	MFAToken    string
 }
 
 func (s *AuthService) Register(ctx context.Context, input RegisterInput) (AuthResult, error) {
@@ -77,25 +108,38 @@ func (s *AuthService) Register(ctx context.Context, input RegisterInput) (AuthRe
 	}
 
 	user := models.User{
-		ID:           ids.New(),
-		Email:        input.Email,
-		PasswordHash: passwordHash,
-		DisplayName:  input.DisplayName,
-		Role:         models.UserRoleUser,
-		Status:       models.UserStatusActive,
+		ID:            ids.New(),
+		Email:         input.Email,
+		PasswordHash:  passwordHash,
+		DisplayName:   input.DisplayName,
+		Role:          models.UserRoleUser,
+		Status:        models.UserStatusActive,
+		StripMetadata: true,
+	}
+	if s.cfg.Security.EmailVerification.Required {
+		user.Status = models.UserStatusPending
 	}
 
 	if err := s.users.Create(ctx, user); err != nil {
 		return AuthResult{}, err
 	}
 
-	deviceID := ids.New()
-	session, tokens, err := s.createSession(ctx, user, deviceID, "New Device", "", "")
+	if user.Status == models.UserStatusPending {
+		// The account exists either way; a failed send can be retried
+		// through the resend endpoint.
+		if err := s.verification.Send(ctx, user); err != nil {
+			s.log.Error().Err(err).Str("user_id", user.ID).Msg("send verification email failed")
+		}
+		return AuthResult{User: user, PendingVerification: true}, nil
+	}
+
+	_, tokens, err := s.createSession(ctx, user, models.Session{
+		DeviceID:   ids.New(),
+		DeviceName: "New Device",
+	})
 	if err != nil {
 		return AuthResult{}, err
 	}
-	_ = session
-
 	return tokens, nil
 }
 
@@ -106,25 +150,248 @@ type LoginInput struct {
 	DeviceName string
 	IPAddress  string
 	UserAgent  string
+	// Scopes optionally restricts the session to part of what the user's
+	// role grants. Empty means unrestricted.
+	Scopes []string
+	// CaptchaToken is required once LoginThrottle asks for it.
+	CaptchaToken string
 }
 
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := security.HashPassword("nodeimage-dummy-password")
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
 func (s *AuthService) Login(ctx context.Context, input LoginInput) (AuthResult, error) {
 	input.Email = strings.TrimSpace(strings.ToLower(input.Email))
+	attempt, err := s.throttle.Reserve(ctx, input.Email, input.IPAddress, input.CaptchaToken)
+	if err != nil {
+		return AuthResult{}, err
+	}
+
 	user, err := s.users.FindByEmail(ctx, input.Email)
+	if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		return AuthResult{}, err
+	}
+	found := err == nil
+
+	// Unknown emails still pay for a hash so timing does not reveal them.
+	passwordHash := dummyPasswordHash()
+	if found {
+		passwordHash = user.PasswordHash
+	}
+	ok, err := security.VerifyPassword(input.Password, passwordHash)
+	if err != nil || !ok || !found {
+		locked, failErr := s.throttle.Fail(ctx, attempt)
+		if failErr != nil {
+			s.log.Warn().Err(failErr).Msg("record login failure failed")
+		}
+		if locked && found {
+			s.throttle.NotifyLockout(ctx, user)
+		}
+		return AuthResult{}, ErrInvalidCredentials
+	}
+	s.throttle.Succeed(ctx, attempt)
+
+	// Checked after the password so the response does not reveal that an
+	// address has a pending account.
+	if user.Status == models.UserStatusPending {
+		return AuthResult{}, ErrEmailNotVerified
+	}
+	if user.Status != models.UserStatusActive {
+		return AuthResult{}, ErrUserSuspended
+	}
+
+	if err := security.ValidateScopes(input.Scopes, security.RoleScopes(user.Role)); err != nil {
+		return AuthResult{}, fmt.Errorf("%w: %v", ErrInvalidScope, err)
+	}
+
+	deviceID := input.DeviceID
+	if deviceID == "" {
+		deviceID = ids.New()
+	}
+	deviceName := input.DeviceName
+	if deviceName == "" {
+		deviceName = "Unknown Device"
+	}
+
+	session := models.Session{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Scopes:     input.Scopes,
+		IPAddress:  input.IPAddress,
+		UserAgent:  input.UserAgent,
+	}
+
+	return s.beginSession(ctx, user, session)
+}
+
+// beginSession creates the session right away, or parks it behind an MFA
+// challenge when the user has a second factor enrolled.
+func (s *AuthService) beginSession(ctx context.Context, user models.User, session models.Session) (AuthResult, error) {
+	enabled, err := s.mfa.Enabled(ctx, user.ID)
 	if err != nil {
-		if errors.Is(err, repository.ErrUserNotFound) {
-			return AuthResult{}, ErrInvalidCredentials
+		return AuthResult{}, err
+	}
+	if enabled {
+		token, err := s.mfa.createChallenge(ctx, pendingLogin{
+			UserID:     user.ID,
+			DeviceID:   session.DeviceID,
+			DeviceName: session.DeviceName,
+			IPAddress:  session.IPAddress,
+			UserAgent:  session.UserAgent,
+			Scopes:     session.Scopes,
+		})
+		if err != nil {
+			return AuthResult{}, err
 		}
+		return AuthResult{User: user, MFARequired: true, MFAToken: token}, nil
+	}
+
+	_, tokens, err := s.createSession(ctx, user, session)
+	if err != nil {
 		return AuthResult{}, err
 	}
+	return tokens, nil
+}
+
+type LoginMFAInput struct {
+	MFAToken string
+	Code     string
+}
 
+// LoginMFA completes a login that Login answered with MFARequired. The code
+// may be a TOTP code or a recovery code.
+func (s *AuthService) LoginMFA(ctx context.Context, input LoginMFAInput) (AuthResult, error) {
+	pending, err := s.mfa.completeChallenge(ctx, input.MFAToken, func(userID string) error {
+		return s.mfa.Verify(ctx, userID, input.Code)
+	})
+	if err != nil {
+		return AuthResult{}, err
+	}
+	return s.finishMFALogin(ctx, pending)
+}
+
+// BeginMFAPasskey returns assertion options for answering an MFA challenge
+// with one of the user's passkeys instead of a code.
+func (s *AuthService) BeginMFAPasskey(ctx context.Context, mfaToken string) (*protocol.CredentialAssertion, error) {
+	pending, err := s.mfa.peekChallenge(ctx, mfaToken)
+	if err != nil {
+		return nil, err
+	}
+	return s.passkeys.BeginLogin(ctx, pending.UserID)
+}
+
+type LoginMFAPasskeyInput struct {
+	MFAToken   string
+	Credential []byte
+}
+
+func (s *AuthService) LoginMFAPasskey(ctx context.Context, input LoginMFAPasskeyInput) (AuthResult, error) {
+	pending, err := s.mfa.completeChallenge(ctx, input.MFAToken, func(userID string) error {
+		_, err := s.passkeys.FinishLogin(ctx, userID, input.Credential)
+		return err
+	})
+	if err != nil {
+		return AuthResult{}, err
+	}
+	return s.finishMFALogin(ctx, pending)
+}
+
+func (s *AuthService) finishMFALogin(ctx context.Context, pending pendingLogin) (AuthResult, error) {
+	user, err := s.users.GetByID(ctx, pending.UserID)
+	if err != nil {
+		return AuthResult{}, err
+	}
 	if user.Status != models.UserStatusActive {
 		return AuthResult{}, ErrUserSuspended
 	}
 
-	ok, err := security.VerifyPassword(input.Password, user.PasswordHash)
-	if err != nil || !ok {
-		return AuthResult{}, ErrInvalidCredentials
+	_, tokens, err := s.createSession(ctx, user, models.Session{
+		DeviceID:   pending.DeviceID,
+		DeviceName: pending.DeviceName,
+		Scopes:     pending.Scopes,
+		MFA:        true,
+		IPAddress:  pending.IPAddress,
+		UserAgent:  pending.UserAgent,
+	})
+	if err != nil {
+		return AuthResult{}, err
+	}
+	return tokens, nil
+}
+
+type OIDCLoginInput struct {
+	Code       string
+	State      string
+	DeviceID   string
+	DeviceName string
+	IPAddress  string
+	UserAgent  string
+	Scopes     []string
+}
+
+// LoginOIDC finishes an OIDC authorization-code login. It is treated like a
+// password login, so users with MFA enrolled still get a challenge.
+func (s *AuthService) LoginOIDC(ctx context.Context, input OIDCLoginInput) (AuthResult, error) {
+	user, err := s.oidc.Resolve(ctx, input.Code, input.State)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	if user.Status != models.UserStatusActive {
+		return AuthResult{}, ErrUserSuspended
+	}
+
+	if err := security.ValidateScopes(input.Scopes, security.RoleScopes(user.Role)); err != nil {
+		return AuthResult{}, fmt.Errorf("%w: %v", ErrInvalidScope, err)
+	}
+
+	deviceID := input.DeviceID
+	if deviceID == "" {
+		deviceID = ids.New()
+	}
+	deviceName := input.DeviceName
+	if deviceName == "" {
+		deviceName = "Unknown Device"
+	}
+
+	return s.beginSession(ctx, user, models.Session{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Scopes:     input.Scopes,
+		IPAddress:  input.IPAddress,
+		UserAgent:  input.UserAgent,
+	})
+}
+
+type PasskeyLoginInput struct {
+	Credential []byte
+	DeviceID   string
+	DeviceName string
+	IPAddress  string
+	UserAgent  string
+	Scopes     []string
+}
+
+// LoginPasskey signs in with a discoverable passkey alone. The ceremony
+// requires user verification, so the session counts as multi-factor.
+func (s *AuthService) LoginPasskey(ctx context.Context, input PasskeyLoginInput) (AuthResult, error) {
+	user, err := s.passkeys.FinishLogin(ctx, "", input.Credential)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	if user.Status == models.UserStatusPending {
+		return AuthResult{}, ErrEmailNotVerified
+	}
+	if user.Status != models.UserStatusActive {
+		return AuthResult{}, ErrUserSuspended
+	}
+
+	if err := security.ValidateScopes(input.Scopes, security.RoleScopes(user.Role)); err != nil {
+		return AuthResult{}, fmt.Errorf("%w: %v", ErrInvalidScope, err)
 	}
 
 	deviceID := input.DeviceID
@@ -136,44 +403,42 @@ func (s *AuthService) Login(ctx context.Context, input LoginInput) (AuthResult,
 		deviceName = "Unknown Device"
 	}
 
-	_, tokens, err := s.createSession(ctx, user, deviceID, deviceName, input.IPAddress, input.UserAgent)
+	_, tokens, err := s.createSession(ctx, user, models.Session{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Scopes:     input.Scopes,
+		MFA:        true,
+		IPAddress:  input.IPAddress,
+		UserAgent:  input.UserAgent,
+	})
 	if err != nil {
 		return AuthResult{}, err
 	}
 	return tokens, nil
 }
 
-func (s *AuthService) createSession(
-	ctx context.Context,
-	user models.User,
-	deviceID string,
-	deviceName string,
-	ipAddress string,
-	userAgent string,
-) (models.Session, AuthResult, error) {
-	refreshToken, refreshHash, err := security.GenerateRefreshToken(64)
+// createSession issues tokens for a new session. The caller fills in the
+// device, client and any scope or MFA details; IDs, hashes and expiry are
+// set here.
+func (s *AuthService) createSession(ctx context.Context, user models.User, session models.Session) (models.Session, AuthResult, error) {
+	session.ID = ids.New()
+	session.UserID = user.ID
+	session.ExpiresAt = time.Now().Add(s.cfg.Security.JWTRefreshTTL)
+
+	refreshToken, refreshHash, err := security.GenerateRefreshToken(s.cfg.Security.JWTRefreshSecret, session.ID)
 	if err != nil {
 		return models.Session{}, AuthResult{}, err
 	}
-
-	session := models.Session{
-		ID:               ids.New(),
-		UserID:           user.ID,
-		DeviceID:         deviceID,
-		DeviceName:       deviceName,
-		RefreshTokenHash: refreshHash,
-		IPAddress:        ipAddress,
-		UserAgent:        userAgent,
-		ExpiresAt:        time.Now().Add(s.cfg.Security.JWTRefreshTTL),
-	}
+	session.RefreshTokenHash = refreshHash
 
 	accessToken, err := security.GenerateAccessToken(
-		s.cfg.Security.JWTAccessSecret,
+		s.keys,
 		user.ID,
 		session.ID,
-		deviceID,
+		session.DeviceID,
 		string(user.Role),
-		nil,
+		security.EffectiveScopes(session.Scopes, user.Role),
+		session.MFA,
 		s.cfg.Security.JWTAccessTTL,
 	)
 	if err != nil {
@@ -192,7 +457,7 @@ func (s *AuthService) createSession(
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 		User:         user,
-		DeviceID:     deviceID,
+		DeviceID:     session.DeviceID,
 	}, nil
 }
 
@@ -209,36 +474,43 @@ func (s *AuthService) enforceSessionLimit(ctx context.Context, userID string) er
 }
 
 type RefreshInput struct {
-	UserID       string
 	RefreshToken string
-	DeviceID     string
+	IPAddress    string
+	UserAgent    string
 }
 
 func (s *AuthService) Refresh(ctx context.Context, input RefreshInput) (AuthResult, error) {
-	user, err := s.users.GetByID(ctx, input.UserID)
+	sessionID, err := security.ParseRefreshToken(input.RefreshToken, s.cfg.Security.JWTRefreshSecret)
 	if err != nil {
-		return AuthResult{}, err
-	}
-	if user.Status != models.UserStatusActive {
-		return AuthResult{}, ErrUserSuspended
+		return AuthResult{}, ErrInvalidCredentials
 	}
 
 	refreshHash := security.HashRefreshToken(input.RefreshToken)
-	session, err := s.sessions.FindByRefreshHash(ctx, input.UserID, refreshHash)
+	session, err := s.sessions.GetByID(ctx, sessionID)
 	if err != nil {
-		return AuthResult{}, ErrInvalidCredentials
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			return AuthResult{}, s.checkRefreshReuse(ctx, input, sessionID, refreshHash)
+		}
+		return AuthResult{}, err
+	}
+	if subtle.ConstantTimeCompare(session.RefreshTokenHash, refreshHash) != 1 {
+		return AuthResult{}, s.checkRefreshReuse(ctx, input, sessionID, refreshHash)
 	}
 
-	if session.DeviceID != input.DeviceID {
-		return AuthResult{}, ErrInvalidCredentials
+	user, err := s.users.GetByID(ctx, session.UserID)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	if user.Status != models.UserStatusActive {
+		return AuthResult{}, ErrUserSuspended
 	}
 
 	if session.ExpiresAt.Before(time.Now()) {
 		_ = s.sessions.DeleteByID(ctx, session.ID)
 		return AuthResult{}, ErrInvalidCredentials
-}
+	}
 
-	refreshToken, newHash, err := security.GenerateRefreshToken(64)
+	refreshToken, newHash, err := security.GenerateRefreshToken(s.cfg.Security.JWTRefreshSecret, session.ID)
 	if err != nil {
 		return AuthResult{}, err
 	}
@@ -246,17 +518,23 @@ func (s *AuthService) Refresh(ctx context.Context, input RefreshInput) (AuthResu
 	session.RefreshTokenHash = newHash
 	session.ExpiresAt = time.Now().Add(s.cfg.Security.JWTRefreshTTL)
 
-	if err := s.sessions.Create(ctx, session); err != nil {
+	if err := s.sessions.RotateRefreshHash(ctx, session, refreshHash); err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			// A concurrent refresh rotated this token first; it is now
+			// history, so treat this attempt as reuse.
+			return AuthResult{}, s.checkRefreshReuse(ctx, input, session.ID, refreshHash)
+		}
 		return AuthResult{}, err
 	}
 
 	accessToken, err := security.GenerateAccessToken(
-		s.cfg.Security.JWTAccessSecret,
+		s.keys,
 		user.ID,
 		session.ID,
 		session.DeviceID,
 		string(user.Role),
-		nil,
+		security.EffectiveScopes(session.Scopes, user.Role),
+		session.MFA,
 		s.cfg.Security.JWTAccessTTL,
 	)
 	if err != nil {
@@ -271,6 +549,57 @@ func (s *AuthService) Refresh(ctx context.Context, input RefreshInput) (AuthResu
 	}, nil
 }
 
-func (s *AuthService) Logout(ctx context.Context, userID string, deviceID string) error {
-	return s.sessions.DeleteByDevice(ctx, userID, deviceID)
+// checkRefreshReuse handles a refresh token that matches no live session. If
+// it is one the session already rotated away from, either the client or an
+// attacker is holding a copy, and there is no telling which; the whole
+// session is revoked so both have to log in again.
+func (s *AuthService) checkRefreshReuse(ctx context.Context, input RefreshInput, sessionID string, refreshHash []byte) error {
+	rotated, err := s.sessions.FindRotatedRefreshHash(ctx, sessionID, refreshHash)
+	if err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+
+	if err := s.sessions.DeleteByID(ctx, rotated.SessionID); err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+		return err
+	}
+
+	s.log.Warn().
+		Str("event", "refresh_token_reuse").
+		Str("user_id", rotated.UserID).
+		Str("session_id", rotated.SessionID).
+		Str("device_id", rotated.DeviceID).
+		Time("rotated_at", rotated.RotatedAt).
+		Str("ip", input.IPAddress).
+		Str("user_agent", input.UserAgent).
+		Msg("rotated refresh token presented again; session revoked")
+
+	return ErrRefreshTokenReused
+}
+
+// LogoutToken ends the session a refresh token belongs to. A forged or
+// malformed token fails with security.ErrInvalidRefreshToken; a genuine one
+// whose session is stale or already logged out is not an error, so logging
+// out twice succeeds.
+func (s *AuthService) LogoutToken(ctx context.Context, refreshToken string) error {
+	sessionID, err := security.ParseRefreshToken(refreshToken, s.cfg.Security.JWTRefreshSecret)
+	if err != nil {
+		return err
+	}
+	session, err := s.sessions.GetByID(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			return nil
+		}
+		return err
+	}
+	if subtle.ConstantTimeCompare(session.RefreshTokenHash, security.HashRefreshToken(refreshToken)) != 1 {
+		return nil
+	}
+	if err := s.sessions.DeleteByID(ctx, session.ID); err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+
+	"nodeimage/api/internal/captcha"
+	"nodeimage/api/internal/config"
+	"nodeimage/api/internal/mailer"
+	"nodeimage/api/internal/models"
+)
+
+var (
+	ErrTooManyAttempts = errors.New("too many attempts")
+	ErrCaptchaRequired = errors.New("captcha required")
+	ErrInvalidCaptcha  = errors.New("invalid captcha")
+)
+
+// ThrottledError is returned while an email or IP must wait before the next
+// attempt. It matches ErrTooManyAttempts.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string { return ErrTooManyAttempts.Error() }
+
+func (e *ThrottledError) Is(target error) bool { return target == ErrTooManyAttempts }
+
+// LoginThrottle tracks failed logins in Redis. Counters are keyed by the
+// submitted email whether or not an account exists, so throttling, CAPTCHA
+// and lockout responses look the same for unknown addresses.
+type LoginThrottle struct {
+	cache   *redis.Client
+	captcha captcha.Verifier
+	mailer  mailer.Mailer
+	cfg     *config.AppConfig
+	log     zerolog.Logger
+}
+
+func NewLoginThrottle(
+	cache *redis.Client,
+	verifier captcha.Verifier,
+	mail mailer.Mailer,
+	cfg *config.AppConfig,
+	log zerolog.Logger,
+) *LoginThrottle {
+	return &LoginThrottle{
+		cache:   cache,
+		captcha: verifier,
+		mailer:  mail,
+		cfg:     cfg,
+		log:     log,
+	}
+}
+
+// Attempt is a login attempt Reserve has already counted as a failure.
+type Attempt struct {
+	email         string
+	ip            string
+	emailFailures int64
+	ipFailures    int64
+}
+
+// reserveScript checks the lock and wait keys and, if none is running,
+// counts the attempt as a failure and starts the wait the next attempt must
+// honour. Doing both in one script means parallel requests cannot all pass
+// the check before any of them is counted. It returns {waitMillis, 0, 0}
+// when rejecting and {0, emailFailures, ipFailures} otherwise. The delay
+// doubles from BaseDelay for each failure past the free attempts, capped at
+// MaxDelay.
+var reserveScript = redis.NewScript(`
+local wait = 0
+for i = 1, 3 do
+	local ttl = redis.call('PTTL', KEYS[i])
+	if ttl > wait then wait = ttl end
+end
+if wait > 0 then
+	return {wait, 0, 0}
+end
+
+local function count(key)
+	local n = redis.call('INCR', key)
+	if n == 1 then
+		redis.call('PEXPIRE', key, ARGV[1])
+	end
+	return n
+end
+
+local base, max = tonumber(ARGV[4]), tonumber(ARGV[5])
+local function delay(n, free)
+	local over = n - free
+	if over <= 0 or base <= 0 then
+		return 0
+	end
+	local d = base
+	for _ = 2, over do
+		if max > 0 and d >= max then break end
+		d = d * 2
+	end
+	if max > 0 and d > max then d = max end
+	return d
+end
+
+local byEmail = count(KEYS[4])
+local byIP = count(KEYS[5])
+local d = delay(byEmail, tonumber(ARGV[2]))
+if d > 0 then
+	redis.call('SET', KEYS[2], '1', 'PX', d)
+end
+d = delay(byIP, tonumber(ARGV[3]))
+if d > 0 then
+	redis.call('SET', KEYS[3], '1', 'PX', d)
+end
+return {0, byEmail, byIP}
+`)
+
+// refundScript takes back an IP reservation without recreating a counter
+// that has expired meanwhile. The wait is lifted if this attempt alone
+// pushed the IP past its free attempts.
+var refundScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+local n = redis.call('DECR', KEYS[1])
+if n <= tonumber(ARGV[1]) then
+	redis.call('DEL', KEYS[2])
+end
+return n
+`)
+
+// Reserve runs before the password is verified. It rejects locked or
+// waiting emails and IPs, demands a valid CAPTCHA once failures pile up,
+// and then atomically counts the attempt as a failure. Callers settle it
+// with Fail or Succeed.
+func (t *LoginThrottle) Reserve(ctx context.Context, email string, ip string, captchaToken string) (Attempt, error) {
+	if err := t.precheck(ctx, email, ip, captchaToken); err != nil {
+		return Attempt{}, err
+	}
+
+	settings := t.cfg.Security.LoginThrottle
+	keys := []string{
+		"login:lock:" + email,
+		"login:wait:email:" + email,
+		"login:wait:ip:" + ip,
+		"login:fail:email:" + email,
+		"login:fail:ip:" + ip,
+	}
+	result, err := reserveScript.Run(ctx, t.cache, keys,
+		settings.Window.Milliseconds(),
+		settings.FreeAttempts,
+		settings.IPFreeAttempts,
+		settings.BaseDelay.Milliseconds(),
+		settings.MaxDelay.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		return Attempt{}, err
+	}
+	if len(result) != 3 {
+		return Attempt{}, fmt.Errorf("login reserve: unexpected reply %v", result)
+	}
+	if result[0] > 0 {
+		return Attempt{}, &ThrottledError{RetryAfter: time.Duration(result[0]) * time.Millisecond}
+	}
+	return Attempt{email: email, ip: ip, emailFailures: result[1], ipFailures: result[2]}, nil
+}
+
+// precheck rejects early, before the CAPTCHA provider is called, and
+// demands a CAPTCHA once failures pile up. Reserve repeats the wait checks
+// atomically.
+func (t *LoginThrottle) precheck(ctx context.Context, email string, ip string, captchaToken string) error {
+	ttls := make([]*redis.DurationCmd, 0, 3)
+	pipe := t.cache.Pipeline()
+	ttls = append(ttls,
+		pipe.PTTL(ctx, "login:lock:"+email),
+		pipe.PTTL(ctx, "login:wait:email:"+email),
+		pipe.PTTL(ctx, "login:wait:ip:"+ip),
+	)
+	emailFailures := pipe.Get(ctx, "login:fail:email:"+email)
+	ipFailures := pipe.Get(ctx, "login:fail:ip:"+ip)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	var wait time.Duration
+	for _, cmd := range ttls {
+		if ttl := cmd.Val(); ttl > wait {
+			wait = ttl
+		}
+	}
+	if wait > 0 {
+		return &ThrottledError{RetryAfter: wait}
+	}
+
+	if t.captcha == nil {
+		return nil
+	}
+	settings := t.cfg.Security.LoginThrottle
+	byEmail, _ := emailFailures.Int()
+	byIP, _ := ipFailures.Int()
+	if byEmail < settings.CaptchaAfter && byIP < settings.IPFreeAttempts {
+		return nil
+	}
+	if captchaToken == "" {
+		return ErrCaptchaRequired
+	}
+	if err := t.captcha.Verify(ctx, captchaToken, ip); err != nil {
+		if errors.Is(err, captcha.ErrInvalidToken) {
+			return ErrInvalidCaptcha
+		}
+		return err
+	}
+	return nil
+}
+
+// Fail settles an attempt whose password was wrong. Reserve already counted
+// it and started the waits; Fail only decides the lockout, and reports
+// whether this failure locked the email.
+func (t *LoginThrottle) Fail(ctx context.Context, attempt Attempt) (bool, error) {
+	settings := t.cfg.Security.LoginThrottle
+	if settings.LockoutThreshold <= 0 || attempt.emailFailures < int64(settings.LockoutThreshold) {
+		return false, nil
+	}
+	locked, err := t.cache.SetNX(ctx, "login:lock:"+attempt.email, "1", settings.LockoutDuration).Result()
+	if err != nil {
+		return false, err
+	}
+	if locked {
+		// Start counting afresh once the lock expires.
+		_ = t.cache.Del(ctx, "login:fail:email:"+attempt.email, "login:wait:email:"+attempt.email).Err()
+		t.log.Warn().Str("event", "login_lockout").Str("email", attempt.email).Str("ip", attempt.ip).Msg("login locked after repeated failures")
+	}
+	return locked, nil
+}
+
+// Succeed clears the email's failures and refunds the IP's reservation.
+// Earlier IP failures are left alone so one valid account cannot be used to
+// reset a spraying IP.
+func (t *LoginThrottle) Succeed(ctx context.Context, attempt Attempt) {
+	if err := t.cache.Del(ctx, "login:fail:email:"+attempt.email, "login:wait:email:"+attempt.email).Err(); err != nil {
+		t.log.Warn().Err(err).Msg("clear login failures failed")
+	}
+	keys := []string{"login:fail:ip:" + attempt.ip, "login:wait:ip:" + attempt.ip}
+	if err := refundScript.Run(ctx, t.cache, keys, t.cfg.Security.LoginThrottle.IPFreeAttempts).Err(); err != nil {
+		t.log.Warn().Err(err).Msg("refund login attempt failed")
+	}
+}
+
+// NotifyLockout tells the account owner their sign-in is locked. It sends in
+// the background so the response time does not depend on the account.
+func (t *LoginThrottle) NotifyLockout(ctx context.Context, user models.User) {
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		msg := mailer.Message{
+			To:      user.Email,
+			Subject: "NodeImage sign-in temporarily locked",
+			Text: fmt.Sprintf(
+				"Hi %s,\n\nThere were too many failed sign-in attempts on your NodeImage account, so password sign-in is locked for %s. Passkey and single sign-on logins still work.\n\nIf this was not you, consider resetting your password.\n",
+				user.DisplayName,
+				t.cfg.Security.LoginThrottle.LockoutDuration,
+			),
+		}
+		if err := t.mailer.Send(ctx, msg); err != nil {
+			t.log.Error().Err(err).Str("user_id", user.ID).Msg("send lockout notice failed")
+		}
+	}()
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+
+	"nodeimage/api/internal/config"
+)
+
+func newTestThrottle(t *testing.T) (*LoginThrottle, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	cfg := &config.AppConfig{}
+	cfg.Security.LoginThrottle = config.LoginThrottleConfig{
+		Window:           15 * time.Minute,
+		FreeAttempts:     3,
+		IPFreeAttempts:   5,
+		BaseDelay:        time.Second,
+		MaxDelay:         4 * time.Second,
+		LockoutThreshold: 6,
+		LockoutDuration:  15 * time.Minute,
+	}
+	cache := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	return NewLoginThrottle(cache, nil, nil, cfg, zerolog.Nop()), mr
+}
+
+func TestLoginThrottleReserveIsAtomic(t *testing.T) {
+	throttle, _ := newTestThrottle(t)
+	ctx := context.Background()
+
+	// Attempts within the free allowance pass; the first one past it starts
+	// a wait, so parallel requests cannot all slip through the check.
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		reserved int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := throttle.Reserve(ctx, "a@example.com", "10.0.0.1", "")
+			if err != nil && !errors.Is(err, ErrTooManyAttempts) {
+				t.Error(err)
+				return
+			}
+			if err == nil {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved != 4 {
+		t.Fatalf("reserved %d attempts, want 4", reserved)
+	}
+}
+
+func TestLoginThrottleDelayDoubles(t *testing.T) {
+	throttle, mr := newTestThrottle(t)
+	ctx := context.Background()
+
+	want := []time.Duration{0, 0, 0, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}
+	for i, delay := range want {
+		if _, err := throttle.Reserve(ctx, "a@example.com", "10.0.0.1", ""); err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+		if got := mr.TTL("login:wait:email:a@example.com"); got != delay {
+			t.Fatalf("attempt %d: wait = %v, want %v", i+1, got, delay)
+		}
+		if delay == 0 {
+			continue
+		}
+		// A rejected attempt is not counted.
+		_, err := throttle.Reserve(ctx, "a@example.com", "10.0.0.2", "")
+		var throttled *ThrottledError
+		if !errors.As(err, &throttled) || throttled.RetryAfter != delay {
+			t.Fatalf("attempt %d: retry err = %v, want wait %v", i+1, err, delay)
+		}
+		mr.FastForward(delay)
+	}
+}
+
+func TestLoginThrottleFailLocks(t *testing.T) {
+	throttle, mr := newTestThrottle(t)
+	ctx := context.Background()
+
+	for i := 1; i <= 6; i++ {
+		attempt, err := throttle.Reserve(ctx, "a@example.com", "10.0.0.1", "")
+		if err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+		locked, err := throttle.Fail(ctx, attempt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked != (i == 6) {
+			t.Fatalf("attempt %d: locked = %v", i, locked)
+		}
+		if !locked {
+			mr.FastForward(time.Minute)
+		}
+	}
+	if mr.Exists("login:fail:email:a@example.com") {
+		t.Fatal("email failures kept after lockout")
+	}
+	_, err := throttle.Reserve(ctx, "a@example.com", "10.0.0.2", "")
+	var throttled *ThrottledError
+	if !errors.As(err, &throttled) || throttled.RetryAfter != 15*time.Minute {
+		t.Fatalf("err = %v, want lockout wait", err)
+	}
+}
+
+func TestLoginThrottleSucceed(t *testing.T) {
+	throttle, mr := newTestThrottle(t)
+	ctx := context.Background()
+
+	for _, email := range []string{"b@example.com", "c@example.com", "d@example.com", "e@example.com", "f@example.com"} {
+		attempt, err := throttle.Reserve(ctx, email, "10.0.0.1", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := throttle.Fail(ctx, attempt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mr.FastForward(time.Minute)
+
+	// The sixth attempt from the IP starts its wait; succeeding refunds the
+	// attempt and lifts the wait it caused, but keeps earlier IP failures.
+	attempt, err := throttle.Reserve(ctx, "a@example.com", "10.0.0.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mr.Exists("login:wait:ip:10.0.0.1") {
+		t.Fatal("ip wait not started")
+	}
+	throttle.Succeed(ctx, attempt)
+	if got, _ := mr.Get("login:fail:ip:10.0.0.1"); got != "5" {
+		t.Fatalf("ip failures = %s, want 5", got)
+	}
+	if mr.Exists("login:wait:ip:10.0.0.1") || mr.Exists("login:fail:email:a@example.com") {
+		t.Fatal("success left waits or email failures behind")
+	}
+
+	// Refunding after the window expired must not leave a negative counter.
+	attempt, err = throttle.Reserve(ctx, "g@example.com", "10.0.0.2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(time.Hour)
+	throttle.Succeed(ctx, attempt)
+	if mr.Exists("login:fail:ip:10.0.0.2") {
+		t.Fatal("refund recreated an expired counter")
+	}
+}
@@ -0,0 +1,370 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+
+	"nodeimage/api/internal/config"
+	"nodeimage/api/internal/models"
+	"nodeimage/api/internal/repository"
+	"nodeimage/api/internal/security"
+)
+
+var (
+	ErrMFAAlreadyEnabled   = errors.New("mfa already enabled")
+	ErrMFANotEnabled       = errors.New("mfa not enabled")
+	ErrInvalidMFACode      = errors.New("invalid mfa code")
+	ErrInvalidMFAChallenge = errors.New("invalid mfa challenge")
+	ErrMFALocked           = errors.New("too many failed mfa attempts")
+)
+
+type MFAService struct {
+	mfa      *repository.MFARepository
+	sessions *repository.SessionRepository
+	cache    *redis.Client
+	cfg      *config.AppConfig
+	log      zerolog.Logger
+}
+
+func NewMFAService(
+	mfa *repository.MFARepository,
+	sessions *repository.SessionRepository,
+	cache *redis.Client,
+	cfg *config.AppConfig,
+	log zerolog.Logger,
+) *MFAService {
+	return &MFAService{
+		mfa:      mfa,
+		sessions: sessions,
+		cache:    cache,
+		cfg:      cfg,
+		log:      log,
+	}
+}
+
+type TOTPSetup struct {
+	Secret string
+	URI    string
+}
+
+type MFAStatus struct {
+	Enabled                bool
+	Required               bool
+	RecoveryCodesRemaining int
+}
+
+// Required reports whether policy demands a second factor for role.
+func (s *MFAService) Required(role models.UserRole) bool {
+	return slices.Contains(s.cfg.Security.MFA.RequiredRoles, string(role))
+}
+
+func (s *MFAService) Status(ctx context.Context, user models.User) (MFAStatus, error) {
+	status := MFAStatus{Required: s.Required(user.Role)}
+	enrollment, err := s.mfa.GetByUser(ctx, user.ID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMFANotFound) {
+			return status, nil
+		}
+		return MFAStatus{}, err
+	}
+	if !enrollment.Enabled() {
+		return status, nil
+	}
+	status.Enabled = true
+	status.RecoveryCodesRemaining, err = s.mfa.CountRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		return MFAStatus{}, err
+	}
+	return status, nil
+}
+
+func (s *MFAService) Enabled(ctx context.Context, userID string) (bool, error) {
+	enrollment, err := s.mfa.GetByUser(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMFANotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return enrollment.Enabled(), nil
+}
+
+// Setup starts enrollment with a fresh secret. It stays inactive until
+// Enable confirms a code from the authenticator app.
+func (s *MFAService) Setup(ctx context.Context, user models.User) (TOTPSetup, error) {
+	if enabled, err := s.Enabled(ctx, user.ID); err != nil {
+		return TOTPSetup{}, err
+	} else if enabled {
+		return TOTPSetup{}, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := security.GenerateTOTPSecret()
+	if err != nil {
+		return TOTPSetup{}, err
+	}
+	sealed, err := security.SealSecret(s.cfg.Security.MFA.SecretKey, []byte(secret))
+	if err != nil {
+		return TOTPSetup{}, err
+	}
+	if err := s.mfa.SavePending(ctx, user.ID, sealed); err != nil {
+		return TOTPSetup{}, err
+	}
+
+	return TOTPSetup{
+		Secret: secret,
+		URI:    security.TOTPProvisioningURI(s.cfg.Security.MFA.Issuer, user.Email, secret),
+	}, nil
+}
+
+// Enable confirms enrollment with a first code and returns recovery codes,
+// which are shown once. The calling session counts as verified from then on.
+func (s *MFAService) Enable(ctx context.Context, user models.User, sessionID string, code string) ([]string, error) {
+	enrollment, err := s.mfa.GetByUser(ctx, user.ID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMFANotFound) {
+			return nil, ErrMFANotEnabled
+		}
+		return nil, err
+	}
+	if enrollment.Enabled() {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step, err := s.matchTOTP(enrollment, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := security.GenerateRecoveryCodes(s.cfg.Security.MFA.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfa.Enable(ctx, user.ID, step, hashes); err != nil {
+		return nil, err
+	}
+	if sessionID != "" {
+		if err := s.sessions.MarkMFA(ctx, sessionID); err != nil {
+			s.log.Warn().Err(err).Str("session_id", sessionID).Msg("mark session mfa failed")
+		}
+	}
+
+	s.log.Info().Str("user_id", user.ID).Msg("totp enabled")
+	return codes, nil
+}
+
+func (s *MFAService) Disable(ctx context.Context, userID string, code string) error {
+	if err := s.Verify(ctx, userID, code); err != nil {
+		return err
+	}
+	if err := s.mfa.Delete(ctx, userID); err != nil {
+		return err
+	}
+	s.log.Info().Str("user_id", userID).Msg("totp disabled")
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes. It takes a TOTP code
+// only, so a leaked recovery code cannot mint more.
+func (s *MFAService) RegenerateRecoveryCodes(ctx context.Context, userID string, code string) ([]string, error) {
+	if err := s.verifyTOTP(ctx, userID, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := security.GenerateRecoveryCodes(s.cfg.Security.MFA.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfa.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify accepts either a current TOTP code or an unused recovery code.
+func (s *MFAService) Verify(ctx context.Context, userID string, code string) error {
+	if isTOTPCode(code) {
+		return s.verifyTOTP(ctx, userID, code)
+	}
+
+	if enabled, err := s.Enabled(ctx, userID); err != nil {
+		return err
+	} else if !enabled {
+		return ErrMFANotEnabled
+	}
+	if err := s.mfa.ConsumeRecoveryCode(ctx, userID, security.HashRecoveryCode(code)); err != nil {
+		if errors.Is(err, repository.ErrRecoveryCodeNotFound) {
+			return ErrInvalidMFACode
+		}
+		return err
+	}
+	s.log.Info().Str("user_id", userID).Msg("mfa recovery code used")
+	return nil
+}
+
+func (s *MFAService) verifyTOTP(ctx context.Context, userID string, code string) error {
+	enrollment, err := s.mfa.GetByUser(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMFANotFound) {
+			return ErrMFANotEnabled
+		}
+		return err
+	}
+	if !enrollment.Enabled() {
+		return ErrMFANotEnabled
+	}
+
+	step, err := s.matchTOTP(enrollment, code)
+	if err != nil {
+		return err
+	}
+	if err := s.mfa.AdvanceStep(ctx, userID, step); err != nil {
+		if errors.Is(err, repository.ErrTOTPStepUsed) {
+			return ErrInvalidMFACode
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *MFAService) matchTOTP(enrollment models.UserMFA, code string) (int64, error) {
+	secret, err := security.OpenSecret(s.cfg.Security.MFA.SecretKey, enrollment.TOTPSecret)
+	if err != nil {
+		return 0, err
+	}
+	step, ok := security.ValidateTOTP(string(secret), code, time.Now())
+	if !ok || step <= enrollment.LastUsedStep {
+		return 0, ErrInvalidMFACode
+	}
+	return step, nil
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// pendingLogin is what a password login that still needs a second factor
+// carries over to the challenge step.
+type pendingLogin struct {
+	UserID     string   `json:"userId"`
+	DeviceID   string   `json:"deviceId"`
+	DeviceName string   `json:"deviceName"`
+	IPAddress  string   `json:"ipAddress"`
+	UserAgent  string   `json:"userAgent"`
+	Scopes     []string `json:"scopes,omitempty"`
+}
+
+// createChallenge parks a pending login in Redis under an opaque token that
+// is only good for ChallengeTTL and MaxAttempts tries.
+func (s *MFAService) createChallenge(ctx context.Context, pending pendingLogin) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate mfa challenge: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	payload, err := json.Marshal(pending)
+	if err != nil {
+		return "", err
+	}
+	if err := s.cache.Set(ctx, challengeKey(token), payload, s.cfg.Security.MFA.ChallengeTTL).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// peekChallenge returns the pending login without using up the challenge.
+func (s *MFAService) peekChallenge(ctx context.Context, token string) (pendingLogin, error) {
+	payload, err := s.cache.Get(ctx, challengeKey(token)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return pendingLogin{}, ErrInvalidMFAChallenge
+		}
+		return pendingLogin{}, err
+	}
+	var pending pendingLogin
+	if err := json.Unmarshal(payload, &pending); err != nil {
+		return pendingLogin{}, ErrInvalidMFAChallenge
+	}
+	return pending, nil
+}
+
+// completeChallenge runs verify for the challenge's user and uses the
+// challenge up when it passes. Failed codes and passkeys count towards
+// MaxAttempts for the challenge and MaxUserFailures for the user, so
+// starting new challenges with the password does not buy more guesses.
+func (s *MFAService) completeChallenge(ctx context.Context, token string, verify func(userID string) error) (pendingLogin, error) {
+	key := challengeKey(token)
+	pending, err := s.peekChallenge(ctx, token)
+	if err != nil {
+		return pendingLogin{}, err
+	}
+
+	// The attempt is charged to the user before verifying, so parallel
+	// guesses cannot all slip in under the limit. It is refunded only if
+	// verification fails for a reason other than a wrong factor.
+	failKey := mfaFailureKey(pending.UserID)
+	failures, err := s.cache.Incr(ctx, failKey).Result()
+	if err != nil {
+		return pendingLogin{}, err
+	}
+	if failures == 1 {
+		_ = s.cache.Expire(ctx, failKey, s.cfg.Security.MFA.LockoutDuration).Err()
+	}
+	if limit := s.cfg.Security.MFA.MaxUserFailures; limit > 0 && failures > int64(limit) {
+		s.log.Warn().Str("user_id", pending.UserID).Str("ip", pending.IPAddress).Msg("mfa locked after repeated failures")
+		return pendingLogin{}, ErrMFALocked
+	}
+
+	if err := verify(pending.UserID); err != nil {
+		if !errors.Is(err, ErrInvalidMFACode) && !errors.Is(err, ErrInvalidPasskey) {
+			_ = s.cache.Decr(ctx, failKey).Err()
+		} else {
+			attempts, incrErr := s.cache.Incr(ctx, key+":attempts").Result()
+			if incrErr == nil && attempts == 1 {
+				_ = s.cache.Expire(ctx, key+":attempts", s.cfg.Security.MFA.ChallengeTTL).Err()
+			}
+			if incrErr == nil && attempts >= int64(s.cfg.Security.MFA.MaxAttempts) {
+				_ = s.cache.Del(ctx, key, key+":attempts").Err()
+				s.log.Warn().Str("user_id", pending.UserID).Str("ip", pending.IPAddress).Msg("mfa challenge attempts exhausted")
+			}
+		}
+		return pendingLogin{}, err
+	}
+
+	// Whoever deletes the challenge owns the login; a parallel request with
+	// another valid code loses.
+	if deleted, err := s.cache.Del(ctx, key).Result(); err != nil {
+		return pendingLogin{}, err
+	} else if deleted == 0 {
+		return pendingLogin{}, ErrInvalidMFAChallenge
+	}
+	_ = s.cache.Del(ctx, key+":attempts", failKey).Err()
+	return pending, nil
+}
+
+func mfaFailureKey(userID string) string {
+	return "mfa:fail:" + userID
+}
+
+func challengeKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "mfa:challenge:" + hex.EncodeToString(sum[:])
+}
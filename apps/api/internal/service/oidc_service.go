@@ -0,0 +1,353 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"golang.org/x/oauth2"
+
+	"nodeimage/api/internal/config"
+	"nodeimage/api/internal/ids"
+	"nodeimage/api/internal/models"
+	"nodeimage/api/internal/repository"
+	"nodeimage/api/internal/security"
+)
+
+var (
+	ErrOIDCDisabled         = errors.New("oidc disabled")
+	ErrInvalidOIDCState     = errors.New("invalid oidc state")
+	ErrOIDCLoginFailed      = errors.New("oidc login failed")
+	ErrOIDCEmailNotVerified = errors.New("oidc email not verified")
+	ErrOIDCAccountNotLinked = errors.New("oidc account not linked")
+	ErrIdentityNotFound     = errors.New("identity not found")
+	ErrOIDCUnavailable      = errors.New("oidc provider unavailable")
+)
+
+// oidcUsers and oidcIdentities are the repository methods OIDCService uses,
+// so tests can run the flow against in-memory stores.
+type oidcUsers interface {
+	Create(ctx context.Context, user models.User) error
+	FindByEmail(ctx context.Context, email string) (models.User, error)
+	GetByID(ctx context.Context, id string) (models.User, error)
+	UpdateStatus(ctx context.Context, id string, status models.UserStatus) error
+	UpdatePassword(ctx context.Context, id string, passwordHash []byte) error
+}
+
+type oidcIdentities interface {
+	Create(ctx context.Context, identity models.ExternalIdentity) error
+	FindBySubject(ctx context.Context, provider string, subject string) (models.ExternalIdentity, error)
+	ListByUser(ctx context.Context, userID string) ([]models.ExternalIdentity, error)
+	TouchLogin(ctx context.Context, id string, email string) error
+	Delete(ctx context.Context, userID string, id string) error
+}
+
+type OIDCService struct {
+	users      oidcUsers
+	identities oidcIdentities
+	cache      *redis.Client
+	cfg        *config.AppConfig
+	log        zerolog.Logger
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func NewOIDCService(
+	users *repository.UserRepository,
+	identities *repository.ExternalIdentityRepository,
+	cache *redis.Client,
+	cfg *config.AppConfig,
+	log zerolog.Logger,
+) *OIDCService {
+	return &OIDCService{
+		users:      users,
+		identities: identities,
+		cache:      cache,
+		cfg:        cfg,
+		log:        log,
+	}
+}
+
+// oidcState is what the authorization request leaves in Redis for the
+// callback: the PKCE verifier and the nonce expected in the ID token.
+type oidcState struct {
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+type OIDCAuthorization struct {
+	URL   string
+	State string
+}
+
+// Authorize starts the authorization-code flow. The caller redirects the
+// browser to URL and must check that the callback carries the same State.
+func (s *OIDCService) Authorize(ctx context.Context) (OIDCAuthorization, error) {
+	provider, err := s.discover(ctx)
+	if err != nil {
+		return OIDCAuthorization{}, err
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return OIDCAuthorization{}, err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return OIDCAuthorization{}, err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	payload, err := json.Marshal(oidcState{Verifier: verifier, Nonce: nonce})
+	if err != nil {
+		return OIDCAuthorization{}, err
+	}
+	if err := s.cache.Set(ctx, oidcStateKey(state), payload, s.cfg.Security.OIDC.StateTTL).Err(); err != nil {
+		return OIDCAuthorization{}, err
+	}
+
+	url := s.oauthConfig(provider).AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(verifier),
+	)
+	return OIDCAuthorization{URL: url, State: state}, nil
+}
+
+// Resolve finishes the flow for an authorization code and returns the local
+// user, linking the identity by verified email on first use.
+func (s *OIDCService) Resolve(ctx context.Context, code string, state string) (models.User, error) {
+	provider, err := s.discover(ctx)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	payload, err := s.cache.GetDel(ctx, oidcStateKey(state)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return models.User{}, ErrInvalidOIDCState
+		}
+		return models.User{}, err
+	}
+	var pending oidcState
+	if err := json.Unmarshal(payload, &pending); err != nil {
+		return models.User{}, ErrInvalidOIDCState
+	}
+
+	token, err := s.oauthConfig(provider).Exchange(ctx, code, oauth2.VerifierOption(pending.Verifier))
+	if err != nil {
+		s.log.Warn().Err(err).Msg("oidc code exchange failed")
+		// The provider answering with an error means the code was refused;
+		// anything else means it could not be reached.
+		var refused *oauth2.RetrieveError
+		if errors.As(err, &refused) {
+			return models.User{}, ErrOIDCLoginFailed
+		}
+		return models.User{}, fmt.Errorf("%w: code exchange: %v", ErrOIDCUnavailable, err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return models.User{}, ErrOIDCLoginFailed
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: s.cfg.Security.OIDC.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		s.log.Warn().Err(err).Msg("oidc id token rejected")
+		return models.User{}, ErrOIDCLoginFailed
+	}
+	if idToken.Nonce != pending.Nonce {
+		s.log.Warn().Str("subject", idToken.Subject).Msg("oidc nonce mismatch")
+		return models.User{}, ErrOIDCLoginFailed
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return models.User{}, ErrOIDCLoginFailed
+	}
+	claims.Email = strings.TrimSpace(strings.ToLower(claims.Email))
+
+	return s.link(ctx, idToken.Subject, claims.Email, claims.EmailVerified, claims.Name)
+}
+
+// link maps a provider subject to a user. A known subject always wins, so
+// later email changes at the provider do not move the link. A new subject
+// is only attached by a verified email.
+func (s *OIDCService) link(ctx context.Context, subject string, email string, emailVerified bool, name string) (models.User, error) {
+	providerName := s.cfg.Security.OIDC.Provider
+
+	identity, err := s.identities.FindBySubject(ctx, providerName, subject)
+	if err == nil {
+		if err := s.identities.TouchLogin(ctx, identity.ID, email); err != nil {
+			s.log.Warn().Err(err).Str("identity_id", identity.ID).Msg("touch external identity failed")
+		}
+		return s.users.GetByID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, repository.ErrExternalIdentityNotFound) {
+		return models.User{}, err
+	}
+
+	if email == "" || !emailVerified {
+		return models.User{}, ErrOIDCEmailNotVerified
+	}
+
+	user, err := s.users.FindByEmail(ctx, email)
+	switch {
+	case err == nil:
+		if user.Status == models.UserStatusPending {
+			if err := s.claimPending(ctx, &user); err != nil {
+				return models.User{}, err
+			}
+		}
+	case errors.Is(err, repository.ErrUserNotFound):
+		if !s.cfg.Security.OIDC.AutoRegister {
+			return models.User{}, ErrOIDCAccountNotLinked
+		}
+		user, err = s.register(ctx, email, name)
+		if err != nil {
+			return models.User{}, err
+		}
+	default:
+		return models.User{}, err
+	}
+
+	if err := s.identities.Create(ctx, models.ExternalIdentity{
+		ID:       ids.New(),
+		UserID:   user.ID,
+		Provider: providerName,
+		Subject:  subject,
+		Email:    email,
+	}); err != nil {
+		return models.User{}, err
+	}
+
+	s.log.Info().Str("user_id", user.ID).Str("provider", providerName).Msg("external identity linked")
+	return user, nil
+}
+
+// claimPending activates a pending account for the provider-verified owner
+// of its address. Whoever registered it never proved the address and may
+// hold its password, so the password is replaced with a random one and any
+// outstanding verification link is voided; the owner can set a password
+// through password reset.
+func (s *OIDCService) claimPending(ctx context.Context, user *models.User) error {
+	passwordHash, err := randomPasswordHash()
+	if err != nil {
+		return err
+	}
+	if err := s.users.UpdatePassword(ctx, user.ID, passwordHash); err != nil {
+		return err
+	}
+	if err := s.cache.Del(ctx, verifyEmailKey(user.ID)).Err(); err != nil {
+		return err
+	}
+	if err := s.users.UpdateStatus(ctx, user.ID, models.UserStatusActive); err != nil {
+		return err
+	}
+	user.PasswordHash = passwordHash
+	user.Status = models.UserStatusActive
+	s.log.Info().Str("user_id", user.ID).Msg("pending account claimed through oidc")
+	return nil
+}
+
+// register creates an account for an OIDC user. The password is random; the
+// user can set one through password reset.
+func (s *OIDCService) register(ctx context.Context, email string, name string) (models.User, error) {
+	passwordHash, err := randomPasswordHash()
+	if err != nil {
+		return models.User{}, err
+	}
+	if name == "" {
+		name = strings.SplitN(email, "@", 2)[0]
+	}
+
+	user := models.User{
+		ID:            ids.New(),
+		Email:         email,
+		PasswordHash:  passwordHash,
+		DisplayName:   name,
+		Role:          models.UserRoleUser,
+		Status:        models.UserStatusActive,
+		StripMetadata: true,
+	}
+	if err := s.users.Create(ctx, user); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (s *OIDCService) ListIdentities(ctx context.Context, userID string) ([]models.ExternalIdentity, error) {
+	return s.identities.ListByUser(ctx, userID)
+}
+
+func (s *OIDCService) Unlink(ctx context.Context, userID string, id string) error {
+	if err := s.identities.Delete(ctx, userID, id); err != nil {
+		if errors.Is(err, repository.ErrExternalIdentityNotFound) {
+			return ErrIdentityNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// discover fetches the provider metadata on first use, so the API still
+// starts while the IdP is unreachable. Failures are retried on the next call.
+func (s *OIDCService) discover(ctx context.Context) (*oidc.Provider, error) {
+	if !s.cfg.Security.OIDC.Enabled {
+		return nil, ErrOIDCDisabled
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.provider != nil {
+		return s.provider, nil
+	}
+	provider, err := oidc.NewProvider(ctx, s.cfg.Security.OIDC.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: discovery: %v", ErrOIDCUnavailable, err)
+	}
+	s.provider = provider
+	return provider, nil
+}
+
+func (s *OIDCService) oauthConfig(provider *oidc.Provider) *oauth2.Config {
+	oc := s.cfg.Security.OIDC
+	return &oauth2.Config{
+		ClientID:     oc.ClientID,
+		ClientSecret: oc.ClientSecret,
+		RedirectURL:  oc.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       oc.Scopes,
+	}
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// randomPasswordHash hashes a password nobody knows.
+func randomPasswordHash() ([]byte, error) {
+	password, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	return security.HashPassword(password)
+}
+
+func oidcStateKey(state string) string {
+	return "oidc:state:" + state
+}
@@ -0,0 +1,394 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+
+	"nodeimage/api/internal/config"
+	"nodeimage/api/internal/models"
+	"nodeimage/api/internal/repository"
+)
+
+const testClientID = "nodeimage-test"
+
+// fakeIdP serves discovery, JWKS and a token endpoint that answers every
+// code with an ID token built from claims.
+type fakeIdP struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                idp.URL,
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("code") == "" || r.PostForm.Get("code_verifier") == "" {
+			http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, idp.claims)
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{"access_token": "access", "token_type": "Bearer", "expires_in": 60, "id_token": signed})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (idp *fakeIdP) issue(subject, email string, verified bool, nonce string) {
+	now := time.Now()
+	idp.claims = jwt.MapClaims{
+		"iss":            idp.URL,
+		"aud":            testClientID,
+		"sub":            subject,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          email,
+		"email_verified": verified,
+	}
+}
+
+type memoryUsers struct {
+	byID map[string]models.User
+}
+
+func (m *memoryUsers) Create(ctx context.Context, user models.User) error {
+	m.byID[user.ID] = user
+	return nil
+}
+
+func (m *memoryUsers) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	for _, user := range m.byID {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return models.User{}, repository.ErrUserNotFound
+}
+
+func (m *memoryUsers) GetByID(ctx context.Context, id string) (models.User, error) {
+	user, ok := m.byID[id]
+	if !ok {
+		return models.User{}, repository.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (m *memoryUsers) UpdateStatus(ctx context.Context, id string, status models.UserStatus) error {
+	user, ok := m.byID[id]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	user.Status = status
+	m.byID[id] = user
+	return nil
+}
+
+func (m *memoryUsers) UpdatePassword(ctx context.Context, id string, passwordHash []byte) error {
+	user, ok := m.byID[id]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	user.PasswordHash = passwordHash
+	m.byID[id] = user
+	return nil
+}
+
+type memoryIdentities struct {
+	items []models.ExternalIdentity
+}
+
+func (m *memoryIdentities) Create(ctx context.Context, identity models.ExternalIdentity) error {
+	m.items = append(m.items, identity)
+	return nil
+}
+
+func (m *memoryIdentities) FindBySubject(ctx context.Context, provider string, subject string) (models.ExternalIdentity, error) {
+	for _, identity := range m.items {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return models.ExternalIdentity{}, repository.ErrExternalIdentityNotFound
+}
+
+func (m *memoryIdentities) ListByUser(ctx context.Context, userID string) ([]models.ExternalIdentity, error) {
+	var out []models.ExternalIdentity
+	for _, identity := range m.items {
+		if identity.UserID == userID {
+			out = append(out, identity)
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryIdentities) TouchLogin(ctx context.Context, id string, email string) error {
+	return nil
+}
+
+func (m *memoryIdentities) Delete(ctx context.Context, userID string, id string) error {
+	return repository.ErrExternalIdentityNotFound
+}
+
+type oidcFixture struct {
+	svc        *OIDCService
+	idp        *fakeIdP
+	redis      *miniredis.Miniredis
+	users      *memoryUsers
+	identities *memoryIdentities
+}
+
+func newOIDCFixture(t *testing.T, users ...models.User) *oidcFixture {
+	t.Helper()
+	idp := newFakeIdP(t)
+	mr := miniredis.RunT(t)
+	cfg := &config.AppConfig{}
+	cfg.Security.OIDC = config.OIDCConfig{
+		Enabled:      true,
+		Provider:     "test",
+		IssuerURL:    idp.URL,
+		ClientID:     testClientID,
+		ClientSecret: "secret",
+		RedirectURL:  "https://app.example/oidc/callback",
+		Scopes:       []string{"openid", "email"},
+		StateTTL:     time.Minute,
+	}
+
+	f := &oidcFixture{
+		idp:        idp,
+		redis:      mr,
+		users:      &memoryUsers{byID: map[string]models.User{}},
+		identities: &memoryIdentities{},
+	}
+	for _, user := range users {
+		f.users.byID[user.ID] = user
+	}
+	f.svc = &OIDCService{
+		users:      f.users,
+		identities: f.identities,
+		cache:      redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		cfg:        cfg,
+		log:        zerolog.Nop(),
+	}
+	return f
+}
+
+// authorize starts a login and returns its state and the nonce the service
+// put in the authorization URL.
+func (f *oidcFixture) authorize(t *testing.T) (string, string) {
+	t.Helper()
+	auth, err := f.svc.Authorize(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(auth.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth.State, u.Query().Get("nonce")
+}
+
+func TestOIDCResolve(t *testing.T) {
+	active := models.User{ID: "user-active", Email: "alice@example.com", Status: models.UserStatusActive, PasswordHash: []byte("alice-hash")}
+	pending := models.User{ID: "user-pending", Email: "bob@example.com", Status: models.UserStatusPending, PasswordHash: []byte("squatter-hash")}
+
+	tests := []struct {
+		name     string
+		subject  string
+		email    string
+		verified bool
+		nonce    string // overrides the nonce from the authorization URL
+		wantErr  error
+		wantUser string
+	}{
+		{name: "links active user by verified email", subject: "sub-alice", email: "Alice@Example.com", verified: true, wantUser: active.ID},
+		{name: "claims pending user by verified email", subject: "sub-bob", email: "bob@example.com", verified: true, wantUser: pending.ID},
+		{name: "unverified email", subject: "sub-alice", email: "alice@example.com", wantErr: ErrOIDCEmailNotVerified},
+		{name: "nonce mismatch", subject: "sub-alice", email: "alice@example.com", verified: true, nonce: "other", wantErr: ErrOIDCLoginFailed},
+		{name: "no matching account", subject: "sub-carol", email: "carol@example.com", verified: true, wantErr: ErrOIDCAccountNotLinked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOIDCFixture(t, active, pending)
+			f.redis.Set(verifyEmailKey(pending.ID), "squatter-nonce")
+			state, nonce := f.authorize(t)
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+			f.idp.issue(tt.subject, tt.email, tt.verified, nonce)
+
+			user, err := f.svc.Resolve(context.Background(), "code", state)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				if len(f.identities.items) != 0 {
+					t.Fatal("identity linked on failure")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.ID != tt.wantUser || user.Status != models.UserStatusActive {
+				t.Fatalf("user = %s (%s), want active %s", user.ID, user.Status, tt.wantUser)
+			}
+			if _, err := f.identities.FindBySubject(context.Background(), "test", tt.subject); err != nil {
+				t.Fatal("identity not linked")
+			}
+		})
+	}
+}
+
+func TestOIDCClaimPendingResetsCredentials(t *testing.T) {
+	pending := models.User{ID: "user-pending", Email: "bob@example.com", Status: models.UserStatusPending, PasswordHash: []byte("squatter-hash")}
+	f := newOIDCFixture(t, pending)
+	f.redis.Set(verifyEmailKey(pending.ID), "squatter-nonce")
+
+	state, nonce := f.authorize(t)
+	f.idp.issue("sub-bob", pending.Email, true, nonce)
+	if _, err := f.svc.Resolve(context.Background(), "code", state); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := f.users.byID[pending.ID]
+	if stored.Status != models.UserStatusActive {
+		t.Fatalf("status = %s, want active", stored.Status)
+	}
+	if bytes.Equal(stored.PasswordHash, pending.PasswordHash) {
+		t.Fatal("password chosen by the registrant still works")
+	}
+	if f.redis.Exists(verifyEmailKey(pending.ID)) {
+		t.Fatal("verification nonce left in place")
+	}
+}
+
+func TestOIDCStateIsSingleUse(t *testing.T) {
+	active := models.User{ID: "user-active", Email: "alice@example.com", Status: models.UserStatusActive}
+	f := newOIDCFixture(t, active)
+	state, nonce := f.authorize(t)
+	f.idp.issue("sub-alice", active.Email, true, nonce)
+
+	if _, err := f.svc.Resolve(context.Background(), "code", state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.svc.Resolve(context.Background(), "code", state); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("reused state: err = %v, want ErrInvalidOIDCState", err)
+	}
+	if _, err := f.svc.Resolve(context.Background(), "code", "never-issued"); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("unknown state: err = %v, want ErrInvalidOIDCState", err)
+	}
+}
+
+func TestOIDCKnownSubjectKeepsLink(t *testing.T) {
+	active := models.User{ID: "user-active", Email: "alice@example.com", Status: models.UserStatusActive}
+	other := models.User{ID: "user-other", Email: "new@example.com", Status: models.UserStatusActive}
+	f := newOIDCFixture(t, active, other)
+	f.identities.items = append(f.identities.items, models.ExternalIdentity{ID: "ident", UserID: active.ID, Provider: "test", Subject: "sub-alice"})
+
+	// The provider now reports another user's address, unverified; the
+	// existing link still decides who signs in.
+	state, nonce := f.authorize(t)
+	f.idp.issue("sub-alice", other.Email, false, nonce)
+	user, err := f.svc.Resolve(context.Background(), "code", state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != active.ID {
+		t.Fatalf("user = %s, want %s", user.ID, active.ID)
+	}
+}
+
+func TestOIDCProviderFailures(t *testing.T) {
+	active := models.User{ID: "user-active", Email: "alice@example.com", Status: models.UserStatusActive}
+
+	t.Run("refused code", func(t *testing.T) {
+		f := newOIDCFixture(t, active)
+		state, nonce := f.authorize(t)
+		f.idp.issue("sub-alice", active.Email, true, nonce)
+		// The fake IdP answers 400 invalid_request to an empty code.
+		if _, err := f.svc.Resolve(context.Background(), "", state); !errors.Is(err, ErrOIDCLoginFailed) {
+			t.Fatalf("err = %v, want ErrOIDCLoginFailed", err)
+		}
+	})
+
+	t.Run("token endpoint down", func(t *testing.T) {
+		f := newOIDCFixture(t, active)
+		state, _ := f.authorize(t)
+		f.idp.Close()
+		if _, err := f.svc.Resolve(context.Background(), "code", state); !errors.Is(err, ErrOIDCUnavailable) {
+			t.Fatalf("err = %v, want ErrOIDCUnavailable", err)
+		}
+	})
+
+	t.Run("discovery down", func(t *testing.T) {
+		f := newOIDCFixture(t, active)
+		f.idp.Close()
+		if _, err := f.svc.Authorize(context.Background()); !errors.Is(err, ErrOIDCUnavailable) {
+			t.Fatalf("err = %v, want ErrOIDCUnavailable", err)
+		}
+	})
+
+	t.Run("cache failure is not a provider failure", func(t *testing.T) {
+		f := newOIDCFixture(t, active)
+		state, nonce := f.authorize(t)
+		f.idp.issue("sub-alice", active.Email, true, nonce)
+		f.redis.Close()
+		_, err := f.svc.Resolve(context.Background(), "code", state)
+		if err == nil || errors.Is(err, ErrOIDCUnavailable) || errors.Is(err, ErrOIDCLoginFailed) {
+			t.Fatalf("err = %v, want an internal error", err)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+
+	"nodeimage/api/internal/config"
+	"nodeimage/api/internal/ids"
+	"nodeimage/api/internal/mailer"
+	"nodeimage/api/internal/models"
+	"nodeimage/api/internal/repository"
+	"nodeimage/api/internal/security"
+)
+
+const purposeResetPassword = "reset_password"
+
+var ErrInvalidResetToken = errors.New("invalid password reset token")
+
+type PasswordResetService struct {
+	users    *repository.UserRepository
+	sessions *repository.SessionRepository
+	resets   *repository.PasswordResetRepository
+	cache    *redis.Client
+	mailer   mailer.Mailer
+	cfg      *config.AppConfig
+	log      zerolog.Logger
+}
+
+func NewPasswordResetService(
+	users *repository.UserRepository,
+	sessions *repository.SessionRepository,
+	resets *repository.PasswordResetRepository,
+	cache *redis.Client,
+	mail mailer.Mailer,
+	cfg *config.AppConfig,
+	log zerolog.Logger,
+) *PasswordResetService {
+	return &PasswordResetService{
+		users:    users,
+		sessions: sessions,
+		resets:   resets,
+		cache:    cache,
+		mailer:   mail,
+		cfg:      cfg,
+		log:      log,
+	}
+}
+
+// Request emails a reset link if the address belongs to a usable account.
+// Callers must answer the same way whatever happens here; failures are only
+// logged so the response cannot reveal whether the account exists.
+func (s *PasswordResetService) Request(ctx context.Context, email string) {
+	email = strings.TrimSpace(strings.ToLower(email))
+	settings := s.cfg.Security.PasswordReset
+
+	acquired, err := s.cache.SetNX(ctx, "reset:request:"+email, "1", settings.RequestInterval).Result()
+	if err != nil {
+		s.log.Error().Err(err).Msg("password reset rate limit check failed")
+		return
+	}
+	if !acquired {
+		return
+	}
+
+	user, err := s.users.FindByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, repository.ErrUserNotFound) {
+			s.log.Error().Err(err).Msg("password reset user lookup failed")
+		}
+		return
+	}
+	if user.Status == models.UserStatusSuspended {
+		return
+	}
+
+	if err := s.send(ctx, user); err != nil {
+		s.log.Error().Err(err).Str("user_id", user.ID).Msg("send password reset failed")
+	}
+}
+
+func (s *PasswordResetService) send(ctx context.Context, user models.User) error {
+	settings := s.cfg.Security.PasswordReset
+	token, action, err := security.GenerateActionToken(s.cfg.Security.ActionTokenSecret, purposeResetPassword, user.ID, settings.TokenTTL)
+	if err != nil {
+		return err
+	}
+	if err := s.resets.Create(ctx, models.PasswordReset{
+		ID:        ids.New(),
+		UserID:    user.ID,
+		TokenHash: security.HashNonce(action.Nonce),
+		ExpiresAt: action.ExpiresAt,
+	}); err != nil {
+		return err
+	}
+
+	link := settings.LinkURL + "?token=" + url.QueryEscape(token)
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your NodeImage password",
+		Text: fmt.Sprintf(
+			"Hi %s,\n\nSomeone asked to reset the password for your NodeImage account. Choose a new password here:\n\n%s\n\nThe link expires in %s and works once. If this was not you, ignore this email; your password stays the same.\n",
+			user.DisplayName, link, settings.TokenTTL,
+		),
+	})
+}
+
+// Confirm sets a new password from a reset token, uses the token up, and
+// signs the user out everywhere.
+func (s *PasswordResetService) Confirm(ctx context.Context, token string, password string) error {
+	action, err := security.ParseActionToken(s.cfg.Security.ActionTokenSecret, purposeResetPassword, token)
+	if err != nil {
+		return ErrInvalidResetToken
+	}
+
+	reset, err := s.resets.Consume(ctx, security.HashNonce(action.Nonce))
+	if err != nil {
+		if errors.Is(err, repository.ErrPasswordResetNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+	if reset.UserID != action.Subject {
+		return ErrInvalidResetToken
+	}
+
+	passwordHash, err := security.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := s.users.UpdatePassword(ctx, reset.UserID, passwordHash); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	if err := s.sessions.DeleteByUser(ctx, reset.UserID); err != nil {
+		return fmt.Errorf("revoke sessions: %w", err)
+	}
+
+	s.log.Info().
+		Str("user_id", reset.UserID).
+		Time("requested_at", reset.CreatedAt).
+		Dur("age", time.Since(reset.CreatedAt)).
+		Msg("password reset; all sessions revoked")
+	return nil
+}
@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
+	"net/http"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/minio/minio-go/v7"
@@ -18,6 +21,9 @@ import (
 
 	"nodeimage/api/internal/config"
 	"nodeimage/api/internal/ids"
+	"nodeimage/api/internal/media/archive"
+	"nodeimage/api/internal/media/datauri"
+	"nodeimage/api/internal/media/metadata"
 	"nodeimage/api/internal/media/sniffer"
 	"nodeimage/api/internal/media/svg"
 	"nodeimage/api/internal/models"
@@ -26,6 +32,20 @@ import (
 	"nodeimage/api/internal/storage"
 )
 
+var (
+	ErrInvalidUpload    = errors.New("invalid file payload")
+	ErrEmptyFile        = errors.New("empty file")
+	ErrTypeMismatch     = errors.New("content type mismatch")
+	ErrTooManyFiles     = errors.New("too many files")
+	ErrNotImage         = errors.New("not an image")
+	ErrFormatNotAllowed = errors.New("format not allowed")
+	ErrFileTooLarge     = errors.New("file too large")
+)
+
+// UploadErrorInternal is the code for failures that are not the client's
+// fault; their details are logged rather than returned.
+const UploadErrorInternal = "internal_error"
+
 type UploadInput struct {
 	User       models.User
 	DeviceID   string
@@ -60,7 +80,11 @@ func NewUploadService(images *repository.ImageRepository, store *storage.ObjectS
 
 func (s *UploadService) Upload(ctx context.Context, input UploadInput) (UploadResult, error) {
 	if input.File == nil || input.Header == nil {
-		return UploadResult{}, errors.New("invalid file payload")
+		return UploadResult{}, ErrInvalidUpload
+	}
+	limit := s.cfg.Upload.MaxFileBytes
+	if limit > 0 && input.Header.Size > limit {
+		return UploadResult{}, fmt.Errorf("%w: more than %d bytes", ErrFileTooLarge, limit)
 	}
 
 	head := make([]byte, 512)
@@ -75,37 +99,119 @@ func (s *UploadService) Upload(ctx context.Context, input UploadInput) (UploadRe
 		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
 			return UploadResult{}, fmt.Errorf("rewind: %w", err)
 		}
-		data, err = io.ReadAll(seeker)
-		if err != nil {
-			return UploadResult{}, fmt.Errorf("read file: %w", err)
-		}
+		data, err = readLimited(seeker, limit, ErrFileTooLarge)
 	} else {
-		rest, err := io.ReadAll(input.File)
-		if err != nil {
-			return UploadResult{}, fmt.Errorf("read file: %w", err)
-		}
-		data = append(head, rest...)
+		data, err = readLimited(io.MultiReader(bytes.NewReader(head), input.File), limit, ErrFileTooLarge)
 	}
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("read file: %w", err)
+	}
+
+	return s.UploadBytes(ctx, UploadBytesInput{
+		User:         input.User,
+		DeviceID:     input.DeviceID,
+		Data:         data,
+		DeclaredMIME: sniffer.MimeTypeFromHTTP(http.Header(input.Header.Header)),
+		Visibility:   input.Visibility,
+		ExpireAt:     input.ExpireAt,
+	})
+}
 
-	result, err := sniffer.DetectHead(head)
+type UploadEncodedInput struct {
+	User       models.User
+	DeviceID   string
+	Payload    string
+	Visibility string
+	ExpireAt   *time.Time
+}
+
+func (s *UploadService) UploadEncoded(ctx context.Context, input UploadEncodedInput) (UploadResult, error) {
+	payload, err := datauri.Decode(input.Payload, s.cfg.Upload.MaxInlineBytes)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	return s.UploadBytes(ctx, UploadBytesInput{
+		User:         input.User,
+		DeviceID:     input.DeviceID,
+		Data:         payload.Data,
+		DeclaredMIME: payload.MIME,
+		Visibility:   input.Visibility,
+		ExpireAt:     input.ExpireAt,
+	})
+}
+
+type UploadBytesInput struct {
+	User         models.User
+	DeviceID     string
+	Data         []byte
+	DeclaredMIME string
+	Visibility   string
+	ExpireAt     *time.Time
+}
+
+func (s *UploadService) UploadBytes(ctx context.Context, input UploadBytesInput) (UploadResult, error) {
+	data := input.Data
+	if len(data) == 0 {
+		return UploadResult{}, ErrEmptyFile
+	}
+
+	result, err := sniffer.DetectBytes(data)
 	if err != nil {
 		return UploadResult{}, fmt.Errorf("detect type: %w", err)
 	}
 
-	declared := sniffer.MimeTypeFromHTTP(input.Header.Header)
-	if declared != "" && declared != result.MIME {
-		return UploadResult{}, fmt.Errorf("content type mismatch: declared %s, actual %s", declared, result.MIME)
+	if input.DeclaredMIME != "" && !sniffer.MatchesMIME(input.DeclaredMIME, result) {
+		return UploadResult{}, fmt.Errorf("%w: declared %s, actual %s", ErrTypeMismatch, input.DeclaredMIME, result.MIME)
 	}
 
-	if len(data) == 0 {
-		return UploadResult{}, errors.New("empty file")
+	if err := s.checkFormat(result.Type); err != nil {
+		return UploadResult{}, err
 	}
 
 	if result.Type == sniffer.TypeSVG {
-		clean, err := svg.Sanitize(data)
+		if data, err = s.svgDocument(result, data); err != nil {
+			return UploadResult{}, err
+		}
+		result.Compressed = false
+	}
+
+	if err := sniffer.Validate(result.Type, data); err != nil {
+		return UploadResult{}, err
+	}
+
+	result, err = sniffer.Inspect(result, data)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("inspect header: %w", err)
+	}
+	if err := s.limits().Check(result.Dimensions()); err != nil {
+		return UploadResult{}, err
+	}
+
+	if input.User.StripMetadata {
+		stripped, err := metadata.Strip(result.Type, data)
+		if err != nil {
+			return UploadResult{}, fmt.Errorf("strip metadata: %w", err)
+		}
+		data = stripped
+
+		// Applying EXIF orientation can swap width and height.
+		if result, err = sniffer.Inspect(result, data); err != nil {
+			return UploadResult{}, fmt.Errorf("inspect header: %w", err)
+		}
+	}
+
+	if result.Type == sniffer.TypeSVG {
+		clean, report, err := svg.Sanitize(data, svg.Limits{
+			MaxDepth:    s.cfg.Upload.MaxSVGDepth,
+			MaxElements: s.cfg.Upload.MaxSVGElements,
+		})
 		if err != nil {
 			return UploadResult{}, fmt.Errorf("sanitize svg: %w", err)
 		}
+		if len(report.Removed) > 0 {
+			s.log.Info().Str("user_id", input.User.ID).Interface("removed", report.Removed).Msg("svg sanitized")
+		}
 		data = clean
 	}
 
@@ -134,9 +240,9 @@ func (s *UploadService) Upload(ctx context.Context, input UploadInput) (UploadRe
 		Bucket:    s.cfg.Storage.BucketOriginals,
 		ObjectKey: objectKey,
 		Format:    string(result.Type),
-		Width:     0,
-		Height:    0,
-		Frames:    1,
+		Width:     result.Width,
+		Height:    result.Height,
+		Frames:    result.Frames,
 		SizeBytes: uploadInfo.Size,
 		Status:    models.ImageStatusProcessing,
 		Visibility: func() string {
@@ -157,7 +263,7 @@ func (s *UploadService) Upload(ctx context.Context, input UploadInput) (UploadRe
 		return UploadResult{}, fmt.Errorf("save metadata: %w", err)
 	}
 
-	if err := s.enqueueProcessing(ctx, image); err != nil {
+	if err := s.enqueueProcessing(ctx, image, result); err != nil {
 		s.log.Warn().Err(err).Str("image_id", image.ID).Msg("enqueue processing failed")
 	}
 
@@ -169,6 +275,277 @@ func (s *UploadService) Upload(ctx context.Context, input UploadInput) (UploadRe
 	}, nil
 }
 
+type BatchUploadInput struct {
+	User       models.User
+	DeviceID   string
+	Files      []*multipart.FileHeader
+	Visibility string
+	ExpireAt   *time.Time
+}
+
+type BatchItemResult struct {
+	Index    int
+	Filename string
+	Result   UploadResult
+	Err      error
+	Skipped  bool
+}
+
+func (s *UploadService) UploadBatch(ctx context.Context, input BatchUploadInput) ([]BatchItemResult, error) {
+	if len(input.Files) == 0 {
+		return nil, ErrInvalidUpload
+	}
+	if max := s.cfg.Upload.MaxBatchFiles; max > 0 && len(input.Files) > max {
+		return nil, fmt.Errorf("%w: %d files, limit %d", ErrTooManyFiles, len(input.Files), max)
+	}
+
+	results := make([]BatchItemResult, len(input.Files))
+	for i, header := range input.Files {
+		results[i] = BatchItemResult{Index: i, Filename: header.Filename}
+	}
+
+	s.runBounded(len(input.Files), func(i int) {
+		results[i].Result, results[i].Err = s.uploadFileHeader(ctx, input, input.Files[i])
+	})
+
+	return results, nil
+}
+
+// runBounded calls fn for each index in [0, n) with at most
+// Upload.BatchConcurrency calls in flight, and waits for all of them.
+func (s *UploadService) runBounded(n int, fn func(i int)) {
+	concurrency := s.cfg.Upload.BatchConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (s *UploadService) uploadFileHeader(ctx context.Context, input BatchUploadInput, header *multipart.FileHeader) (UploadResult, error) {
+	if err := ctx.Err(); err != nil {
+		return UploadResult{}, err
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("%w: %v", ErrInvalidUpload, err)
+	}
+	defer file.Close()
+
+	return s.Upload(ctx, UploadInput{
+		User:       input.User,
+		DeviceID:   input.DeviceID,
+		File:       file,
+		Header:     header,
+		Visibility: input.Visibility,
+		ExpireAt:   input.ExpireAt,
+	})
+}
+
+type ArchiveUploadInput struct {
+	User       models.User
+	DeviceID   string
+	File       multipart.File
+	Visibility string
+	ExpireAt   *time.Time
+}
+
+// UploadArchive expands a ZIP or (gzipped) TAR upload and runs every image
+// entry through UploadBytes. Non-image entries are reported as skipped and
+// unsafe paths as failed; neither aborts the rest of the archive.
+func (s *UploadService) UploadArchive(ctx context.Context, input ArchiveUploadInput) ([]BatchItemResult, error) {
+	if input.File == nil {
+		return nil, ErrInvalidUpload
+	}
+	// The archive itself may not be larger than what it is allowed to
+	// expand to.
+	data, err := readLimited(input.File, s.cfg.Upload.ArchiveMaxBytes, archive.ErrTooLarge)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := archive.Expand(data, archive.Limits{
+		MaxEntries: s.cfg.Upload.ArchiveMaxEntries,
+		MaxBytes:   s.cfg.Upload.ArchiveMaxBytes,
+		MaxDepth:   s.cfg.Upload.ArchiveMaxDepth,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchItemResult, len(entries))
+	var pending []int
+	for i, entry := range entries {
+		results[i] = BatchItemResult{Index: i, Filename: entry.Path}
+		switch {
+		case entry.Err != nil:
+			results[i].Err = entry.Err
+			results[i].Skipped = errors.Is(entry.Err, archive.ErrNestingTooDeep)
+		case !isImage(entry.Data):
+			results[i].Err = ErrNotImage
+			results[i].Skipped = true
+		default:
+			pending = append(pending, i)
+		}
+	}
+
+	s.runBounded(len(pending), func(n int) {
+		i := pending[n]
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			return
+		}
+		results[i].Result, results[i].Err = s.UploadBytes(ctx, UploadBytesInput{
+			User:       input.User,
+			DeviceID:   input.DeviceID,
+			Data:       entries[i].Data,
+			Visibility: input.Visibility,
+			ExpireAt:   input.ExpireAt,
+		})
+	})
+
+	return results, nil
+}
+
+// readLimited reads r to the end and fails with tooLarge once more than
+// limit bytes arrive. A limit of zero or less reads everything.
+func readLimited(r io.Reader, limit int64, tooLarge error) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: more than %d bytes", tooLarge, limit)
+	}
+	return data, nil
+}
+
+func isImage(data []byte) bool {
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	_, err := sniffer.DetectHead(head)
+	return err == nil
+}
+
+func UploadErrorCode(err error) string {
+	var invalid *sniffer.ValidationError
+	if errors.As(err, &invalid) {
+		return string(invalid.Reason)
+	}
+	var bodyTooLarge *http.MaxBytesError
+	if errors.As(err, &bodyTooLarge) {
+		return "request_too_large"
+	}
+
+	switch {
+	case errors.Is(err, ErrInvalidUpload):
+		return "invalid_file"
+	case errors.Is(err, ErrEmptyFile):
+		return "empty_file"
+	case errors.Is(err, ErrTypeMismatch):
+		return "content_type_mismatch"
+	case errors.Is(err, ErrTooManyFiles):
+		return "too_many_files"
+	case errors.Is(err, ErrFileTooLarge):
+		return "file_too_large"
+	case errors.Is(err, ErrNotImage):
+		return "not_an_image"
+	case errors.Is(err, archive.ErrUnsupportedArchive):
+		return "unsupported_archive"
+	case errors.Is(err, archive.ErrTooManyEntries):
+		return "too_many_entries"
+	case errors.Is(err, archive.ErrTooLarge):
+		return "archive_too_large"
+	case errors.Is(err, archive.ErrUnsafePath):
+		return "unsafe_path"
+	case errors.Is(err, archive.ErrNestingTooDeep):
+		return "nesting_too_deep"
+	case errors.Is(err, sniffer.ErrUnknownType):
+		return "unsupported_type"
+	case errors.Is(err, ErrFormatNotAllowed):
+		return "format_not_allowed"
+	case errors.Is(err, sniffer.ErrLimitExceeded):
+		return "image_too_large"
+	case errors.Is(err, sniffer.ErrSVGTooLarge):
+		return "svg_too_large"
+	case errors.Is(err, svg.ErrInvalidSVG):
+		return "invalid_svg"
+	case errors.Is(err, svg.ErrTooComplex):
+		return "svg_too_complex"
+	case errors.Is(err, sniffer.ErrMalformedHeader):
+		return "malformed_image"
+	case errors.Is(err, datauri.ErrInvalidEncoding):
+		return "invalid_encoding"
+	case errors.Is(err, datauri.ErrTooLarge):
+		return "payload_too_large"
+	case errors.Is(err, metadata.ErrMalformed):
+		return "malformed_image"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return UploadErrorInternal
+	}
+}
+
+// svgDocument inflates svgz uploads so only plain SVG is sanitized and
+// stored; both forms share the same size limit, where zero means unlimited.
+func (s *UploadService) svgDocument(result sniffer.Result, data []byte) ([]byte, error) {
+	if result.Compressed {
+		return sniffer.DecompressSVGZ(data, s.cfg.Upload.MaxSVGBytes)
+	}
+	if s.cfg.Upload.MaxSVGBytes > 0 && int64(len(data)) > s.cfg.Upload.MaxSVGBytes {
+		return nil, fmt.Errorf("%w: more than %d bytes", sniffer.ErrSVGTooLarge, s.cfg.Upload.MaxSVGBytes)
+	}
+	return data, nil
+}
+
+// checkFormat accepts a type only if it is both allowed and processable by
+// the deployed worker, so nothing is stored that can never become ready.
+func (s *UploadService) checkFormat(mediaType sniffer.MediaType) error {
+	if !containsFormat(s.cfg.Upload.AllowedFormats, mediaType) {
+		return fmt.Errorf("%w: %s is not allowed", ErrFormatNotAllowed, mediaType)
+	}
+	if !containsFormat(s.cfg.Upload.WorkerFormats, mediaType) {
+		return fmt.Errorf("%w: %s cannot be processed yet", ErrFormatNotAllowed, mediaType)
+	}
+	return nil
+}
+
+func containsFormat(formats []string, mediaType sniffer.MediaType) bool {
+	for _, format := range formats {
+		if strings.EqualFold(strings.TrimSpace(format), string(mediaType)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *UploadService) limits() sniffer.Limits {
+	return sniffer.Limits{
+		MaxWidth:  s.cfg.Upload.MaxWidth,
+		MaxHeight: s.cfg.Upload.MaxHeight,
+		MaxPixels: s.cfg.Upload.MaxPixels,
+		MaxFrames: s.cfg.Upload.MaxFrames,
+	}
+}
+
 func (s *UploadService) buildObjectKey(imageID string, ext string) string {
 	datePrefix := time.Now().UTC().Format("2006/01/02")
 	return path.Join(datePrefix, fmt.Sprintf("%s.%s", imageID, ext))
@@ -182,21 +559,48 @@ func (s *UploadService) buildPublicURL(bucket, objectKey string) string {
 	return fmt.Sprintf("%s/%s/%s", base, bucket, objectKey)
 }
 
-func (s *UploadService) enqueueProcessing(ctx context.Context, image models.Image) error {
+// enqueueProcessing passes the header fields along so the worker can plan
+// its work without decoding first. Stream values arrive as strings, so the
+// header travels as one JSON field.
+func (s *UploadService) enqueueProcessing(ctx context.Context, image models.Image, result sniffer.Result) error {
 	if s.queue == nil {
 		return nil
 	}
 
+	header, err := json.Marshal(ingestHeader{
+		Width:     result.Width,
+		Height:    result.Height,
+		Frames:    result.Frames,
+		BitDepth:  result.BitDepth,
+		ColorType: string(result.ColorType),
+		HasAlpha:  result.HasAlpha,
+		Animated:  result.Animated,
+	})
+	if err != nil {
+		return fmt.Errorf("encode header: %w", err)
+	}
+
 	payload := map[string]any{
 		"type":    "ingest",
 		"imageId": image.ID,
 		"bucket":  image.Bucket,
 		"object":  image.ObjectKey,
 		"format":  image.Format,
+		"header":  string(header),
 	}
-	_, err := s.queue.XAdd(ctx, &redis.XAddArgs{
+	_, err = s.queue.XAdd(ctx, &redis.XAddArgs{
 		Stream: "media:ingest",
 		Values: payload,
 	}).Result()
 	return err
 }
+
+type ingestHeader struct {
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Frames    int    `json:"frames"`
+	BitDepth  int    `json:"bitDepth"`
+	ColorType string `json:"colorType"`
+	HasAlpha  bool   `json:"hasAlpha"`
+	Animated  bool   `json:"animated"`
+}
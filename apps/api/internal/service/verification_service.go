@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+
+	"nodeimage/api/internal/config"
+	"nodeimage/api/internal/mailer"
+	"nodeimage/api/internal/models"
+	"nodeimage/api/internal/repository"
+	"nodeimage/api/internal/security"
+)
+
+const purposeVerifyEmail = "verify_email"
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid verification token")
+	ErrRateLimited              = errors.New("rate limited")
+)
+
+type VerificationService struct {
+	users  *repository.UserRepository
+	cache  *redis.Client
+	mailer mailer.Mailer
+	cfg    *config.AppConfig
+	log    zerolog.Logger
+}
+
+func NewVerificationService(
+	users *repository.UserRepository,
+	cache *redis.Client,
+	mail mailer.Mailer,
+	cfg *config.AppConfig,
+	log zerolog.Logger,
+) *VerificationService {
+	return &VerificationService{
+		users:  users,
+		cache:  cache,
+		mailer: mail,
+		cfg:    cfg,
+		log:    log,
+	}
+}
+
+// Send mails a fresh verification link. Only the latest link works: its nonce
+// hash replaces any earlier one in Redis.
+func (s *VerificationService) Send(ctx context.Context, user models.User) error {
+	settings := s.cfg.Security.EmailVerification
+	token, action, err := security.GenerateActionToken(s.cfg.Security.ActionTokenSecret, purposeVerifyEmail, user.ID, settings.TokenTTL)
+	if err != nil {
+		return err
+	}
+	if err := s.cache.Set(ctx, verifyEmailKey(user.ID), security.HashNonce(action.Nonce), settings.TokenTTL).Err(); err != nil {
+		return fmt.Errorf("store verification nonce: %w", err)
+	}
+
+	link := settings.LinkURL + "?token=" + url.QueryEscape(token)
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your NodeImage email address",
+		Text: fmt.Sprintf(
+			"Hi %s,\n\nConfirm your email address to activate your NodeImage account:\n\n%s\n\nThe link expires in %s. If you did not sign up, ignore this email.\n",
+			user.DisplayName, link, settings.TokenTTL,
+		),
+	})
+}
+
+// Verify consumes a verification token and activates the pending user it
+// was issued for. A second use of the same token fails.
+func (s *VerificationService) Verify(ctx context.Context, token string) (models.User, error) {
+	action, err := security.ParseActionToken(s.cfg.Security.ActionTokenSecret, purposeVerifyEmail, token)
+	if err != nil {
+		return models.User{}, ErrInvalidVerificationToken
+	}
+
+	stored, err := s.cache.GetDel(ctx, verifyEmailKey(action.Subject)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return models.User{}, ErrInvalidVerificationToken
+		}
+		return models.User{}, err
+	}
+	if subtle.ConstantTimeCompare(stored, security.HashNonce(action.Nonce)) != 1 {
+		// An older link; put the current nonce back so it still works.
+		ttl := time.Until(action.ExpiresAt)
+		if ttl > 0 {
+			_ = s.cache.SetNX(ctx, verifyEmailKey(action.Subject), stored, ttl).Err()
+		}
+		return models.User{}, ErrInvalidVerificationToken
+	}
+
+	user, err := s.users.GetByID(ctx, action.Subject)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return models.User{}, ErrInvalidVerificationToken
+		}
+		return models.User{}, err
+	}
+	if user.Status == models.UserStatusPending {
+		if err := s.users.UpdateStatus(ctx, user.ID, models.UserStatusActive); err != nil {
+			return models.User{}, err
+		}
+		user.Status = models.UserStatusActive
+		s.log.Info().Str("user_id", user.ID).Msg("email verified")
+	}
+	return user, nil
+}
+
+// Resend mails a new link to a pending user. The limits apply per address
+// whether or not an account exists, and the outcome is otherwise silent, so
+// the endpoint cannot be used to probe for registered emails.
+func (s *VerificationService) Resend(ctx context.Context, email string) error {
+	email = strings.TrimSpace(strings.ToLower(email))
+	settings := s.cfg.Security.EmailVerification
+
+	acquired, err := s.cache.SetNX(ctx, "verify:resend:"+email, "1", settings.ResendInterval).Result()
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return ErrRateLimited
+	}
+	if settings.MaxResendsPerDay > 0 {
+		dayKey := "verify:resend:day:" + email
+		count, err := s.cache.Incr(ctx, dayKey).Result()
+		if err != nil {
+			return err
+		}
+		if count == 1 {
+			_ = s.cache.Expire(ctx, dayKey, 24*time.Hour).Err()
+		}
+		if count > int64(settings.MaxResendsPerDay) {
+			return ErrRateLimited
+		}
+	}
+
+	user, err := s.users.FindByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+	if user.Status != models.UserStatusPending {
+		return nil
+	}
+	if err := s.Send(ctx, user); err != nil {
+		s.log.Error().Err(err).Str("user_id", user.ID).Msg("resend verification email failed")
+	}
+	return nil
+}
+
+func verifyEmailKey(userID string) string {
+	return "verify:email:" + userID
+}
@@ -0,0 +1,407 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+
+	"nodeimage/api/internal/config"
+	"nodeimage/api/internal/ids"
+	"nodeimage/api/internal/models"
+	"nodeimage/api/internal/repository"
+)
+
+var (
+	ErrPasskeysDisabled = errors.New("passkeys disabled")
+	ErrPasskeyLimit     = errors.New("passkey limit reached")
+	ErrInvalidPasskey   = errors.New("invalid passkey")
+	ErrPasskeyNotFound  = errors.New("passkey not found")
+	ErrMFARequired      = errors.New("mfa required")
+)
+
+type webAuthnCredentials interface {
+	Create(ctx context.Context, cred models.WebAuthnCredential) error
+	ListByUser(ctx context.Context, userID string) ([]models.WebAuthnCredential, error)
+	RecordUse(ctx context.Context, id string, signCount uint32, backupState bool, cloneWarning bool) error
+	Delete(ctx context.Context, userID string, id string) error
+}
+
+type webAuthnUsers interface {
+	GetByID(ctx context.Context, id string) (models.User, error)
+}
+
+type mfaStatus interface {
+	Enabled(ctx context.Context, userID string) (bool, error)
+}
+
+type WebAuthnService struct {
+	creds webAuthnCredentials
+	users webAuthnUsers
+	mfa   mfaStatus
+	cache *redis.Client
+	cfg   *config.AppConfig
+	log   zerolog.Logger
+	rp    *webauthn.WebAuthn
+}
+
+func NewWebAuthnService(
+	creds *repository.WebAuthnRepository,
+	users *repository.UserRepository,
+	mfa *MFAService,
+	cache *redis.Client,
+	cfg *config.AppConfig,
+	log zerolog.Logger,
+) *WebAuthnService {
+	s := &WebAuthnService{
+		creds: creds,
+		users: users,
+		mfa:   mfa,
+		cache: cache,
+		cfg:   cfg,
+		log:   log,
+	}
+
+	wc := cfg.Security.WebAuthn
+	if wc.RPID == "" {
+		return s
+	}
+	rp, err := webauthn.New(&webauthn.Config{
+		RPID:          wc.RPID,
+		RPDisplayName: wc.RPDisplayName,
+		RPOrigins:     wc.RPOrigins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: wc.ChallengeTTL, TimeoutUVD: wc.ChallengeTTL},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: wc.ChallengeTTL, TimeoutUVD: wc.ChallengeTTL},
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("invalid webauthn config, passkeys disabled")
+		return s
+	}
+	s.rp = rp
+	return s
+}
+
+// webAuthnUser adapts a user and their stored credentials to the library.
+// The user handle is the user ID, which carries no personal data.
+type webAuthnUser struct {
+	user  models.User
+	creds []models.WebAuthnCredential
+}
+
+func (u webAuthnUser) WebAuthnID() []byte          { return []byte(u.user.ID) }
+func (u webAuthnUser) WebAuthnName() string        { return u.user.Email }
+func (u webAuthnUser) WebAuthnDisplayName() string { return u.user.DisplayName }
+func (u webAuthnUser) WebAuthnIcon() string        { return "" }
+
+func (u webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	out := make([]webauthn.Credential, 0, len(u.creds))
+	for _, cred := range u.creds {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(cred.Transports))
+		for _, t := range cred.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		out = append(out, webauthn.Credential{
+			ID:              cred.CredentialID,
+			PublicKey:       cred.PublicKey,
+			AttestationType: cred.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: cred.BackupEligible,
+				BackupState:    cred.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    cred.AAGUID,
+				SignCount: cred.SignCount,
+			},
+		})
+	}
+	return out
+}
+
+func (u webAuthnUser) find(credentialID []byte) (models.WebAuthnCredential, bool) {
+	for _, cred := range u.creds {
+		if bytes.Equal(cred.CredentialID, credentialID) {
+			return cred, true
+		}
+	}
+	return models.WebAuthnCredential{}, false
+}
+
+func (s *WebAuthnService) List(ctx context.Context, userID string) ([]models.WebAuthnCredential, error) {
+	return s.creds.ListByUser(ctx, userID)
+}
+
+func (s *WebAuthnService) Delete(ctx context.Context, userID string, id string) error {
+	if err := s.creds.Delete(ctx, userID, id); err != nil {
+		if errors.Is(err, repository.ErrWebAuthnCredentialNotFound) {
+			return ErrPasskeyNotFound
+		}
+		return err
+	}
+	s.log.Info().Str("user_id", userID).Str("passkey_id", id).Msg("passkey removed")
+	return nil
+}
+
+// BeginRegistration returns creation options for navigator.credentials.create.
+// Users with TOTP enabled must be on a session that passed it, so a stolen
+// password-only token cannot plant a passkey.
+func (s *WebAuthnService) BeginRegistration(ctx context.Context, user models.User, sessionMFA bool) (*protocol.CredentialCreation, error) {
+	if s.rp == nil {
+		return nil, ErrPasskeysDisabled
+	}
+	if !sessionMFA {
+		enabled, err := s.mfa.Enabled(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if enabled {
+			return nil, ErrMFARequired
+		}
+	}
+
+	creds, err := s.creds.ListByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(creds) >= s.cfg.Security.WebAuthn.MaxCredentials {
+		return nil, ErrPasskeyLimit
+	}
+
+	waUser := webAuthnUser{user: user, creds: creds}
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(creds))
+	for _, cred := range waUser.WebAuthnCredentials() {
+		exclusions = append(exclusions, cred.Descriptor())
+	}
+
+	creation, session, err := s.rp.BeginRegistration(waUser,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.saveCeremony(ctx, "register", session); err != nil {
+		return nil, err
+	}
+	return creation, nil
+}
+
+// FinishRegistration verifies the attestation returned by the browser and
+// stores the new credential under name.
+func (s *WebAuthnService) FinishRegistration(ctx context.Context, user models.User, name string, response []byte) (models.WebAuthnCredential, error) {
+	if s.rp == nil {
+		return models.WebAuthnCredential{}, ErrPasskeysDisabled
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return models.WebAuthnCredential{}, ErrInvalidPasskey
+	}
+	session, err := s.takeCeremony(ctx, "register", parsed.Response.CollectedClientData.Challenge)
+	if err != nil {
+		return models.WebAuthnCredential{}, err
+	}
+
+	creds, err := s.creds.ListByUser(ctx, user.ID)
+	if err != nil {
+		return models.WebAuthnCredential{}, err
+	}
+	if len(creds) >= s.cfg.Security.WebAuthn.MaxCredentials {
+		return models.WebAuthnCredential{}, ErrPasskeyLimit
+	}
+
+	credential, err := s.rp.CreateCredential(webAuthnUser{user: user, creds: creds}, session, parsed)
+	if err != nil {
+		s.log.Debug().Err(err).Str("user_id", user.ID).Msg("passkey registration rejected")
+		return models.WebAuthnCredential{}, ErrInvalidPasskey
+	}
+
+	if name == "" {
+		name = "Passkey"
+	}
+	transports := make([]string, 0, len(credential.Transport))
+	for _, t := range credential.Transport {
+		transports = append(transports, string(t))
+	}
+	cred := models.WebAuthnCredential{
+		ID:              ids.New(),
+		UserID:          user.ID,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		Name:            name,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		CreatedAt:       time.Now(),
+	}
+	if err := s.creds.Create(ctx, cred); err != nil {
+		return models.WebAuthnCredential{}, err
+	}
+
+	s.log.Info().Str("user_id", user.ID).Str("passkey_id", cred.ID).Msg("passkey registered")
+	return cred, nil
+}
+
+// BeginLogin returns request options for navigator.credentials.get. With an
+// empty userID any discoverable passkey may answer and user verification is
+// required, since the passkey stands in for both factors. With a userID only
+// that user's passkeys are allowed, for use as a second factor.
+func (s *WebAuthnService) BeginLogin(ctx context.Context, userID string) (*protocol.CredentialAssertion, error) {
+	if s.rp == nil {
+		return nil, ErrPasskeysDisabled
+	}
+
+	var (
+		assertion *protocol.CredentialAssertion
+		session   *webauthn.SessionData
+		err       error
+	)
+	if userID == "" {
+		assertion, session, err = s.rp.BeginDiscoverableLogin(
+			webauthn.WithUserVerification(protocol.VerificationRequired),
+		)
+	} else {
+		var waUser webAuthnUser
+		waUser, err = s.loadUser(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if len(waUser.creds) == 0 {
+			return nil, ErrPasskeyNotFound
+		}
+		assertion, session, err = s.rp.BeginLogin(waUser,
+			webauthn.WithUserVerification(protocol.VerificationPreferred),
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.saveCeremony(ctx, "login", session); err != nil {
+		return nil, err
+	}
+	return assertion, nil
+}
+
+// FinishLogin verifies an assertion for a ceremony started by BeginLogin and
+// returns the user it belongs to. userID must match what BeginLogin was
+// given.
+func (s *WebAuthnService) FinishLogin(ctx context.Context, userID string, response []byte) (models.User, error) {
+	if s.rp == nil {
+		return models.User{}, ErrPasskeysDisabled
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return models.User{}, ErrInvalidPasskey
+	}
+	session, err := s.takeCeremony(ctx, "login", parsed.Response.CollectedClientData.Challenge)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	var (
+		waUser     webAuthnUser
+		credential *webauthn.Credential
+	)
+	if userID == "" {
+		credential, err = s.rp.ValidateDiscoverableLogin(func(_, userHandle []byte) (webauthn.User, error) {
+			loaded, loadErr := s.loadUser(ctx, string(userHandle))
+			if loadErr != nil {
+				return nil, loadErr
+			}
+			waUser = loaded
+			return loaded, nil
+		}, session, parsed)
+	} else {
+		waUser, err = s.loadUser(ctx, userID)
+		if err == nil {
+			credential, err = s.rp.ValidateLogin(waUser, session, parsed)
+		}
+	}
+	if err != nil {
+		s.log.Debug().Err(err).Str("user_id", userID).Msg("passkey assertion rejected")
+		return models.User{}, ErrInvalidPasskey
+	}
+
+	stored, ok := waUser.find(credential.ID)
+	if !ok {
+		return models.User{}, ErrInvalidPasskey
+	}
+	cloned := credential.Authenticator.CloneWarning
+	if err := s.creds.RecordUse(ctx, stored.ID, credential.Authenticator.SignCount, credential.Flags.BackupState, cloned); err != nil {
+		return models.User{}, err
+	}
+	if cloned {
+		s.log.Warn().
+			Str("event", "passkey_clone_warning").
+			Str("user_id", waUser.user.ID).
+			Str("passkey_id", stored.ID).
+			Msg("passkey sign count did not advance")
+		return models.User{}, ErrInvalidPasskey
+	}
+
+	return waUser.user, nil
+}
+
+func (s *WebAuthnService) loadUser(ctx context.Context, userID string) (webAuthnUser, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return webAuthnUser{}, err
+	}
+	creds, err := s.creds.ListByUser(ctx, userID)
+	if err != nil {
+		return webAuthnUser{}, err
+	}
+	return webAuthnUser{user: user, creds: creds}, nil
+}
+
+// saveCeremony keeps the session data in Redis keyed by its challenge, which
+// the browser echoes back in clientDataJSON.
+func (s *WebAuthnService) saveCeremony(ctx context.Context, kind string, session *webauthn.SessionData) error {
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return s.cache.Set(ctx, ceremonyKey(kind, session.Challenge), payload, s.cfg.Security.WebAuthn.ChallengeTTL).Err()
+}
+
+// takeCeremony loads and deletes the session data, so each challenge can be
+// answered once.
+func (s *WebAuthnService) takeCeremony(ctx context.Context, kind string, challenge string) (webauthn.SessionData, error) {
+	if challenge == "" {
+		return webauthn.SessionData{}, ErrInvalidPasskey
+	}
+	payload, err := s.cache.GetDel(ctx, ceremonyKey(kind, challenge)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return webauthn.SessionData{}, ErrInvalidPasskey
+		}
+		return webauthn.SessionData{}, err
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal(payload, &session); err != nil {
+		return webauthn.SessionData{}, fmt.Errorf("decode webauthn session: %w", err)
+	}
+	return session, nil
+}
+
+func ceremonyKey(kind string, challenge string) string {
+	return "webauthn:" + kind + ":" + challenge
+}
@@ -0,0 +1,519 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+
+	"nodeimage/api/internal/config"
+	"nodeimage/api/internal/models"
+	"nodeimage/api/internal/repository"
+)
+
+const (
+	testRPID   = "example.test"
+	testOrigin = "https://example.test"
+)
+
+type recordedUse struct {
+	id        string
+	signCount uint32
+	cloned    bool
+}
+
+type memoryCredentials struct {
+	creds []models.WebAuthnCredential
+	uses  []recordedUse
+}
+
+func (m *memoryCredentials) Create(ctx context.Context, cred models.WebAuthnCredential) error {
+	m.creds = append(m.creds, cred)
+	return nil
+}
+
+func (m *memoryCredentials) ListByUser(ctx context.Context, userID string) ([]models.WebAuthnCredential, error) {
+	var out []models.WebAuthnCredential
+	for _, cred := range m.creds {
+		if cred.UserID == userID {
+			out = append(out, cred)
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryCredentials) RecordUse(ctx context.Context, id string, signCount uint32, backupState bool, cloneWarning bool) error {
+	m.uses = append(m.uses, recordedUse{id: id, signCount: signCount, cloned: cloneWarning})
+	for i := range m.creds {
+		if m.creds[i].ID == id && !cloneWarning {
+			m.creds[i].SignCount = signCount
+		}
+	}
+	return nil
+}
+
+func (m *memoryCredentials) Delete(ctx context.Context, userID string, id string) error {
+	for i, cred := range m.creds {
+		if cred.ID == id && cred.UserID == userID {
+			m.creds = append(m.creds[:i], m.creds[i+1:]...)
+			return nil
+		}
+	}
+	return repository.ErrWebAuthnCredentialNotFound
+}
+
+type fixedMFA bool
+
+func (m fixedMFA) Enabled(ctx context.Context, userID string) (bool, error) {
+	return bool(m), nil
+}
+
+type webAuthnFixture struct {
+	svc   *WebAuthnService
+	creds *memoryCredentials
+	users *memoryUsers
+	redis *miniredis.Miniredis
+}
+
+func newWebAuthnFixture(t *testing.T, totpEnabled bool, users ...models.User) *webAuthnFixture {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	cfg := &config.AppConfig{}
+	cfg.Security.WebAuthn = config.WebAuthnConfig{
+		RPID:           testRPID,
+		RPDisplayName:  "Test",
+		RPOrigins:      []string{testOrigin},
+		ChallengeTTL:   time.Minute,
+		MaxCredentials: 2,
+	}
+	svc := NewWebAuthnService(nil, nil, nil, redis.NewClient(&redis.Options{Addr: mr.Addr()}), cfg, zerolog.Nop())
+	if svc.rp == nil {
+		t.Fatal("relying party not configured")
+	}
+
+	f := &webAuthnFixture{
+		svc:   svc,
+		creds: &memoryCredentials{},
+		users: &memoryUsers{byID: map[string]models.User{}},
+		redis: mr,
+	}
+	for _, user := range users {
+		f.users.byID[user.ID] = user
+	}
+	svc.creds, svc.users, svc.mfa = f.creds, f.users, fixedMFA(totpEnabled)
+	return f
+}
+
+// softAuthenticator is an ES256 platform authenticator that answers
+// ceremonies without attestation.
+type softAuthenticator struct {
+	key *ecdsa.PrivateKey
+	id  []byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{key: key, id: id}
+}
+
+func (a *softAuthenticator) publicKey(t *testing.T) []byte {
+	t.Helper()
+	cose, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cose
+}
+
+// credential is the stored form of this authenticator's passkey.
+func (a *softAuthenticator) credential(t *testing.T, userID string, signCount uint32) models.WebAuthnCredential {
+	return models.WebAuthnCredential{
+		ID:           "cred-" + userID,
+		UserID:       userID,
+		CredentialID: a.id,
+		PublicKey:    a.publicKey(t),
+		SignCount:    signCount,
+	}
+}
+
+func clientData(t *testing.T, kind string, challenge string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{"type": kind, "challenge": challenge, "origin": testOrigin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// authData builds authenticator data with the user present and verified.
+func authData(signCount uint32, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	flags := byte(0x01 | 0x04)
+	if attested != nil {
+		flags |= 0x40
+	}
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, signCount)
+	return append(data, attested...)
+}
+
+var b64 = base64.RawURLEncoding.EncodeToString
+
+// create answers a registration ceremony.
+func (a *softAuthenticator) create(t *testing.T, challenge string) []byte {
+	t.Helper()
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.id)))
+	attested = append(attested, a.id...)
+	attested = append(attested, a.publicKey(t)...)
+	object, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData(0, attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(map[string]any{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64(clientData(t, "webauthn.create", challenge)),
+			"attestationObject": b64(object),
+			"transports":        []string{"internal"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// assert answers a login ceremony as the passkey of userID.
+func (a *softAuthenticator) assert(t *testing.T, challenge string, userID string, signCount uint32) []byte {
+	t.Helper()
+	client := clientData(t, "webauthn.get", challenge)
+	auth := authData(signCount, nil)
+	clientHash := sha256.Sum256(client)
+	digest := sha256.Sum256(append(append([]byte{}, auth...), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(map[string]any{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64(client),
+			"authenticatorData": b64(auth),
+			"signature":         b64(signature),
+			"userHandle":        b64([]byte(userID)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func activeUser(id string) models.User {
+	return models.User{ID: id, Email: id + "@example.com", Role: models.UserRoleUser, Status: models.UserStatusActive}
+}
+
+func TestWebAuthnCeremonyIsSingleUse(t *testing.T) {
+	f := newWebAuthnFixture(t, false)
+	ctx := context.Background()
+
+	if err := f.svc.saveCeremony(ctx, "login", &webauthn.SessionData{Challenge: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	// A login challenge cannot complete a registration, and trying does not
+	// consume it.
+	if _, err := f.svc.takeCeremony(ctx, "register", "abc"); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("register take err = %v, want ErrInvalidPasskey", err)
+	}
+	session, err := f.svc.takeCeremony(ctx, "login", "abc")
+	if err != nil || session.Challenge != "abc" {
+		t.Fatalf("login take = %+v, %v", session, err)
+	}
+	if _, err := f.svc.takeCeremony(ctx, "login", "abc"); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("second take err = %v, want ErrInvalidPasskey", err)
+	}
+	if _, err := f.svc.takeCeremony(ctx, "login", ""); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("empty challenge err = %v, want ErrInvalidPasskey", err)
+	}
+
+	if err := f.svc.saveCeremony(ctx, "login", &webauthn.SessionData{Challenge: "late"}); err != nil {
+		t.Fatal(err)
+	}
+	f.redis.FastForward(2 * time.Minute)
+	if _, err := f.svc.takeCeremony(ctx, "login", "late"); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("expired take err = %v, want ErrInvalidPasskey", err)
+	}
+}
+
+func TestWebAuthnBeginRegistration(t *testing.T) {
+	user := activeUser("u1")
+	tests := []struct {
+		name        string
+		totpEnabled bool
+		sessionMFA  bool
+		existing    int
+		wantErr     error
+	}{
+		{name: "no totp", existing: 0},
+		{name: "totp on password-only session", totpEnabled: true, wantErr: ErrMFARequired},
+		{name: "totp on mfa session", totpEnabled: true, sessionMFA: true},
+		{name: "below limit", existing: 1},
+		{name: "at limit", existing: 2, wantErr: ErrPasskeyLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newWebAuthnFixture(t, tt.totpEnabled, user)
+			for i := 0; i < tt.existing; i++ {
+				cred := newSoftAuthenticator(t).credential(t, user.ID, 0)
+				cred.ID = cred.ID + string(rune('a'+i))
+				f.creds.creds = append(f.creds.creds, cred)
+			}
+
+			creation, err := f.svc.BeginRegistration(context.Background(), user, tt.sessionMFA)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				if len(f.redis.Keys()) != 0 {
+					t.Fatalf("ceremony stored on rejection: %v", f.redis.Keys())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(creation.Response.CredentialExcludeList); got != tt.existing {
+				t.Fatalf("excluded %d credentials, want %d", got, tt.existing)
+			}
+			challenge := creation.Response.Challenge.String()
+			if !f.redis.Exists(ceremonyKey("register", challenge)) || f.redis.Exists(ceremonyKey("login", challenge)) {
+				t.Fatalf("ceremony keys = %v", f.redis.Keys())
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		f := newWebAuthnFixture(t, false, user)
+		f.svc.rp = nil
+		if _, err := f.svc.BeginRegistration(context.Background(), user, true); !errors.Is(err, ErrPasskeysDisabled) {
+			t.Fatalf("err = %v, want ErrPasskeysDisabled", err)
+		}
+	})
+}
+
+func TestWebAuthnRegisterAndLogin(t *testing.T) {
+	user := activeUser("u1")
+	f := newWebAuthnFixture(t, false, user)
+	ctx := context.Background()
+	authenticator := newSoftAuthenticator(t)
+
+	creation, err := f.svc.BeginRegistration(ctx, user, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := authenticator.create(t, creation.Response.Challenge.String())
+	cred, err := f.svc.FinishRegistration(ctx, user, "", response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.Name != "Passkey" || len(f.creds.creds) != 1 {
+		t.Fatalf("stored %+v", f.creds.creds)
+	}
+	// The registration challenge was consumed.
+	if _, err := f.svc.FinishRegistration(ctx, user, "", response); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("replayed registration err = %v, want ErrInvalidPasskey", err)
+	}
+
+	assertion, err := f.svc.BeginLogin(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := assertion.Response.Challenge.String()
+	// A login challenge cannot finish a registration.
+	if _, err := f.svc.FinishRegistration(ctx, user, "", authenticator.create(t, challenge)); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("cross-ceremony err = %v, want ErrInvalidPasskey", err)
+	}
+	got, err := f.svc.FinishLogin(ctx, "", authenticator.assert(t, challenge, user.ID, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != user.ID {
+		t.Fatalf("logged in as %q", got.ID)
+	}
+}
+
+func TestWebAuthnRegistrationLimitAtFinish(t *testing.T) {
+	user := activeUser("u1")
+	f := newWebAuthnFixture(t, false, user)
+	ctx := context.Background()
+
+	creation, err := f.svc.BeginRegistration(ctx, user, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Passkeys added elsewhere while this ceremony was open still count.
+	for i := 0; i < 2; i++ {
+		cred := newSoftAuthenticator(t).credential(t, user.ID, 0)
+		cred.ID = cred.ID + string(rune('a'+i))
+		f.creds.creds = append(f.creds.creds, cred)
+	}
+	response := newSoftAuthenticator(t).create(t, creation.Response.Challenge.String())
+	if _, err := f.svc.FinishRegistration(ctx, user, "", response); !errors.Is(err, ErrPasskeyLimit) {
+		t.Fatalf("err = %v, want ErrPasskeyLimit", err)
+	}
+}
+
+func TestWebAuthnFinishLoginCounters(t *testing.T) {
+	tests := []struct {
+		name       string
+		stored     uint32
+		asserted   uint32
+		wantCloned bool
+	}{
+		{name: "counter advances", stored: 4, asserted: 5},
+		{name: "counter unused", stored: 0, asserted: 0},
+		{name: "counter repeats", stored: 5, asserted: 5, wantCloned: true},
+		{name: "counter goes back", stored: 5, asserted: 2, wantCloned: true},
+		{name: "counter reset to zero", stored: 5, asserted: 0, wantCloned: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := activeUser("u1")
+			f := newWebAuthnFixture(t, false, user)
+			ctx := context.Background()
+			authenticator := newSoftAuthenticator(t)
+			f.creds.creds = append(f.creds.creds, authenticator.credential(t, user.ID, tt.stored))
+
+			assertion, err := f.svc.BeginLogin(ctx, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			response := authenticator.assert(t, assertion.Response.Challenge.String(), user.ID, tt.asserted)
+			_, err = f.svc.FinishLogin(ctx, "", response)
+			if tt.wantCloned {
+				if !errors.Is(err, ErrInvalidPasskey) {
+					t.Fatalf("err = %v, want ErrInvalidPasskey", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			want := []recordedUse{{id: "cred-" + user.ID, signCount: tt.asserted, cloned: tt.wantCloned}}
+			if tt.wantCloned {
+				want[0].signCount = tt.stored
+			}
+			if len(f.creds.uses) != 1 || f.creds.uses[0] != want[0] {
+				t.Fatalf("uses = %+v, want %+v", f.creds.uses, want)
+			}
+
+			// The challenge is spent either way.
+			if _, err := f.svc.FinishLogin(ctx, "", response); !errors.Is(err, ErrInvalidPasskey) {
+				t.Fatalf("replay err = %v, want ErrInvalidPasskey", err)
+			}
+		})
+	}
+}
+
+func TestWebAuthnFinishLoginSecondFactor(t *testing.T) {
+	user := activeUser("u1")
+	other := activeUser("u2")
+	f := newWebAuthnFixture(t, true, user, other)
+	ctx := context.Background()
+	mine := newSoftAuthenticator(t)
+	theirs := newSoftAuthenticator(t)
+	f.creds.creds = append(f.creds.creds, mine.credential(t, user.ID, 0), theirs.credential(t, other.ID, 0))
+
+	assertion, err := f.svc.BeginLogin(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another user's passkey cannot answer this user's challenge.
+	if _, err := f.svc.FinishLogin(ctx, user.ID, theirs.assert(t, assertion.Response.Challenge.String(), other.ID, 1)); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("err = %v, want ErrInvalidPasskey", err)
+	}
+
+	assertion, err = f.svc.BeginLogin(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := f.svc.FinishLogin(ctx, user.ID, mine.assert(t, assertion.Response.Challenge.String(), user.ID, 1))
+	if err != nil || got.ID != user.ID {
+		t.Fatalf("FinishLogin = %q, %v", got.ID, err)
+	}
+
+	if _, err := f.svc.BeginLogin(ctx, "missing"); err == nil {
+		t.Fatal("BeginLogin accepted an unknown user")
+	}
+}
+
+func TestLoginPasskeyRejectsInactiveUsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  models.UserStatus
+		wantErr error
+	}{
+		{name: "pending", status: models.UserStatusPending, wantErr: ErrEmailNotVerified},
+		{name: "suspended", status: models.UserStatusSuspended, wantErr: ErrUserSuspended},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := activeUser("u1")
+			user.Status = tt.status
+			f := newWebAuthnFixture(t, false, user)
+			ctx := context.Background()
+			authenticator := newSoftAuthenticator(t)
+			f.creds.creds = append(f.creds.creds, authenticator.credential(t, user.ID, 0))
+			auth := &AuthService{passkeys: f.svc}
+
+			assertion, err := f.svc.BeginLogin(ctx, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = auth.LoginPasskey(ctx, PasskeyLoginInput{
+				Credential: authenticator.assert(t, assertion.Response.Challenge.String(), user.ID, 1),
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"os"
 	"os/signal"
 	"syscall"
 	"time"
@@ -12,6 +11,7 @@ import (
 	"nodeimage/worker/internal/config"
 	"nodeimage/worker/internal/log"
 	"nodeimage/worker/internal/queue"
+	"nodeimage/worker/internal/storage"
 	"nodeimage/worker/internal/tasks"
 )
 
@@ -33,7 +33,12 @@ func main() {
 	}
 	defer client.Close()
 
-	processor := tasks.NewProcessor(logger)
+	store, err := storage.NewObjectStore(cfg.Storage)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to init object store")
+	}
+
+	processor := tasks.NewProcessor(logger, store, client, cfg)
 	consumer := queue.NewConsumer(
 		client,
 		cfg.Redis.Stream,
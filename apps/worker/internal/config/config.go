@@ -13,6 +13,8 @@ type Config struct {
 	Redis       RedisConfig
 	Storage     StorageConfig
 	Queues      QueueConfig
+	Limits      LimitsConfig
+	Raster      RasterConfig
 	Logging     LoggingConfig
 }
 
@@ -23,6 +25,8 @@ type RedisConfig struct {
 	Stream   string
 	Group    string
 	Consumer string
+	// StatusStream carries image status updates back to the API.
+	StatusStream string
 }
 
 type StorageConfig struct {
@@ -40,6 +44,21 @@ type QueueConfig struct {
 	ClaimInterval     time.Duration
 }
 
+type LimitsConfig struct {
+	MaxObjectBytes int64
+	MaxWidth       int
+	MaxHeight      int
+	MaxPixels      int64
+	MaxFrames      int
+}
+
+// RasterConfig controls SVG rendering. Presets map a variant name to the
+// length in pixels of its longer edge.
+type RasterConfig struct {
+	Presets   map[string]int
+	MaxPixels int64
+}
+
 type LoggingConfig struct {
 	Level string
 }
@@ -81,9 +100,19 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("redis.stream", "media:ingest")
 	v.SetDefault("redis.group", "media-workers")
 	v.SetDefault("redis.consumer", "worker-1")
+	v.SetDefault("redis.statusstream", "media:status")
 
 	v.SetDefault("queues.visibilitytimeout", "2m")
 	v.SetDefault("queues.claiminterval", "10s")
 
+	v.SetDefault("limits.maxobjectbytes", 100<<20)
+	v.SetDefault("limits.maxwidth", 16384)
+	v.SetDefault("limits.maxheight", 16384)
+	v.SetDefault("limits.maxpixels", 100_000_000)
+	v.SetDefault("limits.maxframes", 1000)
+
+	v.SetDefault("raster.presets", map[string]int{"sm": 320, "md": 640, "lg": 1280, "hero": 1920})
+	v.SetDefault("raster.maxpixels", 16<<20)
+
 	v.SetDefault("logging.level", "info")
 }
@@ -0,0 +1,78 @@
+package probe
+
+import (
+	"encoding/binary"
+)
+
+type bmffBox struct {
+	kind     string
+	contents int
+	end      int
+}
+
+// readBoxes lists the ISO-BMFF boxes in data[start:end]. It stops at the
+// first box whose header or size does not fit, so truncated input yields the
+// boxes that could be read. It mirrors the sniffer package in the API, which
+// is the source of truth; keep the two in step.
+func readBoxes(data []byte, start, end int) []bmffBox {
+	var boxes []bmffBox
+	pos := start
+	for pos+8 <= end {
+		size := uint64(binary.BigEndian.Uint32(data[pos : pos+4]))
+		kind := string(data[pos+4 : pos+8])
+		header := 8
+		switch size {
+		case 0:
+			size = uint64(end - pos)
+		case 1:
+			if pos+16 > end {
+				return boxes
+			}
+			size = binary.BigEndian.Uint64(data[pos+8 : pos+16])
+			header = 16
+		}
+		if size < uint64(header) || size > uint64(end-pos) {
+			return boxes
+		}
+		boxes = append(boxes, bmffBox{kind: kind, contents: pos + header, end: pos + int(size)})
+		pos += int(size)
+	}
+	return boxes
+}
+
+func findBox(boxes []bmffBox, kind string) (bmffBox, bool) {
+	for _, box := range boxes {
+		if box.kind == kind {
+			return box, true
+		}
+	}
+	return bmffBox{}, false
+}
+
+// findPath descends through nested boxes by type. fullBoxes lists the types
+// whose contents start with a 4-byte version/flags header.
+func findPath(data []byte, boxes []bmffBox, path ...string) (bmffBox, bool) {
+	var box bmffBox
+	for i, kind := range path {
+		var ok bool
+		box, ok = findBox(boxes, kind)
+		if !ok {
+			return bmffBox{}, false
+		}
+		if i < len(path)-1 {
+			start := box.contents
+			if _, full := fullBoxes[kind]; full {
+				start += 4
+			}
+			if start > box.end {
+				return bmffBox{}, false
+			}
+			boxes = readBoxes(data, start, box.end)
+		}
+	}
+	return box, true
+}
+
+var fullBoxes = map[string]struct{}{
+	"meta": {},
+}
@@ -0,0 +1,495 @@
+// Package probe reads image dimensions from file headers without decoding.
+//
+// The header parsers here mirror apps/api/internal/media/sniffer
+// (dimensions.go and bmff.go), which is the source of truth: the API and
+// worker are separate modules and cannot share an internal package. Fix
+// parsing bugs there first and port the change here.
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownFormat   = errors.New("unknown image format")
+	ErrMalformedHeader = errors.New("malformed image header")
+	ErrLimitExceeded   = errors.New("image exceeds limits")
+)
+
+type Dimensions struct {
+	Width  int
+	Height int
+	Frames int
+}
+
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int64
+	MaxFrames int
+}
+
+// Check reports ErrLimitExceeded if any limit is set and exceeded. Zero
+// limits are treated as unlimited.
+func (l Limits) Check(d Dimensions) error {
+	if l.MaxWidth > 0 && d.Width > l.MaxWidth {
+		return fmt.Errorf("%w: width %d > %d", ErrLimitExceeded, d.Width, l.MaxWidth)
+	}
+	if l.MaxHeight > 0 && d.Height > l.MaxHeight {
+		return fmt.Errorf("%w: height %d > %d", ErrLimitExceeded, d.Height, l.MaxHeight)
+	}
+	if pixels := int64(d.Width) * int64(d.Height); l.MaxPixels > 0 && pixels > l.MaxPixels {
+		return fmt.Errorf("%w: %d pixels > %d", ErrLimitExceeded, pixels, l.MaxPixels)
+	}
+	if l.MaxFrames > 0 && d.Frames > l.MaxFrames {
+		return fmt.Errorf("%w: %d frames > %d", ErrLimitExceeded, d.Frames, l.MaxFrames)
+	}
+	return nil
+}
+
+// ParseDimensions reads the canvas size and frame count from the container
+// headers without decoding pixel data. format is the value the API stores in
+// images.format. This mirrors sniffer.ParseDimensions in the API so both
+// sides enforce the same limits.
+func ParseDimensions(format string, data []byte) (Dimensions, error) {
+	switch format {
+	case "jpeg":
+		return jpegDimensions(data)
+	case "png", "apng":
+		return pngDimensions(data)
+	case "gif":
+		return gifDimensions(data)
+	case "webp":
+		return webpDimensions(data)
+	case "avif", "heic", "heif":
+		return avifDimensions(data)
+	case "bmp":
+		return bmpDimensions(data)
+	case "tiff":
+		return tiffDimensions(data)
+	case "ico":
+		return icoDimensions(data)
+	case "jxl":
+		return jxlDimensions(data)
+	case "svg":
+		return Dimensions{Frames: 1}, nil
+	default:
+		return Dimensions{}, ErrUnknownFormat
+	}
+}
+
+func jpegDimensions(data []byte) (Dimensions, error) {
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xff {
+			return Dimensions{}, fmt.Errorf("%w: expected jpeg marker", ErrMalformedHeader)
+		}
+		marker := data[pos+1]
+		if marker == 0xff {
+			pos++
+			continue
+		}
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			pos += 2
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if length < 2 {
+			return Dimensions{}, fmt.Errorf("%w: bad jpeg segment length", ErrMalformedHeader)
+		}
+		if isJPEGSOF(marker) {
+			if pos+9 > len(data) {
+				break
+			}
+			return Dimensions{
+				Height: int(binary.BigEndian.Uint16(data[pos+5 : pos+7])),
+				Width:  int(binary.BigEndian.Uint16(data[pos+7 : pos+9])),
+				Frames: 1,
+			}, nil
+		}
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		pos += 2 + length
+	}
+	return Dimensions{}, fmt.Errorf("%w: jpeg SOF not found", ErrMalformedHeader)
+}
+
+func isJPEGSOF(marker byte) bool {
+	return marker >= 0xc0 && marker <= 0xcf &&
+		marker != 0xc4 && marker != 0xc8 && marker != 0xcc
+}
+
+func pngDimensions(data []byte) (Dimensions, error) {
+	if len(data) < 24 || string(data[12:16]) != "IHDR" {
+		return Dimensions{}, fmt.Errorf("%w: png IHDR missing", ErrMalformedHeader)
+	}
+	d := Dimensions{
+		Width:  int(binary.BigEndian.Uint32(data[16:20])),
+		Height: int(binary.BigEndian.Uint32(data[20:24])),
+		Frames: 1,
+	}
+
+	pos := 8
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		kind := string(data[pos+4 : pos+8])
+		if kind == "IDAT" || kind == "IEND" {
+			break
+		}
+		if kind == "acTL" && length >= 8 && pos+16 <= len(data) {
+			d.Frames = int(binary.BigEndian.Uint32(data[pos+8 : pos+12]))
+			break
+		}
+		if length < 0 || pos+12+length > len(data) {
+			break
+		}
+		pos += 12 + length
+	}
+	return d, nil
+}
+
+func gifDimensions(data []byte) (Dimensions, error) {
+	if len(data) < 13 {
+		return Dimensions{}, fmt.Errorf("%w: gif header truncated", ErrMalformedHeader)
+	}
+	d := Dimensions{
+		Width:  int(binary.LittleEndian.Uint16(data[6:8])),
+		Height: int(binary.LittleEndian.Uint16(data[8:10])),
+	}
+
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << ((flags & 0x07) + 1)
+	}
+
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x2c:
+			if pos+10 > len(data) {
+				return d, nil
+			}
+			d.Frames++
+			width := int(binary.LittleEndian.Uint16(data[pos+5:pos+7])) + int(binary.LittleEndian.Uint16(data[pos+1:pos+3]))
+			height := int(binary.LittleEndian.Uint16(data[pos+7:pos+9])) + int(binary.LittleEndian.Uint16(data[pos+3:pos+5]))
+			d.Width = max(d.Width, width)
+			d.Height = max(d.Height, height)
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << ((flags & 0x07) + 1)
+			}
+			pos++ // LZW minimum code size
+			pos = skipGIFSubBlocks(data, pos)
+		case 0x21:
+			pos = skipGIFSubBlocks(data, pos+2)
+		default:
+			// Trailer (0x3b) or an unknown block: nothing further to count.
+			return withMinFrames(d), nil
+		}
+	}
+	return withMinFrames(d), nil
+}
+
+func skipGIFSubBlocks(data []byte, pos int) int {
+	for pos < len(data) {
+		size := int(data[pos])
+		pos++
+		if size == 0 {
+			return pos
+		}
+		pos += size
+	}
+	return pos
+}
+
+func withMinFrames(d Dimensions) Dimensions {
+	if d.Frames == 0 {
+		d.Frames = 1
+	}
+	return d
+}
+
+func webpDimensions(data []byte) (Dimensions, error) {
+	var d Dimensions
+	pos := 12
+	for pos+8 <= len(data) {
+		fourCC := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		payload := data[pos+8 : min(len(data), pos+8+size)]
+
+		switch fourCC {
+		case "VP8X":
+			if len(payload) < 10 {
+				return Dimensions{}, fmt.Errorf("%w: webp VP8X truncated", ErrMalformedHeader)
+			}
+			d.Width = int(uint24LE(payload[4:7])) + 1
+			d.Height = int(uint24LE(payload[7:10])) + 1
+		case "VP8 ":
+			if d.Width == 0 {
+				if len(payload) < 10 || !bytes.Equal(payload[3:6], []byte{0x9d, 0x01, 0x2a}) {
+					return Dimensions{}, fmt.Errorf("%w: webp VP8 frame header invalid", ErrMalformedHeader)
+				}
+				d.Width = int(binary.LittleEndian.Uint16(payload[6:8]) & 0x3fff)
+				d.Height = int(binary.LittleEndian.Uint16(payload[8:10]) & 0x3fff)
+			}
+		case "VP8L":
+			if d.Width == 0 {
+				if len(payload) < 5 || payload[0] != 0x2f {
+					return Dimensions{}, fmt.Errorf("%w: webp VP8L header invalid", ErrMalformedHeader)
+				}
+				bits := binary.LittleEndian.Uint32(payload[1:5])
+				d.Width = int(bits&0x3fff) + 1
+				d.Height = int(bits>>14&0x3fff) + 1
+			}
+		case "ANMF":
+			d.Frames++
+		}
+		pos += 8 + size + size%2
+	}
+
+	if d.Width == 0 || d.Height == 0 {
+		return Dimensions{}, fmt.Errorf("%w: webp image chunk missing", ErrMalformedHeader)
+	}
+	return withMinFrames(d), nil
+}
+
+func uint24LE(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func avifDimensions(data []byte) (Dimensions, error) {
+	boxes := readBoxes(data, 0, len(data))
+	d := Dimensions{Frames: 1}
+
+	if ipco, ok := findPath(data, boxes, "meta", "iprp", "ipco"); ok {
+		for _, prop := range readBoxes(data, ipco.contents, ipco.end) {
+			if prop.kind != "ispe" || prop.contents+12 > prop.end {
+				continue
+			}
+			d.Width = max(d.Width, int(binary.BigEndian.Uint32(data[prop.contents+4:prop.contents+8])))
+			d.Height = max(d.Height, int(binary.BigEndian.Uint32(data[prop.contents+8:prop.contents+12])))
+		}
+	}
+
+	if moov, ok := findBox(boxes, "moov"); ok {
+		for _, trak := range readBoxes(data, moov.contents, moov.end) {
+			if trak.kind != "trak" {
+				continue
+			}
+			children := readBoxes(data, trak.contents, trak.end)
+			if tkhd, ok := findBox(children, "tkhd"); ok {
+				width, height := trackDimensions(data, tkhd)
+				d.Width = max(d.Width, width)
+				d.Height = max(d.Height, height)
+			}
+			if stsz, ok := findPath(data, children, "mdia", "minf", "stbl", "stsz"); ok && stsz.contents+12 <= stsz.end {
+				d.Frames = max(d.Frames, int(binary.BigEndian.Uint32(data[stsz.contents+8:stsz.contents+12])))
+			}
+		}
+	}
+
+	if d.Width == 0 || d.Height == 0 {
+		return Dimensions{}, fmt.Errorf("%w: ispe property missing", ErrMalformedHeader)
+	}
+	return d, nil
+}
+
+// trackDimensions reads the 16.16 fixed-point width and height at the end of
+// a tkhd box.
+func trackDimensions(data []byte, tkhd bmffBox) (int, int) {
+	if tkhd.end-tkhd.contents < 8 {
+		return 0, 0
+	}
+	width := binary.BigEndian.Uint32(data[tkhd.end-8 : tkhd.end-4])
+	height := binary.BigEndian.Uint32(data[tkhd.end-4 : tkhd.end])
+	return int(width >> 16), int(height >> 16)
+}
+
+func bmpDimensions(data []byte) (Dimensions, error) {
+	if len(data) < 26 {
+		return Dimensions{}, fmt.Errorf("%w: bmp header truncated", ErrMalformedHeader)
+	}
+	if binary.LittleEndian.Uint32(data[14:18]) == 12 {
+		return Dimensions{
+			Width:  int(binary.LittleEndian.Uint16(data[18:20])),
+			Height: int(binary.LittleEndian.Uint16(data[20:22])),
+			Frames: 1,
+		}, nil
+	}
+	width := int(int32(binary.LittleEndian.Uint32(data[18:22])))
+	height := int(int32(binary.LittleEndian.Uint32(data[22:26])))
+	if height < 0 {
+		// Negative height marks a top-down bitmap.
+		height = -height
+	}
+	if width <= 0 || height == 0 {
+		return Dimensions{}, fmt.Errorf("%w: bmp size invalid", ErrMalformedHeader)
+	}
+	return Dimensions{Width: width, Height: height, Frames: 1}, nil
+}
+
+const (
+	tiffTagImageWidth  = 256
+	tiffTagImageLength = 257
+	tiffMaxIFDs        = 10000
+)
+
+// tiffDimensions reports the largest page and counts pages by following the
+// IFD chain. BigTIFF is detected by the sniffer but its 64-bit IFDs are not
+// parsed here, so it is rejected as malformed.
+func tiffDimensions(data []byte) (Dimensions, error) {
+	if len(data) < 8 {
+		return Dimensions{}, fmt.Errorf("%w: tiff header truncated", ErrMalformedHeader)
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[0] == 'M' {
+		order = binary.BigEndian
+	}
+	if order.Uint16(data[2:4]) != 42 {
+		return Dimensions{}, fmt.Errorf("%w: bigtiff is not supported", ErrMalformedHeader)
+	}
+
+	var d Dimensions
+	seen := make(map[uint32]struct{})
+	offset := order.Uint32(data[4:8])
+	for offset != 0 {
+		if _, loop := seen[offset]; loop || len(seen) >= tiffMaxIFDs {
+			return Dimensions{}, fmt.Errorf("%w: tiff IFD chain loops", ErrMalformedHeader)
+		}
+		seen[offset] = struct{}{}
+
+		pos := int(offset)
+		if pos+2 > len(data) {
+			return Dimensions{}, fmt.Errorf("%w: tiff IFD out of range", ErrMalformedHeader)
+		}
+		count := int(order.Uint16(data[pos : pos+2]))
+		if pos+2+count*12+4 > len(data) {
+			return Dimensions{}, fmt.Errorf("%w: tiff IFD truncated", ErrMalformedHeader)
+		}
+		for i := 0; i < count; i++ {
+			entry := data[pos+2+i*12 : pos+14+i*12]
+			tag := order.Uint16(entry[0:2])
+			if tag != tiffTagImageWidth && tag != tiffTagImageLength {
+				continue
+			}
+			var value int
+			switch order.Uint16(entry[2:4]) {
+			case 3:
+				value = int(order.Uint16(entry[8:10]))
+			case 4:
+				value = int(order.Uint32(entry[8:12]))
+			}
+			if tag == tiffTagImageWidth {
+				d.Width = max(d.Width, value)
+			} else {
+				d.Height = max(d.Height, value)
+			}
+		}
+		d.Frames++
+		offset = order.Uint32(data[pos+2+count*12 : pos+2+count*12+4])
+	}
+
+	if d.Width == 0 || d.Height == 0 {
+		return Dimensions{}, fmt.Errorf("%w: tiff size tags missing", ErrMalformedHeader)
+	}
+	return d, nil
+}
+
+// icoDimensions reports the largest image in the directory; a stored width
+// or height of 0 means 256.
+func icoDimensions(data []byte) (Dimensions, error) {
+	if len(data) < 6 {
+		return Dimensions{}, fmt.Errorf("%w: ico header truncated", ErrMalformedHeader)
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:6]))
+	if count == 0 || 6+count*16 > len(data) {
+		return Dimensions{}, fmt.Errorf("%w: ico directory truncated", ErrMalformedHeader)
+	}
+	d := Dimensions{Frames: 1}
+	for i := 0; i < count; i++ {
+		entry := data[6+i*16 : 22+i*16]
+		width, height := int(entry[0]), int(entry[1])
+		if width == 0 {
+			width = 256
+		}
+		if height == 0 {
+			height = 256
+		}
+		d.Width = max(d.Width, width)
+		d.Height = max(d.Height, height)
+	}
+	return d, nil
+}
+
+var jxlContainerMagic = []byte{0x00, 0x00, 0x00, 0x0c, 'J', 'X', 'L', ' ', 0x0d, 0x0a, 0x87, 0x0a}
+
+var jxlRatios = [8][2]uint64{{0, 0}, {1, 1}, {12, 10}, {4, 3}, {3, 2}, {16, 9}, {5, 4}, {2, 1}}
+
+// jxlDimensions decodes the SizeHeader that follows the codestream
+// signature. In the ISOBMFF container the codestream starts inside the jxlc
+// box, or the first jxlp box after its 4-byte part index.
+func jxlDimensions(data []byte) (Dimensions, error) {
+	codestream := data
+	if bytes.HasPrefix(data, jxlContainerMagic) {
+		codestream = nil
+		for _, box := range readBoxes(data, 0, len(data)) {
+			if box.kind == "jxlc" {
+				codestream = data[box.contents:box.end]
+				break
+			}
+			if box.kind == "jxlp" && box.contents+4 <= box.end {
+				codestream = data[box.contents+4 : box.end]
+				break
+			}
+		}
+	}
+	if len(codestream) < 2 || codestream[0] != 0xff || codestream[1] != 0x0a {
+		return Dimensions{}, fmt.Errorf("%w: jxl codestream missing", ErrMalformedHeader)
+	}
+
+	r := bitReader{data: codestream[2:]}
+	size := func(div8 bool) uint64 {
+		if div8 {
+			return (1 + r.bits(5)) * 8
+		}
+		return 1 + r.bits([4]int{9, 13, 18, 30}[r.bits(2)])
+	}
+
+	div8 := r.bits(1) == 1
+	height := size(div8)
+	ratio := r.bits(3)
+	width := height * jxlRatios[ratio][0] / max(jxlRatios[ratio][1], 1)
+	if ratio == 0 {
+		width = size(div8)
+	}
+	if r.overrun {
+		return Dimensions{}, fmt.Errorf("%w: jxl size header truncated", ErrMalformedHeader)
+	}
+	return Dimensions{Width: int(width), Height: int(height), Frames: 1}, nil
+}
+
+// bitReader reads little-endian bit fields, least significant bit first, as
+// the JPEG XL codestream requires.
+type bitReader struct {
+	data    []byte
+	pos     int
+	overrun bool
+}
+
+func (r *bitReader) bits(n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		if r.pos/8 >= len(r.data) {
+			r.overrun = true
+			return 0
+		}
+		bit := (r.data[r.pos/8] >> (r.pos % 8)) & 1
+		v |= uint64(bit) << i
+		r.pos++
+	}
+	return v
+}
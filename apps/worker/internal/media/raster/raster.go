@@ -0,0 +1,213 @@
+package raster
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+var ErrInvalidSVG = errors.New("svg cannot be rendered")
+
+// Browsers fall back to 300x150 when an SVG declares neither a size nor a
+// viewBox; the same default keeps rendered variants consistent with them.
+const (
+	defaultWidth  = 300
+	defaultHeight = 150
+)
+
+// maxSide is the largest edge WebP can encode. It also bounds renders when
+// no pixel cap is configured.
+const maxSide = 16383
+
+// maxIntrinsic rejects documents whose declared size or viewBox is absurd;
+// no real drawing is a million CSS pixels across, and such sizes are only
+// useful for pushing the renderer into huge or degenerate canvases.
+const maxIntrinsic = 1 << 20
+
+type Document struct {
+	icon   *oksvg.SvgIcon
+	Width  float64
+	Height float64
+}
+
+// Parse reads an already sanitized SVG. Width and Height are the intrinsic
+// size in CSS pixels, falling back to the viewBox and then to the browser
+// default.
+func Parse(data []byte) (*Document, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.IgnoreErrorMode)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSVG, err)
+	}
+
+	width, height, viewBox, err := rootGeometry(data)
+	if err != nil {
+		return nil, err
+	}
+	if viewBox[2] > maxIntrinsic || viewBox[3] > maxIntrinsic {
+		return nil, fmt.Errorf("%w: viewBox %gx%g is too large", ErrInvalidSVG, viewBox[2], viewBox[3])
+	}
+	switch {
+	case width > 0 && height > 0:
+	case viewBox[2] > 0 && viewBox[3] > 0:
+		ratio := viewBox[2] / viewBox[3]
+		switch {
+		case width > 0:
+			height = width / ratio
+		case height > 0:
+			width = height * ratio
+		default:
+			width, height = viewBox[2], viewBox[3]
+		}
+	default:
+		if width <= 0 {
+			width = defaultWidth
+		}
+		if height <= 0 {
+			height = defaultHeight
+		}
+	}
+	if !(width <= maxIntrinsic && height <= maxIntrinsic) {
+		return nil, fmt.Errorf("%w: size %gx%g is too large", ErrInvalidSVG, width, height)
+	}
+
+	if viewBox[2] > 0 && viewBox[3] > 0 {
+		icon.ViewBox.X, icon.ViewBox.Y, icon.ViewBox.W, icon.ViewBox.H = viewBox[0], viewBox[1], viewBox[2], viewBox[3]
+	} else {
+		// Without a viewBox user units are CSS pixels.
+		icon.ViewBox.X, icon.ViewBox.Y, icon.ViewBox.W, icon.ViewBox.H = 0, 0, width, height
+	}
+	return &Document{icon: icon, Width: width, Height: height}, nil
+}
+
+// Fit scales the document so its longer edge is longEdge pixels, keeping the
+// aspect ratio and shrinking further if the result would exceed maxPixels or
+// maxSide. A longEdge of zero keeps the intrinsic size and a maxPixels of
+// zero only applies maxSide. Neither edge is ever less than one pixel, so
+// very thin documents give up their aspect ratio rather than the caps.
+func (d *Document) Fit(longEdge int, maxPixels int64) (int, int) {
+	scale := 1.0
+	if longEdge > 0 {
+		scale = float64(longEdge) / math.Max(d.Width, d.Height)
+	}
+	width, height := d.Width*scale, d.Height*scale
+	if pixels := width * height; maxPixels > 0 && pixels > float64(maxPixels) {
+		shrink := math.Sqrt(float64(maxPixels) / pixels)
+		width, height = width*shrink, height*shrink
+	}
+	if long := math.Max(width, height); long > maxSide {
+		width, height = width*maxSide/long, height*maxSide/long
+	}
+
+	w := min(maxSide, max(1, int(math.Floor(width))))
+	h := min(maxSide, max(1, int(math.Floor(height))))
+	// Raising a side to one pixel can push the total back over the cap.
+	if maxPixels > 0 && int64(w)*int64(h) > maxPixels {
+		if w >= h {
+			w = int(max(1, maxPixels/int64(h)))
+		} else {
+			h = int(max(1, maxPixels/int64(w)))
+		}
+	}
+	return w, h
+}
+
+// Render draws the document onto a transparent canvas. The renderer is a
+// third-party parser fed user content, so a panic is reported as an error.
+func (d *Document) Render(width, height int) (img *image.RGBA, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			img, err = nil, fmt.Errorf("%w: renderer panic: %v", ErrInvalidSVG, r)
+		}
+	}()
+
+	img = image.NewRGBA(image.Rect(0, 0, width, height))
+	scanner := rasterx.NewScannerGV(width, height, img, img.Bounds())
+	d.icon.SetTarget(0, 0, float64(width), float64(height))
+	d.icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+	return img, nil
+}
+
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodeWebP writes lossless WebP, the only mode a pure-Go encoder offers.
+func EncodeWebP(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := nativewebp.Encode(&buf, img, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func rootGeometry(data []byte) (float64, float64, [4]float64, error) {
+	var viewBox [4]float64
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return 0, 0, viewBox, fmt.Errorf("%w: root element not found", ErrInvalidSVG)
+		}
+		root, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		var width, height float64
+		for _, attr := range root.Attr {
+			switch attr.Name.Local {
+			case "width":
+				width = parseLength(attr.Value)
+			case "height":
+				height = parseLength(attr.Value)
+			case "viewBox":
+				fields := strings.FieldsFunc(attr.Value, func(r rune) bool { return r == ',' || r == ' ' })
+				if len(fields) != 4 {
+					continue
+				}
+				for i, field := range fields {
+					value, err := strconv.ParseFloat(field, 64)
+					if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+						return 0, 0, viewBox, fmt.Errorf("%w: invalid viewBox %q", ErrInvalidSVG, attr.Value)
+					}
+					viewBox[i] = value
+				}
+			}
+		}
+		return width, height, viewBox, nil
+	}
+}
+
+var lengthUnits = map[string]float64{
+	"": 1, "px": 1, "pt": 4.0 / 3, "pc": 16, "in": 96, "cm": 96 / 2.54, "mm": 96 / 25.4,
+}
+
+// parseLength converts absolute lengths to CSS pixels. Relative units such as
+// % and em have no meaning without a containing block and report zero.
+func parseLength(value string) float64 {
+	value = strings.TrimSpace(value)
+	number := strings.TrimRight(value, "abcdefghijklmnopqrstuvwxyz%")
+	factor, ok := lengthUnits[value[len(number):]]
+	if !ok {
+		return 0
+	}
+	length, err := strconv.ParseFloat(number, 64)
+	if err != nil || !(length > 0) || math.IsInf(length, 0) {
+		return 0
+	}
+	return length * factor
+}
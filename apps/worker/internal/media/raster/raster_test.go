@@ -0,0 +1,115 @@
+package raster
+
+import (
+	"errors"
+	"testing"
+)
+
+func svgDoc(attrs string) []byte {
+	return []byte(`<svg xmlns="http://www.w3.org/2000/svg" ` + attrs + `><rect width="10" height="10" fill="red"/></svg>`)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		attrs      string
+		wantWidth  float64
+		wantHeight float64
+		wantErr    bool
+	}{
+		{name: "width and height", attrs: `width="200" height="100"`, wantWidth: 200, wantHeight: 100},
+		{name: "absolute units", attrs: `width="1in" height="72pt"`, wantWidth: 96, wantHeight: 96},
+		{name: "viewBox only", attrs: `viewBox="0 0 40 20"`, wantWidth: 40, wantHeight: 20},
+		{name: "width and viewBox", attrs: `width="80" viewBox="0,0,40,20"`, wantWidth: 80, wantHeight: 40},
+		{name: "height and viewBox", attrs: `height="10" viewBox="0 0 40 20"`, wantWidth: 20, wantHeight: 10},
+		{name: "no size", attrs: ``, wantWidth: defaultWidth, wantHeight: defaultHeight},
+		{name: "relative units", attrs: `width="50%" height="2em"`, wantWidth: defaultWidth, wantHeight: defaultHeight},
+		{name: "NaN size", attrs: `width="NaN" height="NaN"`, wantWidth: defaultWidth, wantHeight: defaultHeight},
+		{name: "infinite size", attrs: `width="Inf" height="1e400"`, wantWidth: defaultWidth, wantHeight: defaultHeight},
+		{name: "size at limit", attrs: `width="1048576" height="1"`, wantWidth: maxIntrinsic, wantHeight: 1},
+		{name: "huge width", attrs: `width="1e12" height="1"`, wantErr: true},
+		{name: "huge viewBox", attrs: `viewBox="0 0 1e12 1"`, wantErr: true},
+		{name: "huge size derived from viewBox", attrs: `width="1000" viewBox="0 0 1 1000000"`, wantErr: true},
+		{name: "NaN viewBox", attrs: `viewBox="0 0 NaN 10"`, wantErr: true},
+		{name: "infinite viewBox", attrs: `viewBox="0 0 1e400 10"`, wantErr: true},
+		{name: "malformed viewBox", attrs: `viewBox="0 0 ten 10"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse(svgDoc(tt.attrs))
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSVG) {
+					t.Fatalf("err = %v, want ErrInvalidSVG", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc.Width != tt.wantWidth || doc.Height != tt.wantHeight {
+				t.Fatalf("size = %gx%g, want %gx%g", doc.Width, doc.Height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestFit(t *testing.T) {
+	const capPixels = 16 << 20
+	tests := []struct {
+		name       string
+		width      float64
+		height     float64
+		longEdge   int
+		maxPixels  int64
+		wantWidth  int
+		wantHeight int
+	}{
+		{name: "intrinsic size", width: 300, height: 150, maxPixels: capPixels, wantWidth: 300, wantHeight: 150},
+		{name: "scale up to long edge", width: 300, height: 150, longEdge: 600, maxPixels: capPixels, wantWidth: 600, wantHeight: 300},
+		{name: "scale down to long edge", width: 150, height: 300, longEdge: 64, maxPixels: capPixels, wantWidth: 32, wantHeight: 64},
+		{name: "exactly at pixel cap", width: 4096, height: 4096, maxPixels: capPixels, wantWidth: 4096, wantHeight: 4096},
+		{name: "pixel cap shrinks", width: 8192, height: 8192, maxPixels: capPixels, wantWidth: 4096, wantHeight: 4096},
+		{name: "extreme wide intrinsic", width: 1e12, height: 1, maxPixels: capPixels, wantWidth: maxSide, wantHeight: 1},
+		{name: "extreme tall with long edge", width: 1, height: 1e12, longEdge: 256, maxPixels: capPixels, wantWidth: 1, wantHeight: 256},
+		{name: "extreme wide with tiny cap", width: 1e6, height: 1, maxPixels: 10, wantWidth: 10, wantHeight: 1},
+		{name: "thin sides raised to one stay under cap", width: 1e6, height: 1e-3, maxPixels: 100, wantWidth: 100, wantHeight: 1},
+		{name: "zero cap keeps side limit", width: 20000, height: 10000, wantWidth: maxSide, wantHeight: 8191},
+		{name: "zero cap extreme ratio", width: 1e12, height: 1, wantWidth: maxSide, wantHeight: 1},
+		{name: "long edge past side limit", width: 10, height: 5, longEdge: 40000, wantWidth: maxSide, wantHeight: 8191},
+		{name: "tiny document", width: 0.2, height: 0.1, maxPixels: capPixels, wantWidth: 1, wantHeight: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &Document{Width: tt.width, Height: tt.height}
+			width, height := doc.Fit(tt.longEdge, tt.maxPixels)
+			if width != tt.wantWidth || height != tt.wantHeight {
+				t.Fatalf("Fit = %dx%d, want %dx%d", width, height, tt.wantWidth, tt.wantHeight)
+			}
+			if tt.maxPixels > 0 && int64(width)*int64(height) > tt.maxPixels {
+				t.Fatalf("Fit = %dx%d exceeds %d pixels", width, height, tt.maxPixels)
+			}
+		})
+	}
+}
+
+func TestRenderViewBoxOnly(t *testing.T) {
+	doc, err := Parse(svgDoc(`viewBox="0 0 20 10"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	width, height := doc.Fit(0, 16<<20)
+	img, err := doc.Render(width, height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != 20 || bounds.Dy() != 10 {
+		t.Fatalf("canvas = %v, want 20x10", bounds)
+	}
+	// The red square covers the left half of the viewBox.
+	if r, _, _, a := img.At(5, 5).RGBA(); r == 0 || a == 0 {
+		t.Fatal("square not drawn")
+	}
+	if _, _, _, a := img.At(15, 5).RGBA(); a != 0 {
+		t.Fatal("right half not transparent")
+	}
+}
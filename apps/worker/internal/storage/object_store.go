@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"nodeimage/worker/internal/config"
+)
+
+// ErrObjectTooLarge is returned by ReadObject when the object is larger than
+// the caller's cap.
+var ErrObjectTooLarge = errors.New("object too large")
+
+type ObjectStore struct {
+	client *minio.Client
+	cfg    config.StorageConfig
+}
+
+func NewObjectStore(cfg config.StorageConfig) (*ObjectStore, error) {
+	endpoint := cfg.Endpoint
+	useSSL := cfg.UseSSL
+
+	if strings.HasPrefix(endpoint, "http") {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("parse endpoint: %w", err)
+		}
+		endpoint = u.Host
+		useSSL = u.Scheme == "https"
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: useSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("init minio: %w", err)
+	}
+
+	return &ObjectStore{
+		client: client,
+		cfg:    cfg,
+	}, nil
+}
+
+func (s *ObjectStore) Client() *minio.Client {
+	return s.client
+}
+
+// ReadObject downloads an object, failing if it is larger than maxBytes.
+func (s *ObjectStore) ReadObject(ctx context.Context, bucket, key string, maxBytes int64) ([]byte, error) {
+	obj, err := s.client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get object: %w", err)
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(io.LimitReader(obj, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("read object: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("%w: %s/%s exceeds %d bytes", ErrObjectTooLarge, bucket, key, maxBytes)
+	}
+	return data, nil
+}
+
+func (s *ObjectStore) WriteObject(ctx context.Context, bucket, key string, data []byte, contentType string) error {
+	_, err := s.client.PutObject(ctx, bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return fmt.Errorf("put object: %w", err)
+	}
+	return nil
+}
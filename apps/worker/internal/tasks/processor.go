@@ -3,25 +3,51 @@ package tasks
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
+
+	"nodeimage/worker/internal/config"
+	"nodeimage/worker/internal/media/probe"
+	"nodeimage/worker/internal/storage"
 )
 
 type Processor struct {
 	logger zerolog.Logger
+	store  *storage.ObjectStore
+	redis  *redis.Client
+	cfg    *config.Config
 }
 
 type TaskPayload struct {
 	Type    string                 `json:"type"`
 	ImageID string                 `json:"imageId"`
+	Bucket  string                 `json:"bucket"`
+	Object  string                 `json:"object"`
+	Format  string                 `json:"format"`
+	Header  string                 `json:"header"`
 	Data    map[string]interface{} `json:"data"`
 }
 
-func NewProcessor(logger zerolog.Logger) *Processor {
+// ImageHeader is what the API read from the file headers at upload time.
+type ImageHeader struct {
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Frames    int    `json:"frames"`
+	BitDepth  int    `json:"bitDepth"`
+	ColorType string `json:"colorType"`
+	HasAlpha  bool   `json:"hasAlpha"`
+	Animated  bool   `json:"animated"`
+}
+
+func NewProcessor(logger zerolog.Logger, store *storage.ObjectStore, redisClient *redis.Client, cfg *config.Config) *Processor {
 	return &Processor{
 		logger: logger,
+		store:  store,
+		redis:  redisClient,
+		cfg:    cfg,
 	}
 }
 
@@ -55,10 +81,92 @@ func decodePayload(values map[string]interface{}, out *TaskPayload) error {
 }
 
 func (p *Processor) handleIngest(ctx context.Context, payload TaskPayload) error {
-	p.logger.Info().Str("image_id", payload.ImageID).Msg("ingest task received (stub)")
+	if payload.Bucket == "" || payload.Object == "" {
+		p.logger.Warn().Str("image_id", payload.ImageID).Msg("ingest task without object, skipping")
+		return nil
+	}
+
+	data, err := p.store.ReadObject(ctx, payload.Bucket, payload.Object, p.cfg.Limits.MaxObjectBytes)
+	if errors.Is(err, storage.ErrObjectTooLarge) {
+		p.logger.Warn().Err(err).Str("image_id", payload.ImageID).Msg("ingest rejected before read")
+		return p.markFailed(ctx, payload.ImageID, "object_too_large")
+	}
+	if err != nil {
+		return fmt.Errorf("fetch original: %w", err)
+	}
+
+	dimensions, err := p.checkLimits(payload.Format, data)
+	if err != nil {
+		// Retrying cannot make an oversized image acceptable, so the image is
+		// marked failed rather than the task returned for redelivery.
+		p.logger.Warn().Err(err).Str("image_id", payload.ImageID).Msg("ingest rejected before decode")
+		reason := "malformed_image"
+		if errors.Is(err, probe.ErrLimitExceeded) {
+			reason = "image_too_large"
+		}
+		return p.markFailed(ctx, payload.ImageID, reason)
+	}
+
+	var header ImageHeader
+	if payload.Header != "" {
+		if err := json.Unmarshal([]byte(payload.Header), &header); err != nil {
+			p.logger.Warn().Err(err).Str("image_id", payload.ImageID).Msg("ingest header unreadable, using probe")
+		}
+	}
+
+	p.logger.Info().
+		Str("image_id", payload.ImageID).
+		Int("width", dimensions.Width).
+		Int("height", dimensions.Height).
+		Int("frames", dimensions.Frames).
+		Int("bit_depth", header.BitDepth).
+		Str("color_type", header.ColorType).
+		Bool("keep_alpha", header.HasAlpha).
+		Bool("keep_animation", header.Animated || dimensions.Frames > 1).
+		Msg("ingest task received (stub)")
+
+	if payload.Format == "svg" {
+		return p.rasterizeSVG(ctx, payload.ImageID, data)
+	}
+	return nil
+}
+
+// markFailed tells the API that an image will never finish processing. The
+// task is acknowledged once the update is queued; if queuing fails the error
+// is returned so the task is retried.
+func (p *Processor) markFailed(ctx context.Context, imageID, reason string) error {
+	err := p.redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.cfg.Redis.StatusStream,
+		Values: map[string]interface{}{
+			"imageId": imageID,
+			"status":  "failed",
+			"reason":  reason,
+		},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("publish failed status: %w", err)
+	}
 	return nil
 }
 
+// checkLimits must run before any image is decoded; it only reads headers.
+func (p *Processor) checkLimits(format string, data []byte) (probe.Dimensions, error) {
+	dimensions, err := probe.ParseDimensions(format, data)
+	if err != nil {
+		return probe.Dimensions{}, err
+	}
+	limits := probe.Limits{
+		MaxWidth:  p.cfg.Limits.MaxWidth,
+		MaxHeight: p.cfg.Limits.MaxHeight,
+		MaxPixels: p.cfg.Limits.MaxPixels,
+		MaxFrames: p.cfg.Limits.MaxFrames,
+	}
+	if err := limits.Check(dimensions); err != nil {
+		return probe.Dimensions{}, err
+	}
+	return dimensions, nil
+}
+
 func (p *Processor) handleThumbnail(ctx context.Context, payload TaskPayload) error {
 	p.logger.Info().Str("image_id", payload.ImageID).Msg("thumbnail task stub")
 	return nil
@@ -0,0 +1,86 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+
+	"nodeimage/worker/internal/media/raster"
+)
+
+// originalVariant renders the SVG at its intrinsic size, so consumers have a
+// raster stand-in for the original that is safe to embed anywhere.
+const originalVariant = "original"
+
+type rasterVariant struct {
+	name     string
+	longEdge int
+}
+
+type rasterEncoding struct {
+	ext         string
+	contentType string
+	encode      func(image.Image) ([]byte, error)
+}
+
+var rasterEncodings = []rasterEncoding{
+	{ext: "png", contentType: "image/png", encode: raster.EncodePNG},
+	{ext: "webp", contentType: "image/webp", encode: raster.EncodeWebP},
+}
+
+// rasterizeSVG writes PNG and WebP renders of a sanitized SVG for every
+// preset to the variants bucket as {imageID}/{variant}.{ext}.
+func (p *Processor) rasterizeSVG(ctx context.Context, imageID string, data []byte) error {
+	doc, err := raster.Parse(data)
+	if err != nil {
+		// A document the renderer cannot read will not improve on retry.
+		p.logger.Warn().Err(err).Str("image_id", imageID).Msg("svg rasterization skipped")
+		return nil
+	}
+
+	for _, variant := range p.rasterVariants() {
+		width, height := doc.Fit(variant.longEdge, p.cfg.Raster.MaxPixels)
+		img, err := doc.Render(width, height)
+		if errors.Is(err, raster.ErrInvalidSVG) {
+			p.logger.Warn().Err(err).Str("image_id", imageID).Msg("svg rasterization failed")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, encoding := range rasterEncodings {
+			encoded, err := encoding.encode(img)
+			if err != nil {
+				return fmt.Errorf("encode %s %s: %w", variant.name, encoding.ext, err)
+			}
+			key := fmt.Sprintf("%s/%s.%s", imageID, variant.name, encoding.ext)
+			if err := p.store.WriteObject(ctx, p.cfg.Storage.BucketVariants, key, encoded, encoding.contentType); err != nil {
+				return fmt.Errorf("store %s: %w", key, err)
+			}
+		}
+
+		p.logger.Debug().
+			Str("image_id", imageID).
+			Str("variant", variant.name).
+			Int("width", width).
+			Int("height", height).
+			Msg("svg variant rendered")
+	}
+	return nil
+}
+
+// rasterVariants returns the configured presets from smallest to largest,
+// followed by the intrinsic-size render.
+func (p *Processor) rasterVariants() []rasterVariant {
+	variants := make([]rasterVariant, 0, len(p.cfg.Raster.Presets)+1)
+	for name, longEdge := range p.cfg.Raster.Presets {
+		variants = append(variants, rasterVariant{name: name, longEdge: longEdge})
+	}
+	sort.Slice(variants, func(i, j int) bool {
+		return variants[i].longEdge < variants[j].longEdge
+	})
+	return append(variants, rasterVariant{name: originalVariant})
+}
@@ -43,10 +43,12 @@ function generate_default_config() {
   local minio_secret_key
   minio_secret_key="$(random_hex 16)"
 
-  local jwt_access_secret jwt_refresh_secret signature_secret
+  local jwt_access_secret jwt_refresh_secret signature_secret action_token_secret
   jwt_access_secret="$(random_base64 48)"
   jwt_refresh_secret="$(random_base64 48)"
   signature_secret="$(random_base64 48)"
+  action_token_secret="$(random_base64 48)"
+  mfa_secret_key="$(random_base64 48)"
 
   cat >"${CONFIG_FILE}" <<EOF
 # 自动生成的默认配置，如需自定义请编辑此文件并重新运行脚本。
@@ -56,6 +58,8 @@ MINIO_SECRET_KEY=${minio_secret_key}
 JWT_ACCESS_SECRET=${jwt_access_secret}
 JWT_REFRESH_SECRET=${jwt_refresh_secret}
 SIGNATURE_SECRET=${signature_secret}
+ACTION_TOKEN_SECRET=${action_token_secret}
+MFA_SECRET_KEY=${mfa_secret_key}
 FRONTEND_PORT=4173
 API_PORT=8080
 REDIS_STREAM=media:ingest
@@ -80,6 +84,13 @@ function load_config() {
   : "${JWT_ACCESS_SECRET:?JWT_ACCESS_SECRET 未设置}"
   : "${JWT_REFRESH_SECRET:?JWT_REFRESH_SECRET 未设置}"
   : "${SIGNATURE_SECRET:?SIGNATURE_SECRET 未设置}"
+  # 旧版 config.env 没有该项：临时生成，重新运行脚本会使未使用的邮件链接失效
+  : "${ACTION_TOKEN_SECRET:=$(random_base64 48)}"
+  # 该密钥加密已绑定的 TOTP 密钥，必须持久化，旧版 config.env 缺失时追加写入
+  if [[ -z "${MFA_SECRET_KEY:-}" ]]; then
+    MFA_SECRET_KEY="$(random_base64 48)"
+    echo "MFA_SECRET_KEY=${MFA_SECRET_KEY}" >>"${CONFIG_FILE}"
+  fi
   : "${FRONTEND_PORT:=4173}"
   : "${API_PORT:=8080}"
   : "${REDIS_STREAM:=media:ingest}"
@@ -227,7 +238,10 @@ security:
   jwtAccessTTL: 15m
   jwtRefreshTTL: 720h
   signatureSecret: ${SIGNATURE_SECRET}
+  actionTokenSecret: ${ACTION_TOKEN_SECRET}
   maxSessions: 10
+  mfa:
+    secretKey: ${MFA_SECRET_KEY}
 
 nsfw:
   modelPath: ./models/nsfw_model.onnx